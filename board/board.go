@@ -0,0 +1,104 @@
+// Package board keeps the order of tasks inside the columns of a project
+// board. A column is a task status; its settings row in board_column is
+// locked by every write that ranks a task into it, so ranks computed in
+// the same column see each other.
+package board
+
+import (
+	"errors"
+
+	"github.com/Anwarjondev/task-management-api/lexorank"
+	"github.com/Anwarjondev/task-management-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRankExhausted is returned when no short enough rank fits where a task
+// is placed, even after the column was respread.
+var ErrRankExhausted = errors.New("board: no rank left at this position")
+
+// AddColumn creates the settings row of a column unless the column was
+// configured before.
+func AddColumn(tx *gorm.DB, projectID, status string) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.BoardColumn{ProjectID: projectID, Status: status}).Error
+}
+
+// Lock locks the settings row of a column until tx ends, creating it first
+// if the column was never configured.
+func Lock(tx *gorm.DB, projectID, status string) (models.BoardColumn, error) {
+	var column models.BoardColumn
+	if err := AddColumn(tx, projectID, status); err != nil {
+		return column, err
+	}
+	// column is loaded fresh: a primary key left by the insert would be
+	// added to the conditions and miss the row that was already there.
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&column, "project_id = ? and status = ?", projectID, status).Error
+	return column, err
+}
+
+// EndRank returns a rank that places a task last in its column. excludeID,
+// when set, is a task being moved that does not count. tx must hold the
+// column's Lock.
+func EndRank(tx *gorm.DB, projectID, status, excludeID string) (string, error) {
+	return Place(tx, projectID, status, excludeID, func() (string, error) {
+		var last models.Task
+		err := ranked(tx, projectID, status, excludeID).
+			Order(`rank collate "C" desc`).Limit(1).Find(&last).Error
+		if err != nil {
+			return "", err
+		}
+		return lexorank.Between(last.Rank, "")
+	})
+}
+
+// Place returns the rank compute finds for a task in a column. When that
+// rank is longer than lexorank.MaxLength, or the neighbours leave no room,
+// the other tasks of the column are respread and compute runs again, so it
+// must read the neighbours' ranks each time. tx must hold the column's
+// Lock.
+func Place(tx *gorm.DB, projectID, status, excludeID string, compute func() (string, error)) (string, error) {
+	rank, err := compute()
+	if err == nil && len(rank) <= lexorank.MaxLength {
+		return rank, nil
+	}
+	if err != nil && !errors.Is(err, lexorank.ErrInvalidRange) {
+		return "", err
+	}
+	if err := respread(tx, projectID, status, excludeID); err != nil {
+		return "", err
+	}
+	rank, err = compute()
+	if errors.Is(err, lexorank.ErrInvalidRange) || err == nil && len(rank) > lexorank.MaxLength {
+		return "", ErrRankExhausted
+	}
+	return rank, err
+}
+
+// respread gives the ranked tasks of a column evenly spaced short ranks in
+// their current order. Rank is part of a task, so their versions move on.
+func respread(tx *gorm.DB, projectID, status, excludeID string) error {
+	var ids []string
+	err := ranked(tx, projectID, status, excludeID).
+		Order(`rank collate "C", id`).Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for i, rank := range lexorank.Spread(len(ids)) {
+		if err := tx.Model(&models.Task{}).Where("id = ?", ids[i]).Update("rank", rank).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ranked selects the tasks of a column that have a rank, without
+// excludeID.
+func ranked(tx *gorm.DB, projectID, status, excludeID string) *gorm.DB {
+	query := tx.Model(&models.Task{}).Where("project_id = ? and status = ? and rank <> ''", projectID, status)
+	if excludeID != "" {
+		query = query.Where("id <> ?", excludeID)
+	}
+	return query
+}
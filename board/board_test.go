@@ -0,0 +1,109 @@
+package board_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Anwarjondev/task-management-api/board"
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/lexorank"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// useTestDatabase connects to TEST_DATABASE_DSN, a Postgres database the
+// test may fill, and skips the test when it is not set.
+func useTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = conn
+	t.Cleanup(func() { db.DB = previous })
+	conn.Exec(`create extension if not exists "uuid-ossp"`)
+	db.AutoMigrate()
+	return conn
+}
+
+// TestRanksStayShort places tasks at the end of a column and always right
+// after the first one, the two patterns that make ranks grow, and checks
+// that the column is respread before a rank gets too long.
+func TestRanksStayShort(t *testing.T) {
+	conn := useTestDatabase(t)
+	owner := models.User{Username: "rank-" + uuid.New().String()[:8], Role: "team_member"}
+	if err := conn.Create(&owner).Error; err != nil {
+		t.Fatal(err)
+	}
+	project := models.Project{Name: "ranks", OwnerID: owner.ID}
+	if err := conn.Create(&project).Error; err != nil {
+		t.Fatal(err)
+	}
+	projectID := project.ID
+	t.Cleanup(func() {
+		conn.Where("project_id = ?", projectID).Delete(&models.Task{})
+		conn.Where("project_id = ?", projectID).Delete(&models.BoardColumn{})
+		conn.Delete(&project)
+		conn.Delete(&owner)
+	})
+
+	var first models.Task
+	for i := 0; i < 300; i++ {
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if _, err := board.Lock(tx, projectID, "pending"); err != nil {
+				return err
+			}
+			var rank string
+			var err error
+			if i%2 == 0 || first.ID == "" {
+				rank, err = board.EndRank(tx, projectID, "pending", "")
+			} else {
+				rank, err = board.Place(tx, projectID, "pending", "", func() (string, error) {
+					var head, next models.Task
+					tx.Where("id = ?", first.ID).First(&head)
+					tx.Where(`project_id = ? and status = 'pending' and rank collate "C" > ?`, projectID, head.Rank).
+						Order(`rank collate "C"`).Limit(1).Find(&next)
+					return lexorank.Between(head.Rank, next.Rank)
+				})
+			}
+			if err != nil {
+				return err
+			}
+			task := models.Task{Title: "rank", Status: "pending", ProjectID: projectID, CreatorID: owner.ID, Rank: rank}
+			if err := tx.Create(&task).Error; err != nil {
+				return err
+			}
+			if first.ID == "" {
+				first = task
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("placing task %d: %v", i, err)
+		}
+	}
+
+	var ranks []string
+	conn.Model(&models.Task{}).Where("project_id = ?", projectID).Order(`rank collate "C"`).Pluck("rank", &ranks)
+	if len(ranks) != 300 {
+		t.Fatalf("got %d tasks", len(ranks))
+	}
+	for i, rank := range ranks {
+		if len(rank) > lexorank.MaxLength {
+			t.Fatalf("rank %q is longer than %d", rank, lexorank.MaxLength)
+		}
+		if i > 0 && ranks[i-1] == rank {
+			t.Fatalf("rank %q is used twice", rank)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/Anwarjondev/task-management-api/board"
 	"github.com/Anwarjondev/task-management-api/models"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
@@ -44,9 +45,175 @@ func AutoMigrate() {
 		&models.Project{},
 		&models.Subtask{},
 		&models.Task{},
+		&models.Attachment{},
+		&models.WorkLog{},
+		&models.Timer{},
+		&models.TaskTemplate{},
+		&models.Label{},
+		&models.Milestone{},
+		&models.Sprint{},
+		&models.BoardColumn{},
+		&models.SavedView{},
+		&models.Comment{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.OutboxEvent{},
+		&models.OutboxKey{},
+		&models.Notification{},
+		&models.NotificationPreference{},
+		&models.EmailSettings{},
+		&models.InboundAddress{},
+		&models.Watch{},
+		&models.TaskActivity{},
 	)
 	if err != nil {
 		panic("Failed to migrate database")
 	}
+	err = migrateSearch()
+	if err != nil {
+		panic("Failed to migrate search columns: " + err.Error())
+	}
+	err = migrateVersions()
+	if err != nil {
+		panic("Failed to migrate version triggers: " + err.Error())
+	}
+	err = migrateRanks()
+	if err != nil {
+		panic("Failed to rank tasks: " + err.Error())
+	}
+	err = migrateOutboxKeys()
+	if err != nil {
+		panic("Failed to number outbox events: " + err.Error())
+	}
+	err = migratePublishSeq()
+	if err != nil {
+		panic("Failed to number published outbox events: " + err.Error())
+	}
 	log.Println("Database migrated Successfully")
+}
+
+// versionedTables are the tables whose version column counts changes. A
+// trigger starts it at 1 on insert and adds one on every update, so no
+// write can leave it behind.
+var versionedTables = []string{
+	"user", "project", "task", "subtask", "comment", "attachment", "work_log",
+	"label", "milestone", "sprint", "webhook", "saved_view", "task_template",
+	"board_column", "inbound_address",
+}
+
+// migrateVersions installs the version trigger on versionedTables.
+func migrateVersions() error {
+	err := DB.Exec(`create or replace function bump_version() returns trigger as $$
+begin
+	if tg_op = 'INSERT' then
+		new.version := 1;
+	else
+		new.version := old.version + 1;
+	end if;
+	return new;
+end
+$$ language plpgsql`).Error
+	if err != nil {
+		return err
+	}
+	for _, table := range versionedTables {
+		err := DB.Exec(fmt.Sprintf(`drop trigger if exists %s_version on "%s"`, table, table)).Error
+		if err != nil {
+			return err
+		}
+		err = DB.Exec(fmt.Sprintf(`create trigger %s_version before insert or update on "%s" for each row execute function bump_version()`, table, table)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateOutboxKeys numbers outbox events recorded before per-key
+// sequences in Seq order, after the numbered events of their key, and
+// moves the counters in outbox_key past them.
+func migrateOutboxKeys() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`update outbox_event set key_seq = numbered.key_seq
+from (
+	select id, coalesce((select max(key_seq) from outbox_event done where done.key = e.key), 0)
+		+ row_number() over (partition by key order by seq) as key_seq
+	from outbox_event e where key_seq = 0
+) numbered
+where outbox_event.id = numbered.id`).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec(`insert into outbox_key (key, seq)
+select key, max(key_seq) from outbox_event group by key
+on conflict (key) do update set seq = greatest(outbox_key.seq, excluded.seq)`).Error
+	})
+}
+
+// migratePublishSeq creates the sequence behind outbox_event.publish_seq.
+// Events published before it existed keep their seq as publish_seq, so
+// the event ids stream clients already hold stay valid.
+func migratePublishSeq() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("create sequence if not exists outbox_publish_seq").Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("update outbox_event set publish_seq = seq where published_at is not null and publish_seq is null").Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec(`select setval('outbox_publish_seq', greatest(
+	(select coalesce(max(publish_seq), 0) from outbox_event),
+	(select last_value from outbox_publish_seq)) + 1, false)`).Error
+	})
+}
+
+// migrateRanks gives tasks created before board ordering a rank after the
+// ranked tasks of their column, oldest first. It runs once per unranked
+// task, so moves never need to rewrite a column. It runs before the server
+// takes requests, so it does not lock the columns.
+func migrateRanks() error {
+	var tasks []models.Task
+	err := DB.Select("id", "project_id", "status").Where("rank = '' or rank is null").Order("created_at, id").Find(&tasks).Error
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		rank, err := board.EndRank(DB, task.ProjectID, task.Status, task.ID)
+		if err != nil {
+			return err
+		}
+		if err := DB.Model(&models.Task{}).Where("id = ?", task.ID).Update("rank", rank).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchColumns are the generated tsvector columns behind full-text search.
+// Titles weigh more than descriptions. The 'simple' configuration does no
+// stemming, so it treats English, Russian and Uzbek text alike.
+var searchColumns = []struct{ table, document string }{
+	{"project", "setweight(to_tsvector('simple', coalesce(name, '')), 'A') || setweight(to_tsvector('simple', coalesce(description, '')), 'B')"},
+	{"task", "setweight(to_tsvector('simple', coalesce(title, '')), 'A') || setweight(to_tsvector('simple', coalesce(description, '')), 'B')"},
+	{"subtask", "setweight(to_tsvector('simple', coalesce(title, '')), 'A')"},
+	{"comment", "setweight(to_tsvector('simple', coalesce(body, '')), 'B')"},
+}
+
+// migrateSearch adds a search column and a GIN index on it to every table
+// in searchColumns. The columns are maintained by Postgres, so the models
+// do not map them.
+func migrateSearch() error {
+	for _, c := range searchColumns {
+		err := DB.Exec(fmt.Sprintf("alter table %s add column if not exists search tsvector generated always as (%s) stored", c.table, c.document)).Error
+		if err != nil {
+			return err
+		}
+		err = DB.Exec(fmt.Sprintf("create index if not exists idx_%s_search on %s using gin (search)", c.table, c.table)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,153 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxBackoff caps the wait between attempts to publish an event.
+	maxBackoff = time.Hour
+	// Retention is how long published events are kept in the outbox.
+	Retention = 7 * 24 * time.Hour
+	// Channel is the Postgres notification channel on which the
+	// PublishSeq of every published event is announced.
+	Channel = "outbox_event"
+	// publishLock is the advisory lock serializing the stamping of
+	// PublishSeq.
+	publishLock = 0x6f7574626f78
+)
+
+// Sink receives published events.
+type Sink interface {
+	Publish(event models.OutboxEvent) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(event models.OutboxEvent) error
+
+func (f SinkFunc) Publish(event models.OutboxEvent) error {
+	return f(event)
+}
+
+var (
+	mu    sync.RWMutex
+	sinks = map[string]Sink{}
+)
+
+// Register adds a sink under name, replacing any sink of that name.
+func Register(name string, sink Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sinks[name] = sink
+}
+
+// Start runs the dispatcher every interval until ctx is cancelled.
+func Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := RunOnce(time.Now().UTC()); err != nil {
+			log.Println("Event dispatcher failed:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce publishes every event that is due at now and then drops events
+// published longer than Retention ago. Each event is handled in its own
+// transaction holding a row lock taken with SKIP LOCKED. An event is only
+// picked once no event of its key with a lower KeySeq is left unpublished,
+// so a key's events never overtake each other, even across replicas.
+// KeySeq rather than Seq decides: Seq is drawn from a sequence and a
+// transaction holding a lower Seq may commit later.
+func RunOnce(now time.Time) error {
+	for {
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			return publishNext(tx, now)
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return db.DB.Where("published_at < ?", now.Add(-Retention)).Delete(&models.OutboxEvent{}).Error
+}
+
+func publishNext(tx *gorm.DB, now time.Time) error {
+	var event models.OutboxEvent
+	err := tx.Clauses(clause.Locking{
+		Strength: "UPDATE",
+		Options:  "SKIP LOCKED",
+	}).
+		Where("published_at is null and next_attempt_at <= ?", now).
+		Where("not exists (select 1 from outbox_event earlier where earlier.key = outbox_event.key " +
+			"and earlier.published_at is null and earlier.key_seq < outbox_event.key_seq)").
+		Order("seq").
+		First(&event).Error
+	if err != nil {
+		return err
+	}
+
+	err = publish(event)
+	if err != nil {
+		event.Attempts++
+		wait := min(time.Second<<min(event.Attempts, 12), maxBackoff)
+		log.Printf("Publishing event %d (%s) failed, retrying in %s: %v", event.Seq, event.Type, wait, err)
+		return tx.Model(&event).Updates(map[string]any{
+			"attempts":        event.Attempts,
+			"last_error":      err.Error(),
+			"next_attempt_at": now.Add(wait),
+		}).Error
+	}
+	return markPublished(tx, &event, now)
+}
+
+// markPublished stamps event with the next PublishSeq and announces it on
+// Channel. The advisory lock is held until tx commits, so events become
+// visible in PublishSeq order, which a sequence alone does not ensure and a reader that saw one has seen every
+// lower one; notifications are delivered on commit, in the same order.
+func markPublished(tx *gorm.DB, event *models.OutboxEvent, now time.Time) error {
+	if err := tx.Exec("select pg_advisory_xact_lock(?)", publishLock).Error; err != nil {
+		return err
+	}
+	var seq int64
+	err := tx.Raw("select nextval('outbox_publish_seq')").Scan(&seq).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(event).Updates(map[string]any{"published_at": now, "publish_seq": seq, "last_error": ""}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Exec("select pg_notify(?, ?)", Channel, strconv.FormatInt(seq, 10)).Error
+}
+
+// publish hands event to every sink and joins their errors.
+func publish(event models.OutboxEvent) error {
+	mu.RLock()
+	defer mu.RUnlock()
+	var errs []error
+	for name, sink := range sinks {
+		if err := sink.Publish(event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
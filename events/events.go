@@ -0,0 +1,159 @@
+// Package events implements a transactional outbox for domain events.
+//
+// Handlers call Record with the transaction of their change, so an event
+// exists exactly when the change committed. The dispatcher started by
+// Start publishes stored events to every registered Sink at least once;
+// sinks must tolerate seeing an event twice and can use its ID to notice.
+// Events sharing a Key are published one at a time in the order their
+// transactions committed. Every published event is announced on Channel.
+package events
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"github.com/Anwarjondev/task-management-api/models"
+	"gorm.io/gorm"
+)
+
+// Event types.
+const (
+	ProjectCreated     = "project.created"
+	ProjectUpdated     = "project.updated"
+	ProjectDeleted     = "project.deleted"
+	ProjectMemberAdded = "project.member_added"
+
+	TaskCreated       = "task.created"
+	TaskUpdated       = "task.updated"
+	TaskStatusChanged = "task.status_changed"
+	TaskAssigned      = "task.assigned"
+	TaskLabelsChanged = "task.labels_changed"
+	TaskDeleted       = "task.deleted"
+
+	SubtaskCreated   = "subtask.created"
+	SubtaskUpdated   = "subtask.updated"
+	SubtaskCompleted = "subtask.completed"
+	SubtaskAssigned  = "subtask.assigned"
+	SubtaskDeleted   = "subtask.deleted"
+
+	CommentCreated = "comment.created"
+	CommentDeleted = "comment.deleted"
+
+	AttachmentCreated = "attachment.created"
+	AttachmentDeleted = "attachment.deleted"
+
+	LabelCreated = "label.created"
+	LabelUpdated = "label.updated"
+	LabelDeleted = "label.deleted"
+
+	MilestoneCreated  = "milestone.created"
+	MilestoneUpdated  = "milestone.updated"
+	MilestoneClosed   = "milestone.closed"
+	MilestoneReopened = "milestone.reopened"
+	MilestoneDeleted  = "milestone.deleted"
+
+	SprintCreated = "sprint.created"
+	SprintUpdated = "sprint.updated"
+	SprintStarted = "sprint.started"
+	SprintClosed  = "sprint.closed"
+	SprintDeleted = "sprint.deleted"
+
+	BoardColumnUpdated = "board.column_updated"
+
+	TemplateCreated = "template.created"
+	TemplateUpdated = "template.updated"
+	TemplateDeleted = "template.deleted"
+
+	WorkLogCreated = "worklog.created"
+	WorkLogDeleted = "worklog.deleted"
+	TimerStarted   = "timer.started"
+	TimerStopped   = "timer.stopped"
+
+	UserRegistered = "user.registered"
+	UserUpdated    = "user.updated"
+	UserDeleted    = "user.deleted"
+
+	ViewCreated = "view.created"
+	ViewUpdated = "view.updated"
+	ViewDeleted = "view.deleted"
+
+	WebhookCreated = "webhook.created"
+	WebhookUpdated = "webhook.updated"
+	WebhookDeleted = "webhook.deleted"
+)
+
+// Event is a domain event before it is stored. Key is the ordering key,
+// see Key. ProjectID and ActorID may be empty.
+type Event struct {
+	Type      string
+	Key       string
+	ProjectID string
+	ActorID   string
+	Data      any
+}
+
+// Key returns the ordering key of an aggregate, e.g. Key("task", id).
+// Children of a task such as subtasks and comments use the task's key so
+// that a task's history is published in order.
+func Key(aggregate, id string) string {
+	return aggregate + ":" + id
+}
+
+// Record stores events in the outbox using tx, which should be the
+// transaction of the change the events describe. It numbers the events of
+// each key through the key's OutboxKey row, which stays locked until tx
+// ends.
+func Record(tx *gorm.DB, events ...Event) error {
+	counts := map[string]int64{}
+	for _, e := range events {
+		counts[e.Key]++
+	}
+	// Keys are locked in a fixed order so that two calls recording the
+	// same keys cannot deadlock.
+	keys := slices.Sorted(maps.Keys(counts))
+	next := map[string]int64{}
+	for _, key := range keys {
+		last, err := advanceKey(tx, key, counts[key])
+		if err != nil {
+			return err
+		}
+		next[key] = last - counts[key] + 1
+	}
+
+	for _, e := range events {
+		payload, err := json.Marshal(e.Data)
+		if err != nil {
+			return err
+		}
+		row := models.OutboxEvent{
+			Type:      e.Type,
+			Key:       e.Key,
+			KeySeq:    next[e.Key],
+			ProjectID: optional(e.ProjectID),
+			ActorID:   optional(e.ActorID),
+			Payload:   string(payload),
+		}
+		next[e.Key]++
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advanceKey adds n to the counter of key and returns its new value.
+func advanceKey(tx *gorm.DB, key string, n int64) (int64, error) {
+	var last int64
+	err := tx.Raw(`insert into outbox_key (key, seq) values (?, ?)
+		on conflict (key) do update set seq = outbox_key.seq + excluded.seq
+		returning seq`, key, n).Scan(&last).Error
+	return last, err
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,250 @@
+package filter
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Type is the type of a filterable field. It decides which operators and
+// values a field accepts.
+type Type int
+
+const (
+	// String fields support =, !=, ~ (contains, case-insensitive) and in.
+	String Type = iota
+	// Enum fields support =, != and in with a value from Field.Values.
+	Enum
+	// Number fields support every comparison and in.
+	Number
+	// Date fields support every comparison with YYYY-MM-DD or RFC 3339. A
+	// YYYY-MM-DD value stands for the whole day, so due=2026-11-01 matches
+	// any time on that day.
+	Date
+	// User fields hold a user id; "me" is the current user, "none" no user.
+	User
+	// Ref fields hold the id of a related row; "none" matches no row.
+	Ref
+	// Has fields only support ":", e.g. label:bug. Field.Has is the SQL
+	// condition, with a single ? for the value.
+	Has
+)
+
+// Field maps a filter field to SQL.
+type Field struct {
+	Column string
+	Type   Type
+	Values []string
+	Has    string
+}
+
+// Schema lists the filterable fields of a resource by name.
+type Schema map[string]Field
+
+// Env carries the request dependent values of a compilation.
+type Env struct {
+	UserID string
+}
+
+var operators = map[Type][]string{
+	String: {"=", "!=", "~", "in", "not in"},
+	Enum:   {"=", "!=", "in", "not in"},
+	Number: {"=", "!=", "<", "<=", ">", ">=", "in", "not in"},
+	Date:   {"=", "!=", "<", "<=", ">", ">="},
+	User:   {"=", "!=", "in", "not in"},
+	Ref:    {"=", "!=", "in", "not in"},
+	Has:    {":"},
+}
+
+// Check reports the first unknown field, unsupported operator or invalid
+// value of node without building SQL.
+func Check(node Node, schema Schema) error {
+	_, _, err := Compile(node, schema, Env{UserID: uuid.Nil.String()})
+	return err
+}
+
+// Compile turns node into a SQL condition with ? placeholders and its
+// arguments.
+func Compile(node Node, schema Schema, env Env) (string, []any, error) {
+	c := &compiler{schema: schema, env: env}
+	sql, err := c.compile(node)
+	return sql, c.args, err
+}
+
+type compiler struct {
+	schema Schema
+	env    Env
+	args   []any
+}
+
+func (c *compiler) compile(node Node) (string, error) {
+	switch n := node.(type) {
+	case And:
+		return c.binary(n.Left, n.Right, " and ")
+	case Or:
+		return c.binary(n.Left, n.Right, " or ")
+	case Not:
+		x, err := c.compile(n.X)
+		if err != nil {
+			return "", err
+		}
+		return "not " + x, nil
+	case *Comparison:
+		return c.comparison(n)
+	}
+	return "", &Error{Kind: KindSyntax, Value: "expression"}
+}
+
+func (c *compiler) binary(left, right Node, op string) (string, error) {
+	l, err := c.compile(left)
+	if err != nil {
+		return "", err
+	}
+	r, err := c.compile(right)
+	if err != nil {
+		return "", err
+	}
+	return "(" + l + op + r + ")", nil
+}
+
+func (c *compiler) comparison(cmp *Comparison) (string, error) {
+	field, ok := c.schema[cmp.Field]
+	if !ok {
+		return "", &Error{Kind: KindUnknownField, Pos: cmp.Pos, Field: cmp.Field}
+	}
+	if !contains(operators[field.Type], cmp.Op) {
+		return "", &Error{Kind: KindInvalidOperator, Pos: cmp.Pos, Field: cmp.Field, Op: cmp.Op}
+	}
+
+	values := make([]any, 0, len(cmp.Values))
+	none := false
+	for _, raw := range cmp.Values {
+		if (field.Type == User || field.Type == Ref) && raw == "none" {
+			none = true
+			continue
+		}
+		value, err := c.value(field, raw)
+		if err != nil {
+			err.Pos, err.Field = cmp.Pos, cmp.Field
+			return "", err
+		}
+		values = append(values, value)
+	}
+
+	col := field.Column
+	switch cmp.Op {
+	case ":":
+		c.args = append(c.args, values[0])
+		return "(" + field.Has + ")", nil
+	case "~":
+		c.args = append(c.args, "%"+escapeLike(values[0].(string))+"%")
+		return col + " ilike ?", nil
+	case "in", "not in":
+		var parts []string
+		if len(values) > 0 {
+			c.args = append(c.args, values)
+			parts = append(parts, col+" in ?")
+		}
+		if none {
+			parts = append(parts, col+" is null")
+		}
+		sql := "(" + strings.Join(parts, " or ") + ")"
+		if cmp.Op == "not in" {
+			sql = "not " + sql
+		}
+		return sql, nil
+	}
+
+	if none {
+		if cmp.Op == "=" {
+			return col + " is null", nil
+		}
+		return col + " is not null", nil
+	}
+	if d, ok := values[0].(day); ok {
+		return c.dayComparison(col, cmp.Op, time.Time(d)), nil
+	}
+	op := cmp.Op
+	if op == "!=" {
+		op = "<>"
+	}
+	c.args = append(c.args, values[0])
+	return col + " " + op + " ?", nil
+}
+
+// day is a date value without a time, the day starting at that instant.
+type day time.Time
+
+// dayComparison compares col with the whole day starting at start.
+func (c *compiler) dayComparison(col, op string, start time.Time) string {
+	end := start.AddDate(0, 0, 1)
+	switch op {
+	case "=":
+		c.args = append(c.args, start, end)
+		return "(" + col + " >= ? and " + col + " < ?)"
+	case "!=":
+		c.args = append(c.args, start, end)
+		return "(" + col + " < ? or " + col + " >= ?)"
+	case "<":
+		c.args = append(c.args, start)
+		return col + " < ?"
+	case "<=":
+		c.args = append(c.args, end)
+		return col + " < ?"
+	case ">":
+		c.args = append(c.args, end)
+		return col + " >= ?"
+	}
+	c.args = append(c.args, start)
+	return col + " >= ?"
+}
+
+func (c *compiler) value(field Field, raw string) (any, *Error) {
+	switch field.Type {
+	case Enum:
+		if !contains(field.Values, raw) {
+			return nil, &Error{Kind: KindInvalidValue, Value: raw, Expected: "one of " + strings.Join(field.Values, ", ")}
+		}
+	case Number:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, &Error{Kind: KindInvalidValue, Value: raw, Expected: "a whole number"}
+		}
+		return n, nil
+	case Date:
+		if t, err := time.Parse("2006-01-02", raw); err == nil {
+			return day(t), nil
+		}
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		return nil, &Error{Kind: KindInvalidValue, Value: raw, Expected: "a date (YYYY-MM-DD)"}
+	case User:
+		if raw == "me" {
+			return c.env.UserID, nil
+		}
+		if uuid.Validate(raw) != nil {
+			return nil, &Error{Kind: KindInvalidValue, Value: raw, Expected: "me, none or a user id"}
+		}
+	case Ref:
+		if uuid.Validate(raw) != nil {
+			return nil, &Error{Kind: KindInvalidValue, Value: raw, Expected: "none or an id"}
+		}
+	}
+	return raw, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
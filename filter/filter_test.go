@@ -0,0 +1,193 @@
+package filter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testSchema = Schema{
+	"status":   {Column: "task.status", Type: Enum, Values: []string{"pending", "in_progress", "completed"}},
+	"title":    {Column: "task.title", Type: String},
+	"estimate": {Column: "task.estimate_minutes", Type: Number},
+	"due":      {Column: "task.due_date", Type: Date},
+	"assignee": {Column: "task.assignee_id", Type: User},
+	"sprint":   {Column: "task.sprint_id", Type: Ref},
+	"label":    {Type: Has, Has: "exists (select 1 from label where lower(label.name) = lower(?))"},
+}
+
+const (
+	me     = "7f6c0b8e-1c1e-4c55-9d7e-3f1f0c2a9b10"
+	sprint = "0b9d6a4e-5a7c-4f3e-8d1b-2c6e9f0a1b2c"
+)
+
+func cmp(field, op string, pos int, values ...string) *Comparison {
+	return &Comparison{Field: field, Op: op, Values: values, Pos: pos}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Node
+	}{
+		{"status=pending", cmp("status", "=", 1, "pending")},
+		{"Status = 'in progress'", cmp("status", "=", 1, "in progress")},
+		{`title~"a \"b\""`, cmp("title", "~", 1, `a "b"`)},
+		{"due<=2026-11-01", cmp("due", "<=", 1, "2026-11-01")},
+		{"label:bug", cmp("label", ":", 1, "bug")},
+		{"status in (pending, in_progress)", cmp("status", "in", 1, "pending", "in_progress")},
+		{"status NOT IN (completed)", cmp("status", "not in", 1, "completed")},
+		{
+			"a=1 or b=2 and c=3",
+			Or{cmp("a", "=", 1, "1"), And{cmp("b", "=", 8, "2"), cmp("c", "=", 16, "3")}},
+		},
+		{
+			"(a=1 or b=2) and not c=3",
+			And{Or{cmp("a", "=", 2, "1"), cmp("b", "=", 9, "2")}, Not{cmp("c", "=", 22, "3")}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Error
+	}{
+		{"", Error{Kind: KindSyntax, Pos: 1, Value: "end of filter"}},
+		{"status=", Error{Kind: KindSyntax, Pos: 8, Value: "end of filter"}},
+		{"status pending", Error{Kind: KindSyntax, Pos: 8, Value: `"pending"`}},
+		{"status=pending)", Error{Kind: KindSyntax, Pos: 15, Value: `")"`}},
+		{"(status=pending", Error{Kind: KindSyntax, Pos: 16, Value: "end of filter"}},
+		{"status in (pending", Error{Kind: KindSyntax, Pos: 19, Value: "end of filter"}},
+		{"status not pending", Error{Kind: KindSyntax, Pos: 12, Value: `"pending"`}},
+		{"title='open", Error{Kind: KindSyntax, Pos: 7, Value: "end of filter"}},
+		{"status!pending", Error{Kind: KindSyntax, Pos: 7, Value: `"!"`}},
+		{"status=#", Error{Kind: KindSyntax, Pos: 8, Value: `"#"`}},
+		{"and=1", Error{Kind: KindSyntax, Pos: 1, Value: `"and"`}},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+		var got *Error
+		if !errors.As(err, &got) {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.input, err, tt.want)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("Parse(%q) error = %+v, want %+v", tt.input, *got, tt.want)
+		}
+	}
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestCompile(t *testing.T) {
+	may1, may2 := date("2024-05-01"), date("2024-05-02")
+	tests := []struct {
+		input string
+		sql   string
+		args  []any
+	}{
+		{"status=pending", "task.status = ?", []any{"pending"}},
+		{"status!=pending", "task.status <> ?", []any{"pending"}},
+		{"status in (pending,completed)", "(task.status in ?)", []any{[]any{"pending", "completed"}}},
+		{"status not in (completed)", "not (task.status in ?)", []any{[]any{"completed"}}},
+		{`title~"50%_off"`, "task.title ilike ?", []any{`%50\%\_off%`}},
+		{"estimate>=30", "task.estimate_minutes >= ?", []any{30}},
+		{"due=2024-05-01", "(task.due_date >= ? and task.due_date < ?)", []any{may1, may2}},
+		{"due!=2024-05-01", "(task.due_date < ? or task.due_date >= ?)", []any{may1, may2}},
+		{"due<2024-05-01", "task.due_date < ?", []any{may1}},
+		{"due<=2024-05-01", "task.due_date < ?", []any{may2}},
+		{"due>2024-05-01", "task.due_date >= ?", []any{may2}},
+		{"due>=2024-05-01", "task.due_date >= ?", []any{may1}},
+		{`due<"2024-05-01T12:00:00Z"`, "task.due_date < ?", []any{may1.Add(12 * time.Hour)}},
+		{"assignee=me", "task.assignee_id = ?", []any{me}},
+		{"assignee=none", "task.assignee_id is null", nil},
+		{"assignee!=none", "task.assignee_id is not null", nil},
+		{"assignee in (me, none)", "(task.assignee_id in ? or task.assignee_id is null)", []any{[]any{me}}},
+		{"sprint=" + sprint, "task.sprint_id = ?", []any{sprint}},
+		{"label:Bug", "(exists (select 1 from label where lower(label.name) = lower(?)))", []any{"Bug"}},
+		{
+			"status=pending and (assignee=me or not label:bug)",
+			"(task.status = ? and (task.assignee_id = ? or not (exists (select 1 from label where lower(label.name) = lower(?)))))",
+			[]any{"pending", me, "bug"},
+		},
+	}
+	for _, tt := range tests {
+		node, err := Parse(tt.input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.input, err)
+		}
+		sql, args, err := Compile(node, testSchema, Env{UserID: me})
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.input, err)
+			continue
+		}
+		if sql != tt.sql || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Compile(%q) = %q %v, want %q %v", tt.input, sql, args, tt.sql, tt.args)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Error
+	}{
+		{"priority=high", Error{Kind: KindUnknownField, Pos: 1, Field: "priority"}},
+		{"title=a and owner=me", Error{Kind: KindUnknownField, Pos: 13, Field: "owner"}},
+		{"status~pend", Error{Kind: KindInvalidOperator, Pos: 1, Field: "status", Op: "~"}},
+		{"due in (2024-05-01)", Error{Kind: KindInvalidOperator, Pos: 1, Field: "due", Op: "in"}},
+		{"label=bug", Error{Kind: KindInvalidOperator, Pos: 1, Field: "label", Op: "="}},
+		{"status=done", Error{Kind: KindInvalidValue, Pos: 1, Field: "status", Value: "done", Expected: "one of pending, in_progress, completed"}},
+		{"estimate>ten", Error{Kind: KindInvalidValue, Pos: 1, Field: "estimate", Value: "ten", Expected: "a whole number"}},
+		{"due=2024-13-01", Error{Kind: KindInvalidValue, Pos: 1, Field: "due", Value: "2024-13-01", Expected: "a date (YYYY-MM-DD)"}},
+		{"assignee=bob", Error{Kind: KindInvalidValue, Pos: 1, Field: "assignee", Value: "bob", Expected: "me, none or a user id"}},
+		{"sprint=me", Error{Kind: KindInvalidValue, Pos: 1, Field: "sprint", Value: "me", Expected: "none or an id"}},
+	}
+	for _, tt := range tests {
+		node, err := Parse(tt.input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.input, err)
+		}
+		err = Check(node, testSchema)
+		var got *Error
+		if !errors.As(err, &got) {
+			t.Errorf("Check(%q) error = %v, want %v", tt.input, err, tt.want)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("Check(%q) error = %+v, want %+v", tt.input, *got, tt.want)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	node, err := Parse("status=pending or (label:bug and not assignee=me)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range Fields(node) {
+		names = append(names, c.Field)
+	}
+	if want := []string{"status", "label", "assignee"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Fields = %v, want %v", names, want)
+	}
+}
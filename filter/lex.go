@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var keywords = map[string]bool{"and": true, "or": true, "not": true, "in": true}
+
+func isKeyword(text string) bool {
+	return keywords[strings.ToLower(text)]
+}
+
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+// isWordRune reports whether r may appear in an unquoted word. Dates such as
+// 2026-11-01 and identifiers such as in_progress are single words.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' || r == '@'
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", pos})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", pos})
+			i++
+		case r == '=' || r == '~' || r == ':':
+			tokens = append(tokens, token{tokenOp, string(r), pos})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &Error{Kind: KindSyntax, Pos: pos, Value: `"!"`}
+			}
+			tokens = append(tokens, token{tokenOp, op, pos})
+			i += len(op)
+		case r == '"' || r == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				b.WriteRune(runes[j])
+			}
+			if j == len(runes) {
+				return nil, &Error{Kind: KindSyntax, Pos: pos, Value: "end of filter"}
+			}
+			tokens = append(tokens, token{tokenString, b.String(), pos})
+			i = j + 1
+		case isWordRune(r):
+			j := i
+			for j < len(runes) && isWordRune(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[i:j]), pos})
+			i = j
+		default:
+			return nil, &Error{Kind: KindSyntax, Pos: pos, Value: fmt.Sprintf("%q", string(r))}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
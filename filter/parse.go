@@ -0,0 +1,258 @@
+// Package filter implements the filter expression language of list
+// endpoints, for example
+//
+//	status in (pending,in_progress) and assignee=me and due<2026-11-01 and label:bug
+//
+// An expression is parsed into an AST and compiled against a Schema into a
+// parameterized SQL condition. Field names and operators are checked
+// against the schema, values against the field type; user input only ever
+// reaches the database as a bind parameter.
+//
+// Grammar:
+//
+//	expr       = term { "or" term }
+//	term       = factor { "and" factor }
+//	factor     = "not" factor | "(" expr ")" | comparison
+//	comparison = field op value | field ["not"] "in" "(" value { "," value } ")"
+//	op         = "=" | "!=" | "<" | "<=" | ">" | ">=" | "~" | ":"
+//	value      = word | quoted string
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is an expression of the AST.
+type Node interface {
+	node()
+}
+
+// And matches when both sides match.
+type And struct{ Left, Right Node }
+
+// Or matches when either side matches.
+type Or struct{ Left, Right Node }
+
+// Not negates an expression.
+type Not struct{ X Node }
+
+// Comparison compares a field with one or more values. Op is one of the
+// comparison operators, "in" or "not in".
+type Comparison struct {
+	Field  string
+	Op     string
+	Values []string
+	Pos    int
+}
+
+func (And) node()         {}
+func (Or) node()          {}
+func (Not) node()         {}
+func (*Comparison) node() {}
+
+// Error kinds reported by Parse and Compile.
+const (
+	KindSyntax          = "syntax"
+	KindUnknownField    = "unknown_field"
+	KindInvalidOperator = "invalid_operator"
+	KindInvalidValue    = "invalid_value"
+)
+
+// Error describes why an expression was rejected. Pos is the 1-based
+// position in the expression.
+type Error struct {
+	Kind     string
+	Pos      int
+	Field    string
+	Op       string
+	Value    string
+	Expected string
+}
+
+func (e *Error) Error() string {
+	switch e.Kind {
+	case KindUnknownField:
+		return fmt.Sprintf("unknown field %q at position %d", e.Field, e.Pos)
+	case KindInvalidOperator:
+		return fmt.Sprintf("operator %q is not supported for %s", e.Op, e.Field)
+	case KindInvalidValue:
+		return fmt.Sprintf("invalid value %q for %s: expected %s", e.Value, e.Field, e.Expected)
+	}
+	return fmt.Sprintf("syntax error at position %d: unexpected %s", e.Pos, e.Value)
+}
+
+// Parse parses an expression into its AST.
+func Parse(input string) (Node, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.unexpected(tok)
+	}
+	return node, nil
+}
+
+// Fields returns every comparison of the AST, in order of appearance.
+func Fields(node Node) []*Comparison {
+	var out []*Comparison
+	var walk func(Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case And:
+			walk(n.Left)
+			walk(n.Right)
+		case Or:
+			walk(n.Left)
+			walk(n.Right)
+		case Not:
+			walk(n.X)
+		case *Comparison:
+			out = append(out, n)
+		}
+	}
+	walk(node)
+	return out
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) unexpected(tok token) error {
+	value := "end of filter"
+	if tok.kind != tokenEOF {
+		value = fmt.Sprintf("%q", tok.text)
+	}
+	return &Error{Kind: KindSyntax, Pos: tok.pos, Value: value}
+}
+
+func (p *parser) expr() (Node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("or") {
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) term() (Node, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("and") {
+		p.next()
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = And{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) factor() (Node, error) {
+	tok := p.peek()
+	switch {
+	case tok.isKeyword("not"):
+		p.next()
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return Not{x}, nil
+	case tok.kind == tokenLParen:
+		p.next()
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokenRParen {
+			return nil, p.unexpected(tok)
+		}
+		return x, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Node, error) {
+	field := p.next()
+	if field.kind != tokenWord || isKeyword(field.text) {
+		return nil, p.unexpected(field)
+	}
+	cmp := &Comparison{Field: strings.ToLower(field.text), Pos: field.pos}
+
+	tok := p.next()
+	switch {
+	case tok.kind == tokenOp:
+		cmp.Op = tok.text
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		cmp.Values = []string{value}
+		return cmp, nil
+	case tok.isKeyword("not"):
+		if in := p.next(); !in.isKeyword("in") {
+			return nil, p.unexpected(in)
+		}
+		cmp.Op = "not in"
+	case tok.isKeyword("in"):
+		cmp.Op = "in"
+	default:
+		return nil, p.unexpected(tok)
+	}
+
+	if tok := p.next(); tok.kind != tokenLParen {
+		return nil, p.unexpected(tok)
+	}
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		cmp.Values = append(cmp.Values, value)
+		tok := p.next()
+		if tok.kind == tokenRParen {
+			return cmp, nil
+		}
+		if tok.kind != tokenComma {
+			return nil, p.unexpected(tok)
+		}
+	}
+}
+
+func (p *parser) value() (string, error) {
+	tok := p.next()
+	if tok.kind != tokenWord && tok.kind != tokenString {
+		return "", p.unexpected(tok)
+	}
+	return tok.text, nil
+}
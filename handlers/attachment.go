@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/storage"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const defaultMaxAttachmentSize = 10 << 20
+
+var defaultAttachmentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"text/plain",
+	"application/zip",
+}
+
+// maxAttachmentSize reads ATTACHMENT_MAX_SIZE (bytes), defaulting to 10 MB.
+func maxAttachmentSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("ATTACHMENT_MAX_SIZE"), 10, 64)
+	if err != nil || size <= 0 {
+		return defaultMaxAttachmentSize
+	}
+	return size
+}
+
+// allowedAttachmentTypes reads ATTACHMENT_ALLOWED_TYPES as a comma separated list.
+func allowedAttachmentTypes() []string {
+	value := os.Getenv("ATTACHMENT_ALLOWED_TYPES")
+	if value == "" {
+		return defaultAttachmentTypes
+	}
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// detectAttachmentType sniffs the content of the upload instead of trusting
+// the Content-Type sent by the client.
+func detectAttachmentType(head []byte) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "", false
+	}
+	for _, allowed := range allowedAttachmentTypes() {
+		if mediaType == allowed {
+			return mediaType, true
+		}
+	}
+	return mediaType, false
+}
+
+// attachmentUpload is the file part of an upload request. The first bytes
+// of the file were read to sniff its type and are kept in head.
+type attachmentUpload struct {
+	file        multipart.File
+	header      *multipart.FileHeader
+	head        []byte
+	contentType string
+}
+
+// content returns the whole file, head included.
+func (u attachmentUpload) content() io.Reader {
+	return io.MultiReader(bytes.NewReader(u.head), u.file)
+}
+
+// readUpload reads the "file" part of the request, enforcing the size limit
+// and the allowed types. The caller closes the file.
+func readUpload(w http.ResponseWriter, r *http.Request) (attachmentUpload, bool) {
+	var upload attachmentUpload
+	maxSize := maxAttachmentSize()
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.SendError(w, r, http.StatusRequestEntityTooLarge, "attachment.too_large")
+			return upload, false
+		}
+		utils.SendError(w, r, http.StatusBadRequest, "attachment.file_missing")
+		return upload, false
+	}
+	if header.Size > maxSize {
+		file.Close()
+		utils.SendError(w, r, http.StatusRequestEntityTooLarge, "attachment.too_large")
+		return upload, false
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		file.Close()
+		utils.SendError(w, r, http.StatusBadRequest, "attachment.file_unreadable")
+		return upload, false
+	}
+	head = head[:n]
+	contentType, ok := detectAttachmentType(head)
+	if !ok {
+		file.Close()
+		utils.SendError(w, r, http.StatusUnsupportedMediaType, "attachment.unsupported_type", contentType)
+		return upload, false
+	}
+	return attachmentUpload{file: file, header: header, head: head, contentType: contentType}, true
+}
+
+// UploadAttachment uploads a file to a task
+// @Summary Upload an attachment
+// @Description Attach a file to a task the user can read
+// @Tags Attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param file formData file true "File to upload"
+// @Success 201 {object} models.Attachment
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 413 {object} utils.Problem "File too large"
+// @Failure 415 {object} utils.Problem "Unsupported file type"
+// @Router /v1/projects/{id}/tasks/{taskId}/attachments [post]
+func UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+
+	upload, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+	defer upload.file.Close()
+
+	attachment := models.Attachment{
+		TaskID:      task.ID,
+		FileName:    filepath.Base(upload.header.Filename),
+		ContentType: upload.contentType,
+		Size:        upload.header.Size,
+		StorageKey:  "tasks/" + task.ID + "/" + uuid.New().String(),
+		UploaderID:  userID,
+	}
+	err := storage.Store.Put(r.Context(), attachment.StorageKey, upload.content(), upload.header.Size, upload.contentType)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attachment).Error; err != nil {
+			return err
+		}
+		if err := recordActivity(tx, r, task.ID, events.AttachmentCreated, attachment.FileName, nil); err != nil {
+			return err
+		}
+		return record(tx, r, events.AttachmentCreated, events.Key("task", task.ID), task.ProjectID, attachment)
+	})
+	if err != nil {
+		storage.Store.Delete(r.Context(), attachment.StorageKey)
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// GetAttachments lists the attachments of a task
+// @Summary List attachments
+// @Description List files attached to a task the user can read
+// @Tags Attachments
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Success 200 {array} models.Attachment
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/attachments [get]
+func GetAttachments(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	var attachments []models.Attachment
+	err := db.DB.Where("task_id = ?", task.ID).Order("created_at").Find(&attachments).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachments)
+}
+
+// DownloadAttachment streams an attachment
+// @Summary Download an attachment
+// @Description Download a file attached to a task the user can read
+// @Tags Attachments
+// @Produce octet-stream
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param attachmentId path string true "Attachment ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {file} file
+// @Header 200 {string} ETag "Version of the attachment"
+// @Success 304 {string} string "Not modified"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/attachments/{attachmentId} [get]
+func DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	var attachment models.Attachment
+	err := db.DB.First(&attachment, "id = ? and task_id = ?", r.PathValue("attachmentId"), task.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "attachment.not_found")
+		return
+	}
+	if utils.NotModified(w, r, attachment.Version) {
+		return
+	}
+	content, err := storage.Store.Get(r.Context(), attachment.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			utils.SendError(w, r, http.StatusNotFound, "attachment.content_missing")
+			return
+		}
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, content); err != nil {
+		log.Println("Error streaming attachment:", err)
+	}
+}
+
+// DeleteAttachment deletes an attachment
+// @Summary Delete an attachment
+// @Description Delete an attachment if the user uploaded it, created the task, or is admin
+// @Tags Attachments
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param attachmentId path string true "Attachment ID"
+// @Param If-Match header string true "ETag of the attachment"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Attachment changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId}/attachments/{attachmentId} [delete]
+func DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	var attachment models.Attachment
+	err := db.DB.First(&attachment, "id = ? and task_id = ?", r.PathValue("attachmentId"), task.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "attachment.not_found")
+		return
+	}
+	if attachment.UploaderID != userID && task.CreatorID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "attachment.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, attachment.Version) {
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &attachment, attachment.Version); err != nil {
+			return err
+		}
+		if err := recordActivity(tx, r, task.ID, events.AttachmentDeleted, attachment.FileName, nil); err != nil {
+			return err
+		}
+		return record(tx, r, events.AttachmentDeleted, events.Key("task", task.ID), task.ProjectID, attachment)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	if err := storage.Store.Delete(r.Context(), attachment.StorageKey); err != nil {
+		log.Println("Error deleting attachment content:", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Anwarjondev/task-management-api/utils"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// uploadRequest builds a multipart upload of content as the "file" part.
+func uploadRequest(t *testing.T, filename string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "/v1/projects/p/tasks/t/attachments", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var problem utils.Problem
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatalf("response is not a problem: %v", err)
+	}
+	return problem.Code
+}
+
+func TestReadUploadAcceptsAllowedType(t *testing.T) {
+	content := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{0}, 1000)...)
+	w := httptest.NewRecorder()
+	upload, ok := readUpload(w, uploadRequest(t, "logo.png", content))
+	if !ok {
+		t.Fatalf("readUpload refused a PNG: %d %s", w.Code, w.Body)
+	}
+	defer upload.file.Close()
+	if upload.contentType != "image/png" {
+		t.Errorf("content type = %q, want image/png", upload.contentType)
+	}
+	got, _ := io.ReadAll(upload.content())
+	if !bytes.Equal(got, content) {
+		t.Errorf("content has %d bytes, want the %d uploaded", len(got), len(content))
+	}
+}
+
+func TestReadUploadSniffsInsteadOfTrustingName(t *testing.T) {
+	w := httptest.NewRecorder()
+	_, ok := readUpload(w, uploadRequest(t, "logo.png", []byte("<html><script>alert(1)</script></html>")))
+	if ok {
+		t.Fatal("readUpload accepted HTML named .png")
+	}
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want 415", w.Code)
+	}
+	if code := problemCode(t, w); code != "attachment.unsupported_type" {
+		t.Errorf("code = %q", code)
+	}
+}
+
+func TestReadUploadAllowedTypesFromEnv(t *testing.T) {
+	t.Setenv("ATTACHMENT_ALLOWED_TYPES", "application/pdf, text/plain")
+	w := httptest.NewRecorder()
+	if _, ok := readUpload(w, uploadRequest(t, "logo.png", pngHeader)); ok {
+		t.Fatal("readUpload accepted a PNG that is not in ATTACHMENT_ALLOWED_TYPES")
+	}
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want 415", w.Code)
+	}
+}
+
+func TestReadUploadRejectsFileOverLimit(t *testing.T) {
+	t.Setenv("ATTACHMENT_MAX_SIZE", "100")
+	w := httptest.NewRecorder()
+	_, ok := readUpload(w, uploadRequest(t, "notes.txt", []byte(strings.Repeat("a", 101))))
+	if ok {
+		t.Fatal("readUpload accepted a file over ATTACHMENT_MAX_SIZE")
+	}
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+	if code := problemCode(t, w); code != "attachment.too_large" {
+		t.Errorf("code = %q", code)
+	}
+}
+
+func TestReadUploadStopsReadingHugeBody(t *testing.T) {
+	t.Setenv("ATTACHMENT_MAX_SIZE", "100")
+	w := httptest.NewRecorder()
+	_, ok := readUpload(w, uploadRequest(t, "notes.txt", bytes.Repeat([]byte("a"), 2<<20)))
+	if ok {
+		t.Fatal("readUpload accepted a body over the limit")
+	}
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+}
+
+func TestReadUploadRequiresFile(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/projects/p/tasks/t/attachments", strings.NewReader(""))
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	w := httptest.NewRecorder()
+	if _, ok := readUpload(w, r); ok {
+		t.Fatal("readUpload accepted a request without a file")
+	}
+	if code := problemCode(t, w); code != "attachment.file_missing" {
+		t.Errorf("code = %q", code)
+	}
+}
@@ -3,21 +3,33 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
 	"github.com/Anwarjondev/task-management-api/middleware"
 	"github.com/Anwarjondev/task-management-api/models"
 	"github.com/Anwarjondev/task-management-api/utils"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+	// Report JSON field names in validation errors, not Go field names.
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
 }
 
 // Register a new user
@@ -28,31 +40,43 @@ func init() {
 // @Produce json
 // @Param user body models.User true "User data"
 // @Success 201 {object} map[string]string
-// @Failure 400 {string} string "Invalid request"
-// @Failure 500 {string} string "Server error"
-// @Router /register [post]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Router /v1/register [post]
 func Register(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+		utils.SendDecodeError(w, r, err)
 		return
 	}
+	if user.Role == "" {
+		user.Role = "team_member"
+	}
 	err := validate.Struct(&user)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+		utils.SendValidationError(w, r, err)
 		return
 	}
-	if user.Role == "" {
-		user.Role = "team_member"
+	var count int64
+	db.DB.Model(&models.User{}).Where("username = ?", user.Username).Count(&count)
+	if count > 0 {
+		utils.SendError(w, r, http.StatusConflict, "user.username_taken")
+		return
 	}
 	hashedpassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, err.Error())
+		utils.SendInternalError(w, r, err)
 		return
 	}
 	user.Password = string(hashedpassword)
-	if err = db.DB.Create(&user).Error; err != nil {
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.UserRegistered, events.Key("user", user.ID), "", newUserData(user))
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -69,26 +93,26 @@ func Register(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param user body models.User true "User credentials"
 // @Success 200 {object} map[string]string
-// @Failure 400 {string} string "Invalid request"
-// @Failure 401 {string} string "Invalid credentials"
-// @Router /login [post]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Invalid credentials"
+// @Router /v1/login [post]
 func Login(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+		utils.SendDecodeError(w, r, err)
 		return
 	}
-	err := validate.Struct(&user)
-	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, err.Error())
+	if user.Username == "" || user.Password == "" {
+		utils.SendError(w, r, http.StatusBadRequest, utils.CodeMissingLogin)
+		return
 	}
 	var dbUser models.User
 	if err := db.DB.Where("username = ?", user.Username).First(&dbUser).Error; err != nil {
-		utils.SendError(w, http.StatusUnauthorized, err.Error())
+		utils.SendError(w, r, http.StatusUnauthorized, utils.CodeInvalidLogin)
 		return
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(dbUser.Password)); err != nil {
-		utils.SendError(w, http.StatusUnauthorized, err.Error())
+	if err := bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(user.Password)); err != nil {
+		utils.SendError(w, r, http.StatusUnauthorized, utils.CodeInvalidLogin)
 		return
 	}
 	expirationTime := time.Now().Add(30 * time.Minute)
@@ -102,7 +126,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(middleware.JwtKey)
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, err.Error())
+		utils.SendInternalError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/Anwarjondev/task-management-api/board"
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/lexorank"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/pagination"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// boardStatuses are the board columns, in display order.
+var boardStatuses = []string{"pending", "in_progress", "completed"}
+
+// rankOrder sorts tasks by rank in byte order; unranked tasks go last.
+const rankOrder = `rank = '', rank collate "C", id`
+
+var errWIPLimit = errors.New("column WIP limit reached")
+
+// defaultBoardLimit is the number of tasks listed per column when the
+// request does not ask for another, up to pagination.MaxLimit.
+const defaultBoardLimit = 50
+
+// BoardColumnView is a column of the board. Count is the number of tasks in
+// the column the user can see; Tasks holds the first of them by rank.
+// Version is the version of the column settings, for If-Match on an
+// update; a column that was never configured is at version 1.
+type BoardColumnView struct {
+	Status   string        `json:"status"`
+	WIPLimit int           `json:"wip_limit"`
+	Version  int           `json:"version"`
+	Count    int           `json:"count"`
+	Tasks    []models.Task `json:"tasks"`
+}
+
+type Board struct {
+	ProjectID string            `json:"project_id"`
+	Columns   []BoardColumnView `json:"columns"`
+}
+
+// checkWIPLimit returns errWIPLimit when one more task would exceed the
+// column's WIP limit. It locks the column, so tx must be a transaction that
+// then moves the task.
+func checkWIPLimit(tx *gorm.DB, projectID, status string) error {
+	column, err := board.Lock(tx, projectID, status)
+	if err != nil || column.WIPLimit == 0 {
+		return err
+	}
+	var count int64
+	err = tx.Model(&models.Task{}).Where("project_id = ? and status = ?", projectID, status).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if int(count) >= column.WIPLimit {
+		return fmt.Errorf("%w: %s allows %d tasks", errWIPLimit, status, column.WIPLimit)
+	}
+	return nil
+}
+
+// GetBoard returns the tasks of a project grouped into board columns
+// @Summary Project board
+// @Description Tasks of a project grouped by status column and ordered by rank. Users other than admins see the tasks they created or are assigned to. Each column lists at most limit tasks; count gives the full number.
+// @Tags Board
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param limit query int false "Tasks per column, at most 100" default(50)
+// @Success 200 {object} Board
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/board [get]
+func GetBoard(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	var columns []models.BoardColumn
+	err = db.DB.Where("project_id = ?", project.ID).Find(&columns).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultBoardLimit
+	}
+	limit = min(limit, pagination.MaxLimit)
+	visible := func() *gorm.DB {
+		query := db.DB.Model(&models.Task{}).Where("project_id = ?", project.ID)
+		if role != "admin" {
+			query = query.Where("creator_id = ? or assignee_id = ?", userID, userID)
+		}
+		return query
+	}
+	var counts []struct {
+		Status string
+		Count  int
+	}
+	err = visible().Select("status, count(*) as count").Group("status").Scan(&counts).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	board := Board{ProjectID: project.ID}
+	for _, status := range boardStatuses {
+		view := BoardColumnView{Status: status, Version: 1, Tasks: []models.Task{}}
+		for _, column := range columns {
+			if column.Status == status {
+				view.WIPLimit = column.WIPLimit
+				view.Version = column.Version
+			}
+		}
+		for _, count := range counts {
+			if count.Status == status {
+				view.Count = count.Count
+			}
+		}
+		err = visible().Preload("Labels").Where("status = ?", status).Order(rankOrder).Limit(limit).Find(&view.Tasks).Error
+		if err != nil {
+			utils.SendInternalError(w, r, err)
+			return
+		}
+		board.Columns = append(board.Columns, view)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(board)
+}
+
+// UpdateBoardColumn sets the WIP limit of a board column
+// @Summary Update a board column
+// @Description Set the WIP limit of a status column; 0 removes the limit
+// @Tags Board
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param status path string true "Column status"
+// @Param column body models.BoardColumn true "WIP limit"
+// @Param If-Match header string true "ETag of the column, from its version on the board"
+// @Success 200 {object} models.BoardColumn
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Column changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/board/columns/{status} [put]
+func UpdateBoardColumn(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if project.OwnerID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
+		return
+	}
+	status := r.PathValue("status")
+	if !slices.Contains(boardStatuses, status) {
+		utils.SendError(w, r, http.StatusNotFound, "board.column_not_found", status)
+		return
+	}
+	var input models.BoardColumn
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	err = validate.Struct(&input)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	var column models.BoardColumn
+	err = board.AddColumn(db.DB, project.ID, status)
+	if err == nil {
+		err = db.DB.First(&column, "project_id = ? and status = ?", project.ID, status).Error
+	}
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	if !utils.CheckIfMatch(w, r, column.Version) {
+		return
+	}
+	column.WIPLimit = input.WIPLimit
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &column, column.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.BoardColumnUpdated, events.Key("project", project.ID), project.ID, column)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	utils.SetETag(w, column.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(column)
+}
+
+// MoveTask changes a task's status and board position in one call
+// @Summary Move a task on the board
+// @Description Move a task into a column, after after_id or before before_id (or to the end). Only the moved task is updated
+// @Tags Board
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param move body object true "status and optional after_id or before_id"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "WIP limit reached, or no room at the position"
+// @Router /v1/projects/{id}/tasks/{taskId}/move [post]
+func MoveTask(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !task.AssignedTo(userID) && task.CreatorID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	var input struct {
+		Status   string `json:"status" validate:"required,oneof=pending in_progress completed"`
+		AfterID  string `json:"after_id"`
+		BeforeID string `json:"before_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	err = validate.Struct(&input)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if input.AfterID == task.ID || input.BeforeID == task.ID {
+		utils.SendError(w, r, http.StatusBadRequest, "board.self_reference")
+		return
+	}
+
+	before := task
+	previousStatus := task.Status
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if input.Status != task.Status {
+			if err := checkWIPLimit(tx, task.ProjectID, input.Status); err != nil {
+				return err
+			}
+		} else if _, err := board.Lock(tx, task.ProjectID, input.Status); err != nil {
+			return err
+		}
+		rank, err := rankForMove(tx, &task, input.Status, input.AfterID, input.BeforeID)
+		if err != nil {
+			return err
+		}
+		task.Status = input.Status
+		task.Rank = rank
+		err = tx.Model(&task).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "version"}}}).
+			Updates(map[string]any{"status": task.Status, "rank": task.Rank}).Error
+		if err != nil {
+			return err
+		}
+		if err := recordActivity(tx, r, task.ID, events.TaskUpdated, "", taskChanges(before, task)); err != nil {
+			return err
+		}
+		err = record(tx, r, events.TaskUpdated, events.Key("task", task.ID), task.ProjectID, task)
+		if err != nil || task.Status == previousStatus {
+			return err
+		}
+		return record(tx, r, events.TaskStatusChanged, events.Key("task", task.ID), task.ProjectID, taskStatusChange{task, previousStatus})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errWIPLimit):
+			utils.SendError(w, r, http.StatusConflict, "board.wip_limit_reached", input.Status)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			utils.SendError(w, r, http.StatusBadRequest, "board.neighbour_not_in_column")
+		case errors.Is(err, board.ErrRankExhausted):
+			utils.SendError(w, r, http.StatusConflict, "board.rank_exhausted")
+		default:
+			utils.SendInternalError(w, r, err)
+		}
+		return
+	}
+	utils.SetETag(w, task.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// rankForMove computes the rank of task when placed into status after
+// afterID or before beforeID. With neither it goes to the end of the column.
+// tx must hold the lock of the column.
+func rankForMove(tx *gorm.DB, task *models.Task, status, afterID, beforeID string) (string, error) {
+	if afterID == "" && beforeID == "" {
+		return board.EndRank(tx, task.ProjectID, status, task.ID)
+	}
+	return board.Place(tx, task.ProjectID, status, task.ID, func() (string, error) {
+		return neighbourRank(tx, task, status, afterID, beforeID)
+	})
+}
+
+// neighbourRank returns a rank between the task afterID or beforeID and
+// its neighbour on the other side.
+func neighbourRank(tx *gorm.DB, task *models.Task, status, afterID, beforeID string) (string, error) {
+	column := func() *gorm.DB {
+		return tx.Model(&models.Task{}).Where("project_id = ? and status = ? and id <> ?", task.ProjectID, status, task.ID)
+	}
+	var prev, next string
+	switch {
+	case afterID != "":
+		var after models.Task
+		if err := column().Where("id = ?", afterID).First(&after).Error; err != nil {
+			return "", err
+		}
+		prev = after.Rank
+		var following models.Task
+		err := column().Where(`rank collate "C" > ?`, prev).Order(`rank collate "C"`).Limit(1).Find(&following).Error
+		if err != nil {
+			return "", err
+		}
+		next = following.Rank
+	default:
+		var before models.Task
+		if err := column().Where("id = ?", beforeID).First(&before).Error; err != nil {
+			return "", err
+		}
+		next = before.Rank
+		var preceding models.Task
+		err := column().Where(`rank collate "C" < ?`, next).Order(`rank collate "C" desc`).Limit(1).Find(&preceding).Error
+		if err != nil {
+			return "", err
+		}
+		prev = preceding.Rank
+	}
+	return lexorank.Between(prev, next)
+}
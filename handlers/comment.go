@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+// CreateComment comments on a task
+// @Summary Add a comment
+// @Description Add a comment to a task the user can see
+// @Tags Comments
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param comment body models.Comment true "Comment body"
+// @Success 201 {object} models.Comment
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/comments [post]
+func CreateComment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	var comment models.Comment
+	err := json.NewDecoder(r.Body).Decode(&comment)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	comment.Body = strings.TrimSpace(comment.Body)
+	err = validate.Struct(&comment)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	comment.TaskID = task.ID
+	comment.AuthorID = userID
+	comment.EmailFrom = ""
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&comment).Error; err != nil {
+			return err
+		}
+		if err := watchTask(tx, task, userID); err != nil {
+			return err
+		}
+		return record(tx, r, events.CommentCreated, events.Key("task", task.ID), task.ProjectID, comment)
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(comment)
+}
+
+// GetComments lists the comments of a task
+// @Summary List comments
+// @Description List the comments of a task, oldest first
+// @Tags Comments
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Success 200 {array} models.Comment
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/comments [get]
+func GetComments(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	comments := []models.Comment{}
+	err := db.DB.Where("task_id = ?", task.ID).Order("created_at").Find(&comments).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comments)
+}
+
+// DeleteComment deletes a comment
+// @Summary Delete a comment
+// @Description Delete a comment if the user wrote it or is admin
+// @Tags Comments
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param commentId path string true "Comment ID"
+// @Param If-Match header string true "ETag of the comment"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Comment changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId}/comments/{commentId} [delete]
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	var comment models.Comment
+	err := db.DB.First(&comment, "id = ? and task_id = ?", r.PathValue("commentId"), task.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "comment.not_found")
+		return
+	}
+	if comment.AuthorID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "comment.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, comment.Version) {
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &comment, comment.Version); err != nil {
+			return err
+		}
+		if err := recordActivity(tx, r, task.ID, events.CommentDeleted, comment.ID, nil); err != nil {
+			return err
+		}
+		return record(tx, r, events.CommentDeleted, events.Key("task", task.ID), task.ProjectID, comment)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"gorm.io/gorm"
+)
+
+// record writes a domain event about a change made for the request to the
+// outbox. tx must be the transaction of the change.
+func record(tx *gorm.DB, r *http.Request, typ, key, projectID string, data any) error {
+	actorID, _ := r.Context().Value("user_id").(string)
+	return events.Record(tx, events.Event{Type: typ, Key: key, ProjectID: projectID, ActorID: actorID, Data: data})
+}
+
+// taskStatusChange is the data of a task.status_changed event.
+type taskStatusChange struct {
+	Task           models.Task `json:"task"`
+	PreviousStatus string      `json:"previous_status"`
+}
+
+// taskAssignment is the data of a task.assigned event.
+type taskAssignment struct {
+	Task               models.Task `json:"task"`
+	PreviousAssigneeID *string     `json:"previous_assignee_id"`
+}
+
+// subtaskAssignment is the data of a subtask.assigned event.
+type subtaskAssignment struct {
+	Subtask            models.Subtask `json:"subtask"`
+	PreviousAssigneeID *string        `json:"previous_assignee_id"`
+}
+
+// memberAdded is the data of a project.member_added event.
+type memberAdded struct {
+	ProjectID string `json:"project_id"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+}
+
+// userData is the data of user events; it leaves out the password hash.
+type userData struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+func newUserData(user models.User) userData {
+	return userData{ID: user.ID, Username: user.Username, Role: user.Role}
+}
+
+// deleted is the data of *.deleted events.
+type deleted struct {
+	ID string `json:"id"`
+}
+
+// taskLabels is the data of a task.labels_changed event.
+type taskLabels struct {
+	TaskID string         `json:"task_id"`
+	Labels []models.Label `json:"labels"`
+}
+
+// withoutSecret returns hook with its signing secret blanked, for event
+// data.
+func withoutSecret(hook models.Webhook) models.Webhook {
+	hook.Secret = ""
+	return hook
+}
+
+// viewProjectID returns the project of a saved view, or "" for a view
+// without one.
+func viewProjectID(view models.SavedView) string {
+	if view.ProjectID == nil {
+		return ""
+	}
+	return *view.ProjectID
+}
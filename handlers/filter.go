@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Anwarjondev/task-management-api/filter"
+	"github.com/Anwarjondev/task-management-api/i18n"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+var statusValues = []string{"pending", "in_progress", "completed"}
+
+var taskFilterSchema = filter.Schema{
+	"status":      {Column: "task.status", Type: filter.Enum, Values: statusValues},
+	"title":       {Column: "task.title", Type: filter.String},
+	"description": {Column: "task.description", Type: filter.String},
+	"assignee":    {Column: "task.assignee_id", Type: filter.User},
+	"creator":     {Column: "task.creator_id", Type: filter.User},
+	"project":     {Column: "task.project_id", Type: filter.Ref},
+	"milestone":   {Column: "task.milestone_id", Type: filter.Ref},
+	"sprint":      {Column: "task.sprint_id", Type: filter.Ref},
+	"due":         {Column: "task.due_date", Type: filter.Date},
+	"estimate":    {Column: "task.estimate_minutes", Type: filter.Number},
+	"created":     {Column: "task.created_at", Type: filter.Date},
+	"label": {Type: filter.Has, Has: "task.id in (select task_labels.task_id from task_labels " +
+		"join label on label.id = task_labels.label_id where lower(label.name) = lower(?))"},
+}
+
+var projectFilterSchema = filter.Schema{
+	"name":        {Column: "project.name", Type: filter.String},
+	"description": {Column: "project.description", Type: filter.String},
+	"owner":       {Column: "project.owner_id", Type: filter.User},
+	"created":     {Column: "project.created_at", Type: filter.Date},
+}
+
+var subtaskFilterSchema = filter.Schema{
+	"title":    {Column: "subtask.title", Type: filter.String},
+	"status":   {Column: "subtask.status", Type: filter.Enum, Values: statusValues},
+	"assignee": {Column: "subtask.assignee_id", Type: filter.User},
+	"creator":  {Column: "subtask.creator_id", Type: filter.User},
+	"task":     {Column: "subtask.task_id", Type: filter.Ref},
+	"created":  {Column: "subtask.created_at", Type: filter.Date},
+}
+
+// applyFilter adds the filter query parameter to query. It answers 400 and
+// returns false when the expression is rejected.
+func applyFilter(w http.ResponseWriter, r *http.Request, query *gorm.DB, schema filter.Schema) (*gorm.DB, bool) {
+	return filterQuery(w, r, query, r.URL.Query().Get("filter"), schema)
+}
+
+// filterQuery adds the filter expression expr to query.
+func filterQuery(w http.ResponseWriter, r *http.Request, query *gorm.DB, expr string, schema filter.Schema) (*gorm.DB, bool) {
+	if expr == "" {
+		return query, true
+	}
+	node, err := filter.Parse(expr)
+	if err != nil {
+		sendFilterError(w, r, err)
+		return nil, false
+	}
+	userID := r.Context().Value("user_id").(string)
+	sql, args, err := filter.Compile(node, schema, filter.Env{UserID: userID})
+	if err != nil {
+		sendFilterError(w, r, err)
+		return nil, false
+	}
+	return query.Where(sql, args...), true
+}
+
+func sendFilterError(w http.ResponseWriter, r *http.Request, err error) {
+	var filterErr *filter.Error
+	if !errors.As(err, &filterErr) {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	param := filterErr.Field
+	if filterErr.Kind == filter.KindInvalidOperator {
+		param = filterErr.Op
+	}
+	var message string
+	switch filterErr.Kind {
+	case filter.KindSyntax:
+		message = i18n.T(r, "filter.syntax", err.Error(), strconv.Itoa(filterErr.Pos), filterErr.Value)
+	case filter.KindInvalidValue:
+		message = i18n.T(r, "filter.invalid_value", err.Error(), filterErr.Field, filterErr.Value, filterErr.Expected)
+	default:
+		message = i18n.T(r, "filter."+filterErr.Kind, err.Error(), filterErr.Field, filterErr.Op)
+	}
+	utils.SendProblem(w, r, utils.Problem{
+		Status: http.StatusBadRequest,
+		Code:   "filter.invalid",
+		Detail: i18n.T(r, "filter.invalid", "filter.invalid"),
+		Errors: []utils.FieldError{{
+			Field:    "filter",
+			Rule:     filterErr.Kind,
+			Param:    param,
+			Position: filterErr.Pos,
+			Message:  message,
+		}},
+	})
+}
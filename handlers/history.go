@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+// historyEntry is an item of the history of a task: an activity or a
+// comment.
+type historyEntry struct {
+	Kind      string               `json:"kind" enums:"activity,comment"`
+	ActorID   *string              `json:"actor_id"`
+	CreatedAt time.Time            `json:"created_at"`
+	Activity  *models.TaskActivity `json:"activity,omitempty"`
+	Comment   *models.Comment      `json:"comment,omitempty"`
+}
+
+// GetTaskHistory lists the history of a task
+// @Summary Task history
+// @Description List the changes, attachments and comments of a task, oldest first. Changes carry the old and new value of each field.
+// @Tags Tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string false "Project ID, absent on /v1/tasks/{taskId}/history"
+// @Param taskId path string true "Task ID"
+// @Success 200 {array} historyEntry
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/history [get]
+// @Router /v1/tasks/{taskId}/history [get]
+func GetTaskHistory(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadReadableTask(w, r)
+	if !ok {
+		return
+	}
+	var activities []models.TaskActivity
+	err := db.DB.Where("task_id = ?", task.ID).Order("created_at").Find(&activities).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	var comments []models.Comment
+	err = db.DB.Where("task_id = ?", task.ID).Order("created_at").Find(&comments).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	entries := make([]historyEntry, 0, len(activities)+len(comments))
+	for i := range activities {
+		activity := &activities[i]
+		entries = append(entries, historyEntry{Kind: "activity", ActorID: activity.ActorID, CreatedAt: activity.CreatedAt, Activity: activity})
+	}
+	for i := range comments {
+		comment := &comments[i]
+		entries = append(entries, historyEntry{Kind: "comment", ActorID: &comment.AuthorID, CreatedAt: comment.CreatedAt, Comment: comment})
+	}
+	slices.SortStableFunc(entries, func(a, b historyEntry) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// recordActivity adds an entry to the history of a task, made by the user
+// of the request. An update without changes is not recorded.
+func recordActivity(tx *gorm.DB, r *http.Request, taskID, action, subject string, changes []models.FieldChange) error {
+	actorID, _ := r.Context().Value("user_id").(string)
+	return addActivity(tx, actorID, taskID, action, subject, changes)
+}
+
+// addActivity is recordActivity for changes made outside a request.
+func addActivity(tx *gorm.DB, actorID, taskID, action, subject string, changes []models.FieldChange) error {
+	if action == events.TaskUpdated && len(changes) == 0 {
+		return nil
+	}
+	return tx.Create(&models.TaskActivity{
+		TaskID:  taskID,
+		ActorID: optionalString(actorID),
+		Action:  action,
+		Subject: subject,
+		Changes: changes,
+	}).Error
+}
+
+// recordTasksChange records the change of field on every task matching the
+// condition. Call it before the bulk update that makes the change.
+func recordTasksChange(tx *gorm.DB, r *http.Request, field string, from, to any, condition string, args ...any) error {
+	var ids []string
+	if err := tx.Model(&models.Task{}).Where(condition, args...).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	change := []models.FieldChange{{Field: field, Old: from, New: to}}
+	for _, id := range ids {
+		if err := recordActivity(tx, r, id, events.TaskUpdated, "", change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// taskChanges returns the editable fields that differ between two versions
+// of a task.
+func taskChanges(before, after models.Task) []models.FieldChange {
+	var changes []models.FieldChange
+	add := func(field string, from, to any, equal bool) {
+		if !equal {
+			changes = append(changes, models.FieldChange{Field: field, Old: from, New: to})
+		}
+	}
+	add("title", before.Title, after.Title, before.Title == after.Title)
+	add("description", before.Description, after.Description, before.Description == after.Description)
+	add("status", before.Status, after.Status, before.Status == after.Status)
+	add("assignee_id", before.AssigneeID, after.AssigneeID, equalStrings(before.AssigneeID, after.AssigneeID))
+	add("estimate_minutes", before.EstimateMinutes, after.EstimateMinutes, before.EstimateMinutes == after.EstimateMinutes)
+	add("due_date", before.DueDate, after.DueDate, equalTimes(before.DueDate, after.DueDate))
+	add("milestone_id", before.MilestoneID, after.MilestoneID, equalStrings(before.MilestoneID, after.MilestoneID))
+	add("sprint_id", before.SprintID, after.SprintID, equalStrings(before.SprintID, after.SprintID))
+	return changes
+}
+
+// recordLabelChange records the change of the labels of a task, if any.
+func recordLabelChange(tx *gorm.DB, r *http.Request, taskID string, before, after []models.Label) error {
+	from, to := labelNames(before), labelNames(after)
+	if slices.Equal(from, to) {
+		return nil
+	}
+	change := []models.FieldChange{{Field: "labels", Old: from, New: to}}
+	return recordActivity(tx, r, taskID, events.TaskLabelsChanged, "", change)
+}
+
+// labelNames returns the names of labels, sorted.
+func labelNames(labels []models.Label) []string {
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = label.Name
+	}
+	slices.Sort(names)
+	return names
+}
+
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func equalStrings(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// optionalID treats an empty id as no id, since clients written before
+// ids were nullable send "" to clear one.
+func optionalID(id *string) *string {
+	if id == nil || *id == "" {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Anwarjondev/task-management-api/board"
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/inbound"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/storage"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxInboundEmailSize is the largest inbound message accepted, in bytes.
+const MaxInboundEmailSize = 25 << 20
+
+// InboundResult says what an inbound email was filed as. CommentID is set
+// when the email was a reply to a task.
+type InboundResult struct {
+	TaskID      string `json:"task_id"`
+	CommentID   string `json:"comment_id,omitempty"`
+	Attachments int    `json:"attachments"`
+}
+
+// inboundDomain reads INBOUND_EMAIL_DOMAIN, the domain of inbound
+// addresses.
+func inboundDomain() string {
+	if domain := os.Getenv("INBOUND_EMAIL_DOMAIN"); domain != "" {
+		return domain
+	}
+	return "localhost"
+}
+
+// GetInboundAddress returns the inbound email address of a project
+// @Summary Get inbound email address
+// @Description Get the address that turns email into tasks of the project (owner only)
+// @Tags Inbound email
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} models.InboundAddress
+// @Header 200 {string} ETag "Version of the address"
+// @Success 304 {string} string "Not modified"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/inbound_address [get]
+func GetInboundAddress(w http.ResponseWriter, r *http.Request) {
+	project, ok := loadOwnedProject(w, r)
+	if !ok {
+		return
+	}
+	var address models.InboundAddress
+	err := db.DB.First(&address, "project_id = ?", project.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "inbound.not_enabled")
+		return
+	}
+	if utils.NotModified(w, r, address.Version) {
+		return
+	}
+	address.Address = address.Token + "@" + inboundDomain()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(address)
+}
+
+// UpdateInboundAddress enables or rotates the inbound email address of a project
+// @Summary Enable inbound email
+// @Description Give the project a new inbound address; a previous address stops working (owner only). Rotating an existing address requires If-Match.
+// @Tags Inbound email
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param If-Match header string false "ETag of the current address, when there is one"
+// @Success 200 {object} models.InboundAddress
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Address changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/inbound_address [put]
+func UpdateInboundAddress(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	project, ok := loadOwnedProject(w, r)
+	if !ok {
+		return
+	}
+	var address models.InboundAddress
+	err := db.DB.Limit(1).Find(&address, "project_id = ?", project.ID).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	exists := address.ProjectID != ""
+	if exists && !utils.CheckIfMatch(w, r, address.Version) {
+		return
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	address.ProjectID = project.ID
+	address.Token = "p-" + hex.EncodeToString(b)
+	address.CreatorID = userID
+	if exists {
+		err = saveVersioned(db.DB, &address, address.Version)
+	} else {
+		err = createInboundAddress(db.DB, &address)
+	}
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	utils.SetETag(w, address.Version)
+	address.Address = address.Token + "@" + inboundDomain()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(address)
+}
+
+// createInboundAddress inserts the first address of a project. It fails
+// with errStale when another request enabled inbound email first.
+func createInboundAddress(tx *gorm.DB, address *models.InboundAddress) error {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(address)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errStale
+	}
+	return result.Error
+}
+
+// DeleteInboundAddress disables inbound email for a project
+// @Summary Disable inbound email
+// @Tags Inbound email
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param If-Match header string true "ETag of the address"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Address changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/inbound_address [delete]
+func DeleteInboundAddress(w http.ResponseWriter, r *http.Request) {
+	project, ok := loadOwnedProject(w, r)
+	if !ok {
+		return
+	}
+	var address models.InboundAddress
+	err := db.DB.First(&address, "project_id = ?", project.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "inbound.not_enabled")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, address.Version) {
+		return
+	}
+	err = deleteVersioned(db.DB, &address, address.Version)
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReceiveEmail files a raw MIME message posted by a mail provider
+// @Summary Receive an email
+// @Description File a raw RFC 5322 message as a task of the project it is addressed to, or as a comment when its subject carries a [task:<id>] reference. Requires the X-Inbound-Secret header to match INBOUND_EMAIL_SECRET.
+// @Tags Inbound email
+// @Accept message/rfc822
+// @Produce json
+// @Param X-Inbound-Secret header string true "Shared secret"
+// @Param recipient query string false "Envelope recipient; defaults to the first inbound address in To, Cc or Delivered-To"
+// @Success 201 {object} InboundResult
+// @Failure 400 {object} utils.Problem "Invalid message"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Unknown recipient"
+// @Failure 413 {object} utils.Problem "Message too large"
+// @Router /v1/inbound/email [post]
+func ReceiveEmail(w http.ResponseWriter, r *http.Request) {
+	secret := os.Getenv("INBOUND_EMAIL_SECRET")
+	given := r.Header.Get("X-Inbound-Secret")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+		utils.SendError(w, r, http.StatusForbidden, "inbound.forbidden")
+		return
+	}
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxInboundEmailSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.SendError(w, r, http.StatusRequestEntityTooLarge, "inbound.too_large")
+			return
+		}
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	recipient := r.URL.Query().Get("recipient")
+	if recipient == "" {
+		msg, err := inbound.Parse(raw)
+		if err != nil {
+			utils.SendError(w, r, http.StatusBadRequest, "inbound.invalid_message")
+			return
+		}
+		for _, to := range msg.To {
+			if AcceptsInboundEmail(to) {
+				recipient = to
+				break
+			}
+		}
+	}
+	result, err := deliverEmail(r.Context(), recipient, raw)
+	switch {
+	case errors.Is(err, inbound.ErrUnknownRecipient):
+		utils.SendError(w, r, http.StatusNotFound, "inbound.unknown_recipient")
+		return
+	case errors.Is(err, inbound.ErrRejected):
+		utils.SendError(w, r, http.StatusBadRequest, "inbound.invalid_message")
+		return
+	case err != nil:
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// AcceptsInboundEmail reports whether recipient is the inbound address of
+// a project.
+func AcceptsInboundEmail(recipient string) bool {
+	_, err := lookupInboundAddress(recipient)
+	return err == nil
+}
+
+// DeliverInboundEmail files a raw message sent to recipient; it is the
+// inbound.Handler of the SMTP listener.
+func DeliverInboundEmail(recipient string, raw []byte) error {
+	_, err := deliverEmail(context.Background(), recipient, raw)
+	return err
+}
+
+func lookupInboundAddress(recipient string) (models.InboundAddress, error) {
+	var address models.InboundAddress
+	local, domain, ok := strings.Cut(strings.ToLower(recipient), "@")
+	if !ok || local == "" || !strings.EqualFold(domain, inboundDomain()) {
+		return address, inbound.ErrUnknownRecipient
+	}
+	err := db.DB.First(&address, "token = ?", local).Error
+	if err != nil {
+		return address, inbound.ErrUnknownRecipient
+	}
+	return address, nil
+}
+
+// deliverEmail files a message as a new task of the recipient's project,
+// or as a comment when its subject references a task of that project. The
+// user who set up the address is the author: the From header is not
+// authenticated, so the sender is only kept in EmailFrom.
+func deliverEmail(ctx context.Context, recipient string, raw []byte) (InboundResult, error) {
+	var result InboundResult
+	address, err := lookupInboundAddress(recipient)
+	if err != nil {
+		return result, err
+	}
+	msg, err := inbound.Parse(raw)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", inbound.ErrRejected, err)
+	}
+	var project models.Project
+	if err := db.DB.First(&project, "id = ?", address.ProjectID).Error; err != nil {
+		return result, inbound.ErrUnknownRecipient
+	}
+	authorID := address.CreatorID
+
+	var task models.Task
+	var comment *models.Comment
+	if id, ok := inbound.FindTaskReference(msg.Subject); ok {
+		if db.DB.First(&task, "id = ? and project_id = ?", id, project.ID).Error == nil {
+			body := inbound.StripQuoted(msg.Text)
+			if body == "" {
+				body = "(no text)"
+			}
+			comment = &models.Comment{TaskID: task.ID, AuthorID: authorID, Body: truncateRunes(body, 5000), EmailFrom: msg.From}
+		}
+	}
+	files := msg.Attachments
+	if comment == nil {
+		task = models.Task{
+			Title:       emailTaskTitle(msg),
+			Description: truncateRunes(msg.Text, 500),
+			Status:      "pending",
+			ProjectID:   project.ID,
+			CreatorID:   authorID,
+			EmailFrom:   msg.From,
+		}
+		// Keep the whole body when it does not fit the description.
+		if task.Description != msg.Text {
+			files = append([]inbound.Attachment{{FileName: "message.txt", ContentType: "text/plain", Content: []byte(msg.Text)}}, files...)
+		}
+	}
+
+	attachments := storeEmailAttachments(ctx, files, authorID)
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		recorded := []events.Event{}
+		if comment == nil {
+			// Mail is filed even into a full column: there is nobody to
+			// tell about the WIP limit.
+			if _, err := board.Lock(tx, task.ProjectID, task.Status); err != nil {
+				return err
+			}
+			rank, err := board.EndRank(tx, task.ProjectID, task.Status, "")
+			if err != nil {
+				return err
+			}
+			task.Rank = rank
+			if err := tx.Create(&task).Error; err != nil {
+				return err
+			}
+			if err := watchTask(tx, task, task.CreatorID, task.AssigneeUserID()); err != nil {
+				return err
+			}
+			if err := addActivity(tx, authorID, task.ID, events.TaskCreated, "", nil); err != nil {
+				return err
+			}
+			recorded = append(recorded, events.Event{Type: events.TaskCreated, Data: task})
+		} else {
+			if err := tx.Create(comment).Error; err != nil {
+				return err
+			}
+			if err := watchTask(tx, task, comment.AuthorID); err != nil {
+				return err
+			}
+			recorded = append(recorded, events.Event{Type: events.CommentCreated, Data: *comment})
+		}
+		for i := range attachments {
+			attachments[i].TaskID = task.ID
+			if err := tx.Create(&attachments[i]).Error; err != nil {
+				return err
+			}
+			if err := addActivity(tx, authorID, task.ID, events.AttachmentCreated, attachments[i].FileName, nil); err != nil {
+				return err
+			}
+			recorded = append(recorded, events.Event{Type: events.AttachmentCreated, Data: attachments[i]})
+		}
+		for i := range recorded {
+			recorded[i].Key = events.Key("task", task.ID)
+			recorded[i].ProjectID = task.ProjectID
+			recorded[i].ActorID = authorID
+		}
+		return events.Record(tx, recorded...)
+	})
+	if err != nil {
+		for _, attachment := range attachments {
+			storage.Store.Delete(ctx, attachment.StorageKey)
+		}
+		return result, err
+	}
+	result = InboundResult{TaskID: task.ID, Attachments: len(attachments)}
+	if comment != nil {
+		result.CommentID = comment.ID
+	}
+	return result, nil
+}
+
+// storeEmailAttachments stores the files of a message that pass the
+// attachment size and type limits and returns their attachments, not yet
+// tied to a task. Other files are skipped.
+func storeEmailAttachments(ctx context.Context, files []inbound.Attachment, uploaderID string) []models.Attachment {
+	var attachments []models.Attachment
+	for _, file := range files {
+		if int64(len(file.Content)) > maxAttachmentSize() {
+			log.Printf("Skipping email attachment %q: too large", file.FileName)
+			continue
+		}
+		contentType, ok := detectAttachmentType(file.Content)
+		if !ok {
+			log.Printf("Skipping email attachment %q: unsupported type %s", file.FileName, contentType)
+			continue
+		}
+		name := filepath.Base(file.FileName)
+		if name == "." || name == "/" {
+			name = "attachment"
+		}
+		attachment := models.Attachment{
+			FileName:    name,
+			ContentType: contentType,
+			Size:        int64(len(file.Content)),
+			StorageKey:  "email/" + uuid.New().String(),
+			UploaderID:  uploaderID,
+		}
+		err := storage.Store.Put(ctx, attachment.StorageKey, bytes.NewReader(file.Content), attachment.Size, contentType)
+		if err != nil {
+			log.Printf("Storing email attachment %q failed: %v", file.FileName, err)
+			continue
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// emailTaskTitle makes a task title from the subject of a message, falling
+// back to the sender when the subject is too short.
+func emailTaskTitle(msg *inbound.Message) string {
+	title := strings.TrimSpace(msg.Subject)
+	if utf8.RuneCountInString(title) < 3 {
+		title = "Email from " + msg.From
+	}
+	return truncateRunes(title, 100)
+}
+
+// truncateRunes shortens s to at most n runes, ending it with an ellipsis
+// when it was cut.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,479 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+// CreateLabel creates a label in a project
+// @Summary Create a label
+// @Description Create a project-scoped label with a name and color
+// @Tags Labels
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param label body models.Label true "Label data"
+// @Success 201 {object} models.Label
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Label already exists"
+// @Router /v1/projects/{id}/labels [post]
+func CreateLabel(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	var label models.Label
+	err = json.NewDecoder(r.Body).Decode(&label)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	label.Name = strings.TrimSpace(label.Name)
+	err = validate.Struct(&label)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if labelNameTaken(project.ID, label.Name, "") {
+		utils.SendError(w, r, http.StatusConflict, "label.name_taken", label.Name)
+		return
+	}
+	label.ProjectID = project.ID
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&label).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.LabelCreated, events.Key("label", label.ID), label.ProjectID, label)
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(label)
+}
+
+// GetLabels lists the labels of a project
+// @Summary List labels
+// @Description List the labels of a project
+// @Tags Labels
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Success 200 {array} models.Label
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/labels [get]
+func GetLabels(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	var labels []models.Label
+	err = db.DB.Where("project_id = ?", project.ID).Order("name").Find(&labels).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(labels)
+}
+
+// UpdateLabel renames or recolors a label
+// @Summary Update a label
+// @Description Rename or recolor a label; every task using it shows the new values
+// @Tags Labels
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param labelId path string true "Label ID"
+// @Param label body models.Label true "Updated label data"
+// @Param If-Match header string true "ETag of the label"
+// @Success 200 {object} models.Label
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Label already exists"
+// @Failure 412 {object} utils.Problem "Label changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/labels/{labelId} [put]
+func UpdateLabel(w http.ResponseWriter, r *http.Request) {
+	label, ok := loadEditableLabel(w, r)
+	if !ok {
+		return
+	}
+	var updateLabel models.Label
+	err := json.NewDecoder(r.Body).Decode(&updateLabel)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	saveLabel(w, r, label, updateLabel)
+}
+
+// PatchLabel renames or recolors a label
+// @Summary Patch a label
+// @Description Change some fields of the label with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: name, color.
+// @Tags Labels
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param labelId path string true "Label ID"
+// @Param label body models.Label true "Fields to change"
+// @Param If-Match header string true "ETag of the label"
+// @Success 200 {object} models.Label
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Label already exists"
+// @Failure 412 {object} utils.Problem "Label changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/labels/{labelId} [patch]
+func PatchLabel(w http.ResponseWriter, r *http.Request) {
+	label, ok := loadEditableLabel(w, r)
+	if !ok {
+		return
+	}
+	updateLabel := label
+	if !applyMergePatch(w, r, &updateLabel, "name", "color") {
+		return
+	}
+	saveLabel(w, r, label, updateLabel)
+}
+
+// loadEditableLabel loads the label of the request for a write by a user
+// with access to its project, checking If-Match.
+func loadEditableLabel(w http.ResponseWriter, r *http.Request) (models.Label, bool) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var label models.Label
+	err := db.DB.First(&label, "id = ?", r.PathValue("labelId")).Error
+	if err != nil || !inProject(r, label.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "label.not_found")
+		return label, false
+	}
+	var project models.Project
+	err = db.DB.First(&project, "id = ?", label.ProjectID).Error
+	if err != nil || !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return label, false
+	}
+	if !utils.CheckIfMatch(w, r, label.Version) {
+		return label, false
+	}
+	return label, true
+}
+
+// saveLabel validates updateLabel and saves its name and color to label.
+func saveLabel(w http.ResponseWriter, r *http.Request, label, updateLabel models.Label) {
+	updateLabel.Name = strings.TrimSpace(updateLabel.Name)
+	err := validate.Struct(&updateLabel)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if labelNameTaken(label.ProjectID, updateLabel.Name, label.ID) {
+		utils.SendError(w, r, http.StatusConflict, "label.name_taken", updateLabel.Name)
+		return
+	}
+	label.Name = updateLabel.Name
+	label.Color = updateLabel.Color
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &label, label.Version); err != nil {
+			return err
+		}
+		if err := touchLabelledTasks(tx, label.ID); err != nil {
+			return err
+		}
+		return record(tx, r, events.LabelUpdated, events.Key("label", label.ID), label.ProjectID, label)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	utils.SetETag(w, label.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(label)
+}
+
+// DeleteLabel deletes a label
+// @Summary Delete a label
+// @Description Delete a label and remove it from every task
+// @Tags Labels
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param labelId path string true "Label ID"
+// @Param If-Match header string true "ETag of the label"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Label changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/labels/{labelId} [delete]
+func DeleteLabel(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var label models.Label
+	err := db.DB.First(&label, "id = ?", r.PathValue("labelId")).Error
+	if err != nil || !inProject(r, label.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "label.not_found")
+		return
+	}
+	var project models.Project
+	err = db.DB.First(&project, "id = ?", label.ProjectID).Error
+	if err != nil || !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, label.Version) {
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := touchLabelledTasks(tx, label.ID); err != nil {
+			return err
+		}
+		if err := tx.Exec("delete from task_labels where label_id = ?", label.ID).Error; err != nil {
+			return err
+		}
+		if err := deleteVersioned(tx, &label, label.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.LabelDeleted, events.Key("label", label.ID), label.ProjectID, deleted{label.ID})
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetTaskLabels replaces the labels of a task
+// @Summary Set task labels
+// @Description Replace the labels of a task with labels from the task's project
+// @Tags Labels
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param labels body object true "label_ids to set"
+// @Param If-Match header string true "ETag of the task"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Task changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId}/labels [put]
+func SetTaskLabels(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, task.Version) {
+		return
+	}
+	var input struct {
+		LabelIDs []string `json:"label_ids"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	labels := []models.Label{}
+	if len(input.LabelIDs) > 0 {
+		err = db.DB.Where("id in ? and project_id = ?", input.LabelIDs, task.ProjectID).Find(&labels).Error
+		if err != nil {
+			utils.SendInternalError(w, r, err)
+			return
+		}
+	}
+	if len(labels) != len(uniqueStrings(input.LabelIDs)) {
+		utils.SendError(w, r, http.StatusBadRequest, "label.not_in_project")
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		previous := []models.Label{}
+		if err := tx.Model(&task).Association("Labels").Find(&previous); err != nil {
+			return err
+		}
+		if err := tx.Model(&task).Association("Labels").Replace(labels); err != nil {
+			return err
+		}
+		if err := bumpVersioned(tx, &task, task.Version); err != nil {
+			return err
+		}
+		if err := recordLabelChange(tx, r, task.ID, previous, labels); err != nil {
+			return err
+		}
+		return record(tx, r, events.TaskLabelsChanged, events.Key("task", task.ID), task.ProjectID, taskLabels{task.ID, labels})
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	task.Labels = labels
+	utils.SetETag(w, task.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// RemoveTaskLabel removes a label from a task
+// @Summary Remove task label
+// @Description Remove one label from a task
+// @Tags Labels
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param labelId path string true "Label ID"
+// @Param If-Match header string true "ETag of the task"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Task changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId}/labels/{labelId} [delete]
+func RemoveTaskLabel(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, task.Version) {
+		return
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		previous := []models.Label{}
+		if err := tx.Model(&task).Association("Labels").Find(&previous); err != nil {
+			return err
+		}
+		result := tx.Exec("delete from task_labels where task_id = ? and label_id = ?", task.ID, r.PathValue("labelId"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		labels := []models.Label{}
+		if err := tx.Model(&task).Association("Labels").Find(&labels); err != nil {
+			return err
+		}
+		if err := bumpVersioned(tx, &task, task.Version); err != nil {
+			return err
+		}
+		if err := recordLabelChange(tx, r, task.ID, previous, labels); err != nil {
+			return err
+		}
+		return record(tx, r, events.TaskLabelsChanged, events.Key("task", task.ID), task.ProjectID, taskLabels{task.ID, labels})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.SendError(w, r, http.StatusNotFound, "label.not_on_task")
+		return
+	}
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// touchLabelledTasks bumps the version of the tasks that show a label.
+func touchLabelledTasks(tx *gorm.DB, labelID string) error {
+	return tx.Model(&models.Task{}).
+		Where("id in (select task_id from task_labels where label_id = ?)", labelID).
+		Update("version", gorm.Expr("version + 1")).Error
+}
+
+func labelNameTaken(projectID, name, exceptID string) bool {
+	var count int64
+	query := db.DB.Model(&models.Label{}).Where("project_id = ? and lower(name) = lower(?)", projectID, name)
+	if exceptID != "" {
+		query = query.Where("id <> ?", exceptID)
+	}
+	query.Count(&count)
+	return count > 0
+}
+
+// filterByLabels restricts a task query to tasks carrying the named labels.
+// With matchAll every label must be present, otherwise any one is enough.
+// Names are compared case-insensitively, like labelNameTaken does.
+func filterByLabels(query *gorm.DB, names []string, matchAll bool) *gorm.DB {
+	if len(names) == 0 {
+		return query
+	}
+	lowered := make([]string, len(names))
+	for i, name := range names {
+		lowered[i] = strings.ToLower(name)
+	}
+	lowered = uniqueStrings(lowered)
+	sub := db.DB.Table("task_labels").
+		Select("task_labels.task_id").
+		Joins("join label on label.id = task_labels.label_id").
+		Where("lower(label.name) in ?", lowered)
+	if matchAll {
+		sub = sub.Group("task_labels.task_id").Having("count(distinct lower(label.name)) = ?", len(lowered))
+	}
+	return query.Where("task.id in (?)", sub)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			out = append(out, value)
+		}
+	}
+	return out
+}
@@ -0,0 +1,511 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+// MilestoneProgress is a milestone together with the state of its tasks.
+type MilestoneProgress struct {
+	models.Milestone
+	TotalTasks      int           `json:"total_tasks"`
+	OpenTasks       int           `json:"open_tasks"`
+	ClosedTasks     int           `json:"closed_tasks"`
+	PercentComplete float64       `json:"percent_complete"`
+	OverdueTasks    []models.Task `json:"overdue_tasks"`
+}
+
+func milestoneProgress(milestone models.Milestone) (MilestoneProgress, error) {
+	progress := MilestoneProgress{Milestone: milestone, OverdueTasks: []models.Task{}}
+	var counts []struct {
+		Status string
+		Count  int
+	}
+	err := db.DB.Model(&models.Task{}).
+		Select("status, count(*) as count").
+		Where("milestone_id = ?", milestone.ID).
+		Group("status").
+		Scan(&counts).Error
+	if err != nil {
+		return progress, err
+	}
+	for _, c := range counts {
+		progress.TotalTasks += c.Count
+		if c.Status == "completed" {
+			progress.ClosedTasks += c.Count
+		}
+	}
+	progress.OpenTasks = progress.TotalTasks - progress.ClosedTasks
+	if progress.TotalTasks > 0 {
+		percent := float64(progress.ClosedTasks) * 100 / float64(progress.TotalTasks)
+		progress.PercentComplete = math.Round(percent*10) / 10
+	}
+
+	// A task is overdue when its own due date has passed, or when it has
+	// none and the milestone's target date has passed.
+	now := time.Now().UTC()
+	query := db.DB.Where("milestone_id = ? and status <> ?", milestone.ID, "completed")
+	if milestone.TargetDate != nil && milestone.TargetDate.Before(now) {
+		query = query.Where("due_date < ? or due_date is null", now)
+	} else {
+		query = query.Where("due_date < ?", now)
+	}
+	err = query.Order("due_date").Find(&progress.OverdueTasks).Error
+	return progress, err
+}
+
+var (
+	errMilestoneNotInProject = errors.New("milestone does not belong to the task's project")
+	errMilestoneClosed       = errors.New("milestone is closed")
+)
+
+// checkMilestone verifies that a task may be put into the milestone.
+func checkMilestone(milestoneID *string, projectID string) error {
+	if milestoneID == nil || *milestoneID == "" {
+		return nil
+	}
+	var milestone models.Milestone
+	err := db.DB.First(&milestone, "id = ? and project_id = ?", *milestoneID, projectID).Error
+	if err != nil {
+		return errMilestoneNotInProject
+	}
+	if milestone.State == "closed" {
+		return errMilestoneClosed
+	}
+	return nil
+}
+
+// CreateMilestone creates a milestone in a project
+// @Summary Create a milestone
+// @Description Create a milestone with a target date in a project
+// @Tags Milestones
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param milestone body models.Milestone true "Milestone data"
+// @Success 201 {object} models.Milestone
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/milestones [post]
+func CreateMilestone(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if project.OwnerID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
+		return
+	}
+	var milestone models.Milestone
+	err = json.NewDecoder(r.Body).Decode(&milestone)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	err = validate.Struct(&milestone)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	milestone.ProjectID = project.ID
+	milestone.State = "open"
+	milestone.ClosedAt = nil
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&milestone).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.MilestoneCreated, events.Key("milestone", milestone.ID), milestone.ProjectID, milestone)
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(milestone)
+}
+
+// GetMilestones lists the milestones of a project
+// @Summary List milestones
+// @Description List the milestones of a project with their progress
+// @Tags Milestones
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param state query string false "open or closed"
+// @Success 200 {array} MilestoneProgress
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/milestones [get]
+func GetMilestones(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	var milestones []models.Milestone
+	query := db.DB.Where("project_id = ?", project.ID)
+	if state := r.URL.Query().Get("state"); state != "" {
+		query = query.Where("state = ?", state)
+	}
+	err = query.Order("target_date nulls last, created_at").Find(&milestones).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	result := make([]MilestoneProgress, 0, len(milestones))
+	for _, milestone := range milestones {
+		progress, err := milestoneProgress(milestone)
+		if err != nil {
+			utils.SendInternalError(w, r, err)
+			return
+		}
+		result = append(result, progress)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetMilestone returns a milestone with its progress
+// @Summary Get a milestone
+// @Description Completion percentage, open and closed counts and overdue tasks of a milestone
+// @Tags Milestones
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param milestoneId path string true "Milestone ID"
+// @Success 200 {object} MilestoneProgress
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/milestones/{milestoneId} [get]
+func GetMilestone(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var milestone models.Milestone
+	err := db.DB.First(&milestone, "id = ?", r.PathValue("milestoneId")).Error
+	if err != nil || !inProject(r, milestone.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "milestone.not_found")
+		return
+	}
+	var project models.Project
+	err = db.DB.First(&project, "id = ?", milestone.ProjectID).Error
+	if err != nil || !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	progress, err := milestoneProgress(milestone)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	// Progress is computed from the tasks, so the ETag only serves
+	// If-Match on writes and If-None-Match is not answered.
+	utils.SetETag(w, milestone.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
+
+// UpdateMilestone updates a milestone
+// @Summary Update a milestone
+// @Description Update the title, description and target date of a milestone
+// @Tags Milestones
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param milestoneId path string true "Milestone ID"
+// @Param milestone body models.Milestone true "Updated milestone data"
+// @Param If-Match header string true "ETag of the milestone"
+// @Success 200 {object} models.Milestone
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Milestone changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/milestones/{milestoneId} [put]
+func UpdateMilestone(w http.ResponseWriter, r *http.Request) {
+	milestone, ok := loadManagedMilestone(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, milestone.Version) {
+		return
+	}
+	var updateMilestone models.Milestone
+	err := json.NewDecoder(r.Body).Decode(&updateMilestone)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	saveMilestone(w, r, milestone, updateMilestone)
+}
+
+// PatchMilestone partially updates a milestone
+// @Summary Patch a milestone
+// @Description Change some fields of the milestone with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: title, description, target_date.
+// @Tags Milestones
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param milestoneId path string true "Milestone ID"
+// @Param milestone body models.Milestone true "Fields to change"
+// @Param If-Match header string true "ETag of the milestone"
+// @Success 200 {object} models.Milestone
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Milestone changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/milestones/{milestoneId} [patch]
+func PatchMilestone(w http.ResponseWriter, r *http.Request) {
+	milestone, ok := loadManagedMilestone(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, milestone.Version) {
+		return
+	}
+	updateMilestone := milestone
+	if !applyMergePatch(w, r, &updateMilestone, "title", "description", "target_date") {
+		return
+	}
+	saveMilestone(w, r, milestone, updateMilestone)
+}
+
+// saveMilestone validates updateMilestone and saves its editable fields to
+// milestone.
+func saveMilestone(w http.ResponseWriter, r *http.Request, milestone, updateMilestone models.Milestone) {
+	err := validate.Struct(&updateMilestone)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	milestone.Title = updateMilestone.Title
+	milestone.Description = updateMilestone.Description
+	milestone.TargetDate = updateMilestone.TargetDate
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &milestone, milestone.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.MilestoneUpdated, events.Key("milestone", milestone.ID), milestone.ProjectID, milestone)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	utils.SetETag(w, milestone.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(milestone)
+}
+
+// CloseMilestone closes a milestone
+// @Summary Close a milestone
+// @Description Close a milestone. Open tasks must either be moved to another open milestone (move_to_milestone_id) or explicitly kept (keep_open_tasks)
+// @Tags Milestones
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param milestoneId path string true "Milestone ID"
+// @Param options body object false "move_to_milestone_id or keep_open_tasks"
+// @Success 200 {object} MilestoneProgress
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Milestone has open tasks"
+// @Router /v1/projects/{id}/milestones/{milestoneId}/close [post]
+func CloseMilestone(w http.ResponseWriter, r *http.Request) {
+	milestone, ok := loadManagedMilestone(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		MoveToMilestoneID string `json:"move_to_milestone_id"`
+		KeepOpenTasks     bool   `json:"keep_open_tasks"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+	}
+	if milestone.State == "closed" {
+		utils.SendError(w, r, http.StatusConflict, "milestone.closed")
+		return
+	}
+	if input.MoveToMilestoneID != "" {
+		if input.MoveToMilestoneID == milestone.ID {
+			utils.SendError(w, r, http.StatusBadRequest, "milestone.same_target")
+			return
+		}
+		if err := checkMilestone(&input.MoveToMilestoneID, milestone.ProjectID); err != nil {
+			utils.SendError(w, r, http.StatusBadRequest, referenceCode(err))
+			return
+		}
+	}
+	var openTasks int64
+	db.DB.Model(&models.Task{}).Where("milestone_id = ? and status <> ?", milestone.ID, "completed").Count(&openTasks)
+	if openTasks > 0 && input.MoveToMilestoneID == "" && !input.KeepOpenTasks {
+		utils.SendError(w, r, http.StatusConflict, "milestone.has_open_tasks", strconv.FormatInt(openTasks, 10))
+		return
+	}
+	now := time.Now().UTC()
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if input.MoveToMilestoneID != "" {
+			err := recordTasksChange(tx, r, "milestone_id", milestone.ID, input.MoveToMilestoneID,
+				"milestone_id = ? and status <> ?", milestone.ID, "completed")
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&models.Task{}).
+				Where("milestone_id = ? and status <> ?", milestone.ID, "completed").
+				Update("milestone_id", input.MoveToMilestoneID).Error
+			if err != nil {
+				return err
+			}
+		}
+		milestone.State = "closed"
+		milestone.ClosedAt = &now
+		if err := saveVersioned(tx, &milestone, milestone.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.MilestoneClosed, events.Key("milestone", milestone.ID), milestone.ProjectID, milestone)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	progress, err := milestoneProgress(milestone)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
+
+// ReopenMilestone reopens a closed milestone
+// @Summary Reopen a milestone
+// @Tags Milestones
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param milestoneId path string true "Milestone ID"
+// @Success 200 {object} models.Milestone
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/milestones/{milestoneId}/reopen [post]
+func ReopenMilestone(w http.ResponseWriter, r *http.Request) {
+	milestone, ok := loadManagedMilestone(w, r)
+	if !ok {
+		return
+	}
+	milestone.State = "open"
+	milestone.ClosedAt = nil
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &milestone, milestone.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.MilestoneReopened, events.Key("milestone", milestone.ID), milestone.ProjectID, milestone)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(milestone)
+}
+
+// DeleteMilestone deletes a milestone
+// @Summary Delete a milestone
+// @Description Delete a milestone; its tasks are kept without a milestone
+// @Tags Milestones
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param milestoneId path string true "Milestone ID"
+// @Param If-Match header string true "ETag of the milestone"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Milestone changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/milestones/{milestoneId} [delete]
+func DeleteMilestone(w http.ResponseWriter, r *http.Request) {
+	milestone, ok := loadManagedMilestone(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, milestone.Version) {
+		return
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := recordTasksChange(tx, r, "milestone_id", milestone.ID, nil, "milestone_id = ?", milestone.ID)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Task{}).Where("milestone_id = ?", milestone.ID).Update("milestone_id", nil).Error
+		if err != nil {
+			return err
+		}
+		if err := deleteVersioned(tx, &milestone, milestone.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.MilestoneDeleted, events.Key("milestone", milestone.ID), milestone.ProjectID, deleted{milestone.ID})
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadManagedMilestone loads the milestone from the path and checks that the
+// user owns its project or is admin.
+func loadManagedMilestone(w http.ResponseWriter, r *http.Request) (models.Milestone, bool) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var milestone models.Milestone
+	err := db.DB.First(&milestone, "id = ?", r.PathValue("milestoneId")).Error
+	if err != nil || !inProject(r, milestone.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "milestone.not_found")
+		return milestone, false
+	}
+	var project models.Project
+	err = db.DB.First(&project, "id = ?", milestone.ProjectID).Error
+	if err != nil || (project.OwnerID != userID && role != "admin") {
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
+		return milestone, false
+	}
+	return milestone, true
+}
@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/i18n"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/notifications"
+	"github.com/Anwarjondev/task-management-api/pagination"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm/clause"
+)
+
+// GetNotifications lists the notifications of the user
+// @Summary List notifications
+// @Description List the user's notifications, newest first
+// @Tags Notifications
+// @Produce json
+// @Security BearerAuth
+// @Param unread query bool false "Only unread notifications"
+// @Param limit query int false "Items per page, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param include_total query bool false "Include the total count"
+// @Success 200 {object} pagination.Page[models.Notification]
+// @Failure 400 {object} utils.Problem "Invalid cursor"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/notifications [get]
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	params, err := pagination.Parse(r)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "pagination.invalid_cursor")
+		return
+	}
+	query := db.DB.Model(&models.Notification{}).Where("user_id = ?", userID)
+	if r.URL.Query().Get("unread") == "true" {
+		query = query.Where("read_at is null")
+	}
+	total, err := pagination.Total(query, params)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	var items []models.Notification
+	err = params.ApplyOrder(query, "notification", pagination.Order{Desc: true}).Find(&items).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	pagination.Write(w, r, params, items, notificationCursor, total)
+}
+
+func notificationCursor(notification models.Notification) pagination.Cursor {
+	return pagination.Cursor{CreatedAt: notification.CreatedAt, ID: notification.ID}
+}
+
+// GetUnreadNotificationCount counts the unread notifications of the user
+// @Summary Count unread notifications
+// @Tags Notifications
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]int64
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/notifications/unread_count [get]
+func GetUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	var count int64
+	err := db.DB.Model(&models.Notification{}).Where("user_id = ? and read_at is null", userID).Count(&count).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"unread": count})
+}
+
+// MarkNotificationRead marks a notification as read
+// @Summary Mark a notification read
+// @Tags Notifications
+// @Produce json
+// @Security BearerAuth
+// @Param notificationId path string true "Notification ID"
+// @Success 200 {object} models.Notification
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/notifications/{notificationId}/read [post]
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	var notification models.Notification
+	err := db.DB.First(&notification, "id = ? and user_id = ?", r.PathValue("notificationId"), userID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "notification.not_found")
+		return
+	}
+	if notification.ReadAt == nil {
+		now := time.Now().UTC()
+		notification.ReadAt = &now
+		err = db.DB.Model(&notification).Update("read_at", now).Error
+		if err != nil {
+			utils.SendInternalError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notification)
+}
+
+// MarkAllNotificationsRead marks every notification of the user as read
+// @Summary Mark all notifications read
+// @Tags Notifications
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]int64
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/notifications/read_all [post]
+func MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	result := db.DB.Model(&models.Notification{}).
+		Where("user_id = ? and read_at is null", userID).
+		Update("read_at", time.Now().UTC())
+	if result.Error != nil {
+		utils.SendInternalError(w, r, result.Error)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"updated": result.RowsAffected})
+}
+
+// GetNotificationPreferences lists the notification preferences of the user
+// @Summary Get notification preferences
+// @Description List every notification type with whether it notifies the user
+// @Tags Notifications
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.NotificationPreference
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/notifications/preferences [get]
+func GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	preferences, err := notificationPreferences(userID)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// UpdateNotificationPreferences turns notification types on or off
+// @Summary Update notification preferences
+// @Description Turn the given notification types on or off; types left out keep their setting
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param preferences body object true "preferences: list of type and enabled"
+// @Success 200 {array} models.NotificationPreference
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/notifications/preferences [put]
+func UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	var input struct {
+		Preferences []models.NotificationPreference `json:"preferences" validate:"required,dive"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	err = validate.Struct(&input)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	// A type given twice takes its last setting.
+	byType := map[string]models.NotificationPreference{}
+	for _, p := range input.Preferences {
+		p.UserID = userID
+		byType[p.Type] = p
+	}
+	if len(byType) > 0 {
+		changed := slices.Collect(maps.Values(byType))
+		err = db.DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}},
+			DoUpdates: clause.AssignmentColumns([]string{"enabled"}),
+		}).Create(&changed).Error
+		if err != nil {
+			utils.SendInternalError(w, r, err)
+			return
+		}
+	}
+	preferences, err := notificationPreferences(userID)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preferences)
+}
+
+// notificationPreferences returns the setting of every notification type
+// for a user.
+func notificationPreferences(userID string) ([]models.NotificationPreference, error) {
+	var stored []models.NotificationPreference
+	err := db.DB.Where("user_id = ?", userID).Find(&stored).Error
+	if err != nil {
+		return nil, err
+	}
+	preferences := make([]models.NotificationPreference, len(notifications.Types))
+	for i, typ := range notifications.Types {
+		preferences[i] = models.NotificationPreference{UserID: userID, Type: typ, Enabled: true}
+		for _, p := range stored {
+			if p.Type == typ {
+				preferences[i].Enabled = p.Enabled
+			}
+		}
+	}
+	return preferences, nil
+}
+
+// GetEmailSettings returns how the user is emailed about notifications
+// @Summary Get email settings
+// @Tags Notifications
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.EmailSettings
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/notifications/email [get]
+func GetEmailSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	settings := models.EmailSettings{UserID: userID, Delivery: "immediate"}
+	err := db.DB.Limit(1).Find(&settings, "user_id = ?", userID).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// UpdateEmailSettings sets how the user is emailed about notifications
+// @Summary Update email settings
+// @Description Choose immediate emails, a daily digest or no email. Emails go to the address of the user's profile.
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param settings body models.EmailSettings true "Email settings"
+// @Success 200 {object} models.EmailSettings
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/notifications/email [put]
+func UpdateEmailSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	var input models.EmailSettings
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	err = validate.Struct(&input)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	settings, err := setEmailDelivery(userID, input.Delivery)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// ConfirmUnsubscribe asks to confirm an unsubscribe link
+// @Summary Confirm unsubscribing from email
+// @Description Show a page asking to confirm turning off notification email. Opening the link changes
+// @Description nothing, so link scanners and prefetching cannot unsubscribe anyone; the page posts the
+// @Description confirmation to POST /v1/unsubscribe.
+// @Tags Notifications
+// @Produce html
+// @Param user query string true "User ID"
+// @Param sig query string true "Signature"
+// @Success 200 {string} string "Confirmation page"
+// @Failure 400 {object} utils.Problem "Invalid link"
+// @Router /v1/unsubscribe [get]
+func ConfirmUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	if _, ok := unsubscribeUser(w, r); !ok {
+		return
+	}
+	writeUnsubscribePage(w, r, false)
+}
+
+// Unsubscribe turns off notification email through a signed link
+// @Summary Unsubscribe from email
+// @Description Turn off notification email for the user of a signed unsubscribe link. Also serves
+// @Description one-click unsubscribe (RFC 8058) from mail clients. A browser posting the confirmation
+// @Description form gets a page back.
+// @Tags Notifications
+// @Produce json
+// @Param user query string true "User ID"
+// @Param sig query string true "Signature"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} utils.Problem "Invalid link"
+// @Router /v1/unsubscribe [post]
+func Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := unsubscribeUser(w, r)
+	if !ok {
+		return
+	}
+	if _, err := setEmailDelivery(userID, "off"); err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		writeUnsubscribePage(w, r, true)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": i18n.T(r, "notification.unsubscribed", "")})
+}
+
+// unsubscribeUser returns the user of a signed unsubscribe link.
+func unsubscribeUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.URL.Query().Get("user")
+	if userID == "" || !notifications.VerifyUnsubscribe(userID, r.URL.Query().Get("sig")) {
+		utils.SendError(w, r, http.StatusBadRequest, "notification.invalid_unsubscribe_link")
+		return "", false
+	}
+	var count int64
+	db.DB.Model(&models.User{}).Where("id = ?", userID).Count(&count)
+	if count == 0 {
+		utils.SendError(w, r, http.StatusBadRequest, "notification.invalid_unsubscribe_link")
+		return "", false
+	}
+	return userID, true
+}
+
+func writeUnsubscribePage(w http.ResponseWriter, r *http.Request, done bool) {
+	page := notifications.UnsubscribePage{
+		Lang:    i18n.Locale(r),
+		Title:   i18n.T(r, "notification.unsubscribe_title", ""),
+		Message: i18n.T(r, "notification.unsubscribe_confirm", ""),
+		Button:  i18n.T(r, "notification.unsubscribe_button", ""),
+		Action:  r.URL.RequestURI(),
+		Done:    done,
+	}
+	if done {
+		page.Message = i18n.T(r, "notification.unsubscribed", "")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := notifications.RenderUnsubscribePage(w, page); err != nil {
+		log.Println("Error rendering the unsubscribe page:", err)
+	}
+}
+
+func setEmailDelivery(userID, delivery string) (models.EmailSettings, error) {
+	settings := models.EmailSettings{UserID: userID, Delivery: delivery}
+	err := db.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"delivery"}),
+	}).Create(&settings).Error
+	if err != nil {
+		return settings, err
+	}
+	err = db.DB.First(&settings, "user_id = ?", userID).Error
+	return settings, err
+}
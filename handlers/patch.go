@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/Anwarjondev/task-management-api/mergepatch"
+	"github.com/Anwarjondev/task-management-api/utils"
+)
+
+// applyMergePatch reads a JSON merge patch (RFC 7396) from the request and
+// applies it to target, a copy of the stored model. Only the writable
+// fields may be patched. The caller revalidates target.
+func applyMergePatch(w http.ResponseWriter, r *http.Request, target any, writable ...string) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		utils.SendError(w, r, http.StatusUnsupportedMediaType, utils.CodeUnsupportedMedia, mediaType)
+		return false
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, utils.CodeMalformedBody)
+		return false
+	}
+	err = mergepatch.Apply(target, patch, writable...)
+	var fieldErr *mergepatch.FieldError
+	if errors.As(err, &fieldErr) {
+		utils.SendReadOnlyError(w, r, fieldErr.Field)
+		return false
+	}
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return false
+	}
+	return true
+}
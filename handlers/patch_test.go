@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Anwarjondev/task-management-api/models"
+)
+
+func patchRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPatch, "/v1/projects/p/tasks/t", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	return r
+}
+
+func TestMergePatchClearsAssignee(t *testing.T) {
+	assignee := "7f6c0b8e-1c1e-4c55-9d7e-3f1f0c2a9b10"
+	task := models.Task{Title: "Write report", AssigneeID: &assignee}
+
+	w := httptest.NewRecorder()
+	if !applyMergePatch(w, patchRequest(`{"assignee_id": null}`), &task, "assignee_id") {
+		t.Fatalf("patch refused: %d %s", w.Code, w.Body)
+	}
+	// A null assignee must reach the database as NULL: "" is not a uuid.
+	if task.AssigneeID != nil {
+		t.Errorf("assignee_id = %q after patching null, want nil", *task.AssigneeID)
+	}
+	if task.AssignedTo("") || task.AssigneeUserID() != "" {
+		t.Error("an unassigned task reports an assignee")
+	}
+
+	subtask := models.Subtask{Title: "Draft", AssigneeID: &assignee}
+	if !applyMergePatch(httptest.NewRecorder(), patchRequest(`{"assignee_id": null}`), &subtask, "assignee_id") {
+		t.Fatal("subtask patch refused")
+	}
+	if subtask.AssigneeID != nil {
+		t.Errorf("subtask assignee_id = %q after patching null, want nil", *subtask.AssigneeID)
+	}
+}
+
+func TestMergePatchSetsAssignee(t *testing.T) {
+	task := models.Task{Title: "Write report"}
+	w := httptest.NewRecorder()
+	if !applyMergePatch(w, patchRequest(`{"assignee_id": "u2"}`), &task, "assignee_id") {
+		t.Fatalf("patch refused: %d %s", w.Code, w.Body)
+	}
+	if !task.AssignedTo("u2") {
+		t.Errorf("assignee_id = %v, want u2", task.AssigneeID)
+	}
+}
+
+func TestOptionalIDClearsEmpty(t *testing.T) {
+	empty, id := "", "u1"
+	if optionalID(&empty) != nil || optionalID(nil) != nil {
+		t.Error(`optionalID kept "" or nil`)
+	}
+	if got := optionalID(&id); got == nil || *got != "u1" {
+		t.Errorf("optionalID(u1) = %v", got)
+	}
+}
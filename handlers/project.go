@@ -3,11 +3,15 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
 
 	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
 	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/pagination"
 	"github.com/Anwarjondev/task-management-api/utils"
+	"github.com/Anwarjondev/task-management-api/watchers"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateProject creates a new project
@@ -19,27 +23,35 @@ import (
 // @Security BearerAuth
 // @Param project body models.Project true "Project data"
 // @Success 201 {object} models.Project
-// @Failure 400 {string} string "Invalid request"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 500 {string} string "Server error"
-// @Router /createproject [post]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Router /v1/projects [post]
 func CreateProject(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	var project models.Project
 	err := json.NewDecoder(r.Body).Decode(&project)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		utils.SendDecodeError(w, r, err)
 		return
 	}
 	err = validate.Struct(&project)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Validation failed: "+err.Error())
+		utils.SendValidationError(w, r, err)
 		return
 	}
 	project.OwnerID = userID
-	err = db.DB.Create(&project).Error
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&project).Error; err != nil {
+			return err
+		}
+		if err := watchers.Watch(tx, userID, watchers.Project, project.ID, project.ID); err != nil {
+			return err
+		}
+		return record(tx, r, events.ProjectCreated, events.Key("project", project.ID), project.ID, project)
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error with creating project: "+err.Error())
+		utils.SendInternalError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -49,46 +61,89 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 
 // GetProjects lists projects with pagination
 // @Summary List projects
-// @Description Get projects accessible to the user with pagination
+// @Description Get projects accessible to the user. Pages by cursor unless page or per_page is given.
 // @Tags Projects
 // @Produce json
 // @Security BearerAuth
-// @Param page query int false "Page number" default(1)
-// @Param per_page query int false "Items per page" default(10)
-// @Success 200 {array} models.Project
-// @Failure 401 {string} string "Unauthorized"
-// @Router /getproject [get]
+// @Param limit query int false "Items per page, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param include_total query bool false "Include the total count"
+// @Param page query int false "Page number (offset mode)"
+// @Param per_page query int false "Items per page (offset mode)"
+// @Param filter query string false "Filter expression, e.g. owner=me and name~api"
+// @Success 200 {object} pagination.Page[models.Project]
+// @Failure 400 {object} utils.Problem "Invalid cursor or filter"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/projects [get]
 func GetProject(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
+	params, err := pagination.Parse(r)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "pagination.invalid_cursor")
+		return
+	}
+
+	query := db.DB.Model(&models.Project{})
+	if role != "admin" {
+		query = query.Where("project.owner_id = ? or exists (select 1 from project_members where project_members.project_id = project.id and project_members.user_id = ?)", userID, userID)
+	}
+	query, ok := applyFilter(w, r, query, projectFilterSchema)
+	if !ok {
+		return
 	}
-	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
-	if perPage < 1 {
-		perPage = 10
+	total, err := pagination.Total(query, params)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
 	}
-	offset := (page -1) *perPage
 	var projects []models.Project
+	err = params.Apply(query, "project").Find(&projects).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	pagination.Write(w, r, params, projects, projectCursor, total)
+}
 
-	query := db.DB.Limit(perPage).Offset(offset)
-	if role == "admin" {
-		err := query.Find(&projects).Error
-		if err != nil {
-			utils.SendError(w, http.StatusInternalServerError, "Error fetch projects: "+err.Error())
-			return
-		}
-		
-	} else {
-		err := query.Joins("Join project_members on project_members.project_id = project.id").Where("project_members.user_id = ? or project.owner_id = ?", userID, userID).Find(&projects).Error
-		if err != nil {
-			utils.SendError(w, http.StatusInternalServerError, "Failed to fetch all projects: "+err.Error())
-			return
-		}
+func projectCursor(project models.Project) pagination.Cursor {
+	return pagination.Cursor{CreatedAt: project.CreatedAt, ID: project.ID}
+}
+
+// GetProjectByID returns a single project
+// @Summary Get a project
+// @Description Get a project the user owns or is a member of
+// @Tags Projects
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} models.Project
+// @Header 200 {string} ETag "Version of the project"
+// @Success 304 {string} string "Not modified"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id} [get]
+func GetProjectByID(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.Preload("Members").First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	if utils.NotModified(w, r, project.Version) {
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(projects)
+	json.NewEncoder(w).Encode(project)
 }
 
 // UpdateProject updates a project
@@ -100,45 +155,103 @@ func GetProject(w http.ResponseWriter, r *http.Request) {
 // @Security BearerAuth
 // @Param id path string true "Project ID"
 // @Param project body models.Project true "Updated project data"
+// @Param If-Match header string true "ETag of the project"
 // @Success 200 {object} models.Project
-// @Failure 400 {string} string "Invalid request"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Forbidden"
-// @Failure 500 {string} string "Server error"
-// @Router /projects/{id} [put]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Failure 412 {object} utils.Problem "Project changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id} [put]
 func UpdateProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := loadEditableProject(w, r)
+	if !ok {
+		return
+	}
+	var updateProject models.Project
+	err := json.NewDecoder(r.Body).Decode(&updateProject)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	saveProject(w, r, project, updateProject)
+}
+
+// PatchProject partially updates a project
+// @Summary Patch a project
+// @Description Change some fields of the project with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: name, description.
+// @Tags Projects
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param project body models.Project true "Fields to change"
+// @Param If-Match header string true "ETag of the project"
+// @Success 200 {object} models.Project
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Project changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id} [patch]
+func PatchProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := loadEditableProject(w, r)
+	if !ok {
+		return
+	}
+	updateProject := project
+	if !applyMergePatch(w, r, &updateProject, "name", "description") {
+		return
+	}
+	saveProject(w, r, project, updateProject)
+}
+
+// loadEditableProject loads the {id} project for a write by its owner or an
+// admin, checking If-Match.
+func loadEditableProject(w http.ResponseWriter, r *http.Request) (models.Project, bool) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
-	id := r.URL.Path[len("/updateproject/"):]
 
 	var project models.Project
-	err := db.DB.First(&project, "id = ?", id).Error
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "Project Not found: "+err.Error())
-		return
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return project, false
 	}
 	if project.OwnerID != userID && role != "admin" {
-		utils.SendError(w,http.StatusNotFound, "You are not authorized: "+err.Error())
-		return
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
+		return project, false
 	}
-	var updateProject models.Project
-	err = json.NewDecoder(r.Body).Decode(&updateProject)
-	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Bad request: "+err.Error())
-		return
+	if !utils.CheckIfMatch(w, r, project.Version) {
+		return project, false
 	}
-	err = validate.Struct(&updateProject)
+	return project, true
+}
+
+// saveProject validates updateProject and saves its editable fields to
+// project.
+func saveProject(w http.ResponseWriter, r *http.Request, project, updateProject models.Project) {
+	err := validate.Struct(&updateProject)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Validation failed: "+err.Error())
+		utils.SendValidationError(w, r, err)
 		return
 	}
 	project.Name = updateProject.Name
 	project.Description = updateProject.Description
-	err = db.DB.Save(&project).Error
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &project, project.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.ProjectUpdated, events.Key("project", project.ID), project.ID, project)
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Failed to update project: "+err.Error())
+		sendWriteError(w, r, err)
 		return
 	}
+	utils.SetETag(w, project.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(project)
 }
@@ -150,29 +263,43 @@ func UpdateProject(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Security BearerAuth
 // @Param id path string true "Project ID"
+// @Param If-Match header string true "ETag of the project"
 // @Success 204 {string} string "No content"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Forbidden"
-// @Failure 404 {string} string "Not found"
-// @Router /projects/{id} [delete]
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Project changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id} [delete]
 func DeleteProject(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
-	id := r.URL.Path[len("/deleteproject/"):]
+	id := r.PathValue("id")
 
 	var project models.Project
 	err := db.DB.First(&project, "id = ?", id).Error
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "Project not found: "+err.Error())
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
 		return
 	}
 	if project.OwnerID != userID && role != "admin" {
-		utils.SendError(w, http.StatusForbidden, "You are not authorized to delete: "+err.Error())
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, project.Version) {
 		return
 	}
-	err = db.DB.Delete(&project).Error
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := watchers.Forget(tx, watchers.Project, project.ID); err != nil {
+			return err
+		}
+		if err := deleteVersioned(tx, &project, project.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.ProjectDeleted, events.Key("project", project.ID), project.ID, deleted{project.ID})
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error deleting project: "+err.Error())
+		sendWriteError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -188,24 +315,24 @@ func DeleteProject(w http.ResponseWriter, r *http.Request) {
 // @Param id path string true "Project ID"
 // @Param user body map[string]string true "User ID to add"
 // @Success 200 {object} models.Project
-// @Failure 400 {string} string "Invalid request"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Forbidden"
-// @Failure 404 {string} string "Not found"
-// @Router /projects/{id}/members [post]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/members [post]
 func AddProjectMember(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
-	id := r.URL.Path[len("/projects/"):len(r.URL.Path)-len("/members")]
+	id := r.PathValue("id")
 
 	var project models.Project
 	err := db.DB.Preload("Members").First(&project, "id = ?", id).Error
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "Project not found: "+err.Error())
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
 		return
 	}
 	if project.OwnerID != userID && role != "admin" {
-		utils.SendError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
 		return
 	}
 	var input struct {
@@ -213,26 +340,52 @@ func AddProjectMember(w http.ResponseWriter, r *http.Request) {
 	}
 	err = json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		utils.SendDecodeError(w, r, err)
 		return
 	}
 	err  = validate.Struct(&input)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Validation error: "+err.Error())
+		utils.SendValidationError(w, r, err)
 		return
 	}
 	var user models.User
 	err = db.DB.First(&user, "id = ?", input.UserID).Error
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "User not found: "+err.Error())
+		utils.SendError(w, r, http.StatusNotFound, "user.not_found")
 		return
 	}
 	project.Members = append(project.Members, user)
-	err = db.DB.Save(&project).Error
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Returning{Columns: []clause.Column{{Name: "version"}}}).Save(&project).Error
+		if err != nil {
+			return err
+		}
+		return record(tx, r, events.ProjectMemberAdded, events.Key("project", project.ID), project.ID, memberAdded{project.ID, user.ID, user.Username})
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error adding members: "+err.Error())
+		utils.SendInternalError(w, r, err)
 		return
 	}
+	utils.SetETag(w, project.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(project)
 }
+
+// canAccessProject reports whether the user owns or is a member of the
+// project. Admins can access every project.
+func canAccessProject(project *models.Project, userID, role string) bool {
+	if role == "admin" || project.OwnerID == userID {
+		return true
+	}
+	var count int64
+	db.DB.Table("project_members").Where("project_id = ? and user_id = ?", project.ID, userID).Count(&count)
+	return count > 0
+}
+
+// inProject reports whether a resource of projectID may be served on this
+// route: routes nested under /projects/{id} only serve that project's
+// resources, flat routes serve any.
+func inProject(r *http.Request, projectID string) bool {
+	id := r.PathValue("id")
+	return id == "" || id == projectID
+}
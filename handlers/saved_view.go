@@ -0,0 +1,588 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/filter"
+	"github.com/Anwarjondev/task-management-api/i18n"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/pagination"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+// viewSort is a sort a saved view can use: the SQL order and the value of
+// a task under that order, for cursors.
+type viewSort struct {
+	order pagination.Order
+	value func(models.Task) string
+}
+
+var viewSorts = map[string]viewSort{
+	"created": {},
+	"due": {
+		order: pagination.Order{Expr: "coalesce(task.due_date, 'infinity')", Type: "timestamptz"},
+		value: func(t models.Task) string {
+			if t.DueDate == nil {
+				return "infinity"
+			}
+			return t.DueDate.UTC().Format(time.RFC3339Nano)
+		},
+	},
+	"title": {
+		order: pagination.Order{Expr: "task.title", Type: "text"},
+		value: func(t models.Task) string { return t.Title },
+	},
+	"status": {
+		order: pagination.Order{Expr: "task.status", Type: "text"},
+		value: func(t models.Task) string { return t.Status },
+	},
+	"estimate": {
+		order: pagination.Order{Expr: "task.estimate_minutes", Type: "integer"},
+		value: func(t models.Task) string { return strconv.Itoa(t.EstimateMinutes) },
+	},
+}
+
+// lookupViewSort resolves a view's sort such as "-due". The empty sort is
+// "created".
+func lookupViewSort(sort string) viewSort {
+	desc := strings.HasPrefix(sort, "-")
+	s := viewSorts[strings.TrimPrefix(sort, "-")]
+	s.order.Desc = desc
+	return s
+}
+
+// viewReferenceModels are the filter fields whose values are row ids.
+var viewReferenceModels = map[string]any{
+	"assignee":  &models.User{},
+	"creator":   &models.User{},
+	"project":   &models.Project{},
+	"milestone": &models.Milestone{},
+	"sprint":    &models.Sprint{},
+}
+
+// CreateSavedView saves a task view
+// @Summary Create a saved view
+// @Description Save a named task filter, sort and column list. With shared set the view is visible to every member of project_id.
+// @Tags Saved views
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param view body models.SavedView true "View data"
+// @Success 201 {object} models.SavedView
+// @Failure 400 {object} utils.Problem "Invalid request, filter or reference"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Project not found"
+// @Router /v1/views [post]
+func CreateSavedView(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var view models.SavedView
+	err := json.NewDecoder(r.Body).Decode(&view)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	if !checkSavedView(w, r, &view, userID, role) {
+		return
+	}
+	view.OwnerID = userID
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&view).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.ViewCreated, events.Key("view", view.ID), viewProjectID(view), view)
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// GetSavedViews lists the views of the user
+// @Summary List saved views
+// @Description List the user's own views and the views shared to projects the user is a member of
+// @Tags Saved views
+// @Produce json
+// @Security BearerAuth
+// @Param project_id query string false "Only views of this project"
+// @Success 200 {array} models.SavedView
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/views [get]
+func GetSavedViews(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	query := db.DB.Model(&models.SavedView{})
+	if role == "admin" {
+		query = query.Where("owner_id = ? or shared", userID)
+	} else {
+		query = query.Where("owner_id = ? or (shared and project_id in (select id from project where owner_id = ? "+
+			"union select project_id from project_members where user_id = ?))", userID, userID, userID)
+	}
+	if projectID := r.URL.Query().Get("project_id"); projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	views := []models.SavedView{}
+	err := query.Order("name").Find(&views).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	for i := range views {
+		err = checkViewReferences(&views[i])
+		if err != nil {
+			utils.SendInternalError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// GetSavedView returns a saved view
+// @Summary Get a saved view
+// @Description Get a view with its validity; invalid_references lists labels and users in the filter that no longer exist
+// @Tags Saved views
+// @Produce json
+// @Security BearerAuth
+// @Param viewId path string true "View ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} models.SavedView
+// @Header 200 {string} ETag "Version of the view"
+// @Success 304 {string} string "Not modified"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/views/{viewId} [get]
+func GetSavedView(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	view, ok := loadSavedView(w, r)
+	if !ok {
+		return
+	}
+	if !canReadView(&view, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "view.forbidden")
+		return
+	}
+	if utils.NotModified(w, r, view.Version) {
+		return
+	}
+	err := checkViewReferences(&view)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// UpdateSavedView updates a saved view
+// @Summary Update a saved view
+// @Description Replace the name, filter, sort, columns and sharing of a view. Only the owner or an admin can update it.
+// @Tags Saved views
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param viewId path string true "View ID"
+// @Param view body models.SavedView true "Updated view data"
+// @Param If-Match header string true "ETag of the view"
+// @Success 200 {object} models.SavedView
+// @Failure 400 {object} utils.Problem "Invalid request, filter or reference"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "View changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/views/{viewId} [put]
+func UpdateSavedView(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	view, ok := loadSavedView(w, r)
+	if !ok {
+		return
+	}
+	if role != "admin" && view.OwnerID != userID {
+		utils.SendError(w, r, http.StatusForbidden, "view.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, view.Version) {
+		return
+	}
+	var updateView models.SavedView
+	err := json.NewDecoder(r.Body).Decode(&updateView)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	saveSavedView(w, r, view, updateView)
+}
+
+// PatchSavedView partially updates a saved view
+// @Summary Patch a saved view
+// @Description Change some fields of the view with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: name, project_id, shared, filter, sort, columns.
+// @Tags Saved views
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param viewId path string true "View ID"
+// @Param view body models.SavedView true "Fields to change"
+// @Param If-Match header string true "ETag of the view"
+// @Success 200 {object} models.SavedView
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "View changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/views/{viewId} [patch]
+func PatchSavedView(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	view, ok := loadSavedView(w, r)
+	if !ok {
+		return
+	}
+	if role != "admin" && view.OwnerID != userID {
+		utils.SendError(w, r, http.StatusForbidden, "view.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, view.Version) {
+		return
+	}
+	updateView := view
+	if !applyMergePatch(w, r, &updateView, "name", "project_id", "shared", "filter", "sort", "columns") {
+		return
+	}
+	saveSavedView(w, r, view, updateView)
+}
+
+// saveSavedView checks updateView and saves its editable fields to view.
+func saveSavedView(w http.ResponseWriter, r *http.Request, view, updateView models.SavedView) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	if !checkSavedView(w, r, &updateView, userID, role) {
+		return
+	}
+	view.Name = updateView.Name
+	view.ProjectID = updateView.ProjectID
+	view.Shared = updateView.Shared
+	view.Filter = updateView.Filter
+	view.Sort = updateView.Sort
+	view.Columns = updateView.Columns
+	view.Valid = true
+	view.InvalidReferences = nil
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &view, view.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.ViewUpdated, events.Key("view", view.ID), viewProjectID(view), view)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	utils.SetETag(w, view.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// DeleteSavedView deletes a saved view
+// @Summary Delete a saved view
+// @Description Delete a view. Only the owner or an admin can delete it.
+// @Tags Saved views
+// @Security BearerAuth
+// @Param viewId path string true "View ID"
+// @Param If-Match header string true "ETag of the view"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "View changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/views/{viewId} [delete]
+func DeleteSavedView(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	view, ok := loadSavedView(w, r)
+	if !ok {
+		return
+	}
+	if role != "admin" && view.OwnerID != userID {
+		utils.SendError(w, r, http.StatusForbidden, "view.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, view.Version) {
+		return
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &view, view.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.ViewDeleted, events.Key("view", view.ID), viewProjectID(view), deleted{view.ID})
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunSavedView lists the tasks matching a saved view
+// @Summary Run a saved view
+// @Description List the tasks matching a view's filter in the view's sort order. A view whose filter names a deleted label or user answers 422.
+// @Tags Saved views
+// @Produce json
+// @Security BearerAuth
+// @Param viewId path string true "View ID"
+// @Param limit query int false "Items per page, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param include_total query bool false "Include the total count"
+// @Param page query int false "Page number (offset mode)"
+// @Param per_page query int false "Items per page (offset mode)"
+// @Success 200 {object} pagination.Page[models.Task]
+// @Failure 400 {object} utils.Problem "Invalid cursor"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 422 {object} utils.Problem "View is invalid"
+// @Router /v1/views/{viewId}/tasks [get]
+func RunSavedView(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	view, ok := loadSavedView(w, r)
+	if !ok {
+		return
+	}
+	if !canReadView(&view, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "view.forbidden")
+		return
+	}
+	sort := lookupViewSort(view.Sort)
+	params, err := pagination.Parse(r)
+	if err == nil {
+		err = params.CheckOrder(sort.order)
+	}
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "pagination.invalid_cursor")
+		return
+	}
+	err = checkViewReferences(&view)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	if !view.Valid {
+		sendInvalidView(w, r, http.StatusUnprocessableEntity, &view)
+		return
+	}
+
+	query := db.DB.Model(&models.Task{})
+	if view.ProjectID != nil {
+		query = query.Where("task.project_id = ?", *view.ProjectID)
+	}
+	if role != "admin" {
+		query = query.Where("creator_id = ? or assignee_id = ?", userID, userID)
+	}
+	query, ok = filterQuery(w, r, query, view.Filter, taskFilterSchema)
+	if !ok {
+		return
+	}
+	total, err := pagination.Total(query, params)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	var tasks []models.Task
+	err = params.ApplyOrder(query.Preload("Labels"), "task", sort.order).Find(&tasks).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	err = fillTimeTotals(tasks)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	key := taskCursor
+	if sort.value != nil {
+		key = func(task models.Task) pagination.Cursor {
+			cursor := taskCursor(task)
+			cursor.Value = sort.value(task)
+			return cursor
+		}
+	}
+	pagination.Write(w, r, params, tasks, key, total)
+}
+
+func loadSavedView(w http.ResponseWriter, r *http.Request) (models.SavedView, bool) {
+	var view models.SavedView
+	err := db.DB.First(&view, "id = ?", r.PathValue("viewId")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "view.not_found")
+		return view, false
+	}
+	return view, true
+}
+
+func canReadView(view *models.SavedView, userID, role string) bool {
+	if role == "admin" || view.OwnerID == userID {
+		return true
+	}
+	if !view.Shared || view.ProjectID == nil {
+		return false
+	}
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", *view.ProjectID).Error
+	return err == nil && canAccessProject(&project, userID, role)
+}
+
+// checkSavedView validates a view from a request body: its fields, its
+// filter against the task fields, access to its project and that every
+// label and user it names exists. It answers 4xx and returns false
+// otherwise.
+func checkSavedView(w http.ResponseWriter, r *http.Request, view *models.SavedView, userID, role string) bool {
+	view.Name = strings.TrimSpace(view.Name)
+	view.Filter = strings.TrimSpace(view.Filter)
+	if view.Sort == "created" {
+		view.Sort = ""
+	}
+	err := validate.Struct(view)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return false
+	}
+	if view.Filter != "" {
+		node, err := filter.Parse(view.Filter)
+		if err == nil {
+			err = filter.Check(node, taskFilterSchema)
+		}
+		if err != nil {
+			sendFilterError(w, r, err)
+			return false
+		}
+	}
+	if view.ProjectID != nil {
+		var project models.Project
+		err = db.DB.First(&project, "id = ?", *view.ProjectID).Error
+		if err != nil {
+			utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+			return false
+		}
+		if !canAccessProject(&project, userID, role) {
+			utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+			return false
+		}
+	}
+	err = checkViewReferences(view)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return false
+	}
+	if !view.Valid {
+		sendInvalidView(w, r, http.StatusBadRequest, view)
+		return false
+	}
+	return true
+}
+
+// checkViewReferences sets Valid and InvalidReferences of view from the
+// labels, users, projects, milestones and sprints its filter names. A
+// filter that no longer parses is reported as a whole.
+func checkViewReferences(view *models.SavedView) error {
+	view.InvalidReferences = nil
+	if view.ProjectID != nil {
+		found, err := rowExists(&models.Project{}, *view.ProjectID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			view.InvalidReferences = append(view.InvalidReferences, models.ViewReference{Field: "project_id", Value: *view.ProjectID})
+		}
+	}
+	if view.Filter != "" {
+		node, err := filter.Parse(view.Filter)
+		if err == nil {
+			err = filter.Check(node, taskFilterSchema)
+		}
+		if err != nil {
+			view.InvalidReferences = append(view.InvalidReferences, models.ViewReference{Field: "filter", Value: view.Filter})
+			node = nil
+		}
+		for _, cmp := range filter.Fields(node) {
+			for _, value := range cmp.Values {
+				found, err := viewValueExists(view, cmp.Field, value)
+				if err != nil {
+					return err
+				}
+				if !found {
+					view.InvalidReferences = append(view.InvalidReferences, models.ViewReference{Field: cmp.Field, Value: value})
+				}
+			}
+		}
+	}
+	view.Valid = len(view.InvalidReferences) == 0
+	return nil
+}
+
+// viewValueExists reports whether the row a filter value names exists.
+// Values of other fields, "me" and "none" always exist. Labels are matched
+// by name ignoring case, as the label filter does.
+func viewValueExists(view *models.SavedView, field, value string) (bool, error) {
+	if value == "me" || value == "none" {
+		return true, nil
+	}
+	if field == "label" {
+		var count int64
+		query := db.DB.Model(&models.Label{}).Where("lower(name) = lower(?)", value)
+		if view.ProjectID != nil {
+			query = query.Where("project_id = ?", *view.ProjectID)
+		}
+		err := query.Count(&count).Error
+		return count > 0, err
+	}
+	model, ok := viewReferenceModels[field]
+	if !ok {
+		return true, nil
+	}
+	return rowExists(model, value)
+}
+
+func rowExists(model any, id string) (bool, error) {
+	var count int64
+	err := db.DB.Model(model).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+// sendInvalidView answers with one error per reference that no longer
+// exists.
+func sendInvalidView(w http.ResponseWriter, r *http.Request, status int, view *models.SavedView) {
+	p := utils.Problem{Status: status, Code: "view.invalid", Detail: i18n.T(r, "view.invalid", "view.invalid")}
+	for _, ref := range view.InvalidReferences {
+		field := "filter"
+		if ref.Field == "project_id" {
+			field = ref.Field
+		}
+		p.Errors = append(p.Errors, utils.FieldError{
+			Field:   field,
+			Rule:    "exists",
+			Param:   ref.Value,
+			Message: i18n.T(r, "view.missing_reference", ref.Field+" "+ref.Value, ref.Field, ref.Value),
+		})
+	}
+	utils.SendProblem(w, r, p)
+}
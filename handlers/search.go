@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/pagination"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"github.com/google/uuid"
+)
+
+// SearchResult is one match of a search. Type is "project", "task",
+// "subtask" or "comment"; TaskID is set for subtasks and comments. Snippet
+// is the matching text with the matched words wrapped in <mark> tags.
+type SearchResult struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	ProjectID string    `json:"project_id"`
+	TaskID    *string   `json:"task_id,omitempty"`
+	Title     string    `json:"title"`
+	Snippet   string    `json:"snippet"`
+	Rank      float64   `json:"rank"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SearchResults is a page of search results, best match first.
+type SearchResults struct {
+	Data  []SearchResult `json:"data"`
+	Total int64          `json:"total"`
+}
+
+const headlineOptions = "'StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2'"
+
+// searchSource is the SQL of one result type. visibility mirrors the list
+// endpoint of the type; each of its placeholders takes the user id.
+type searchSource struct {
+	typ        string
+	query      string
+	projectCol string
+	visibility string
+}
+
+var searchSources = []searchSource{
+	{
+		typ: "project",
+		query: "select 'project' as type, project.id, project.id as project_id, cast(null as uuid) as task_id, project.name as title, " +
+			"ts_headline('simple', project.name || ' ' || coalesce(project.description, ''), q.query, " + headlineOptions + ") as snippet, " +
+			"ts_rank(project.search, q.query) as rank, project.created_at " +
+			"from q, project where project.search @@ q.query",
+		projectCol: "project.id",
+		visibility: "(project.owner_id = ? or exists (select 1 from project_members where project_members.project_id = project.id and project_members.user_id = ?))",
+	},
+	{
+		typ: "task",
+		query: "select 'task' as type, task.id, task.project_id, cast(null as uuid) as task_id, task.title, " +
+			"ts_headline('simple', task.title || ' ' || coalesce(task.description, ''), q.query, " + headlineOptions + ") as snippet, " +
+			"ts_rank(task.search, q.query) as rank, task.created_at " +
+			"from q, task where task.search @@ q.query",
+		projectCol: "task.project_id",
+		visibility: "(task.creator_id = ? or task.assignee_id = ?)",
+	},
+	{
+		typ: "subtask",
+		query: "select 'subtask' as type, subtask.id, task.project_id, subtask.task_id, subtask.title, " +
+			"ts_headline('simple', subtask.title, q.query, " + headlineOptions + ") as snippet, " +
+			"ts_rank(subtask.search, q.query) as rank, subtask.created_at " +
+			"from q, subtask join task on task.id = subtask.task_id where subtask.search @@ q.query",
+		projectCol: "task.project_id",
+		visibility: "(subtask.creator_id = ? or subtask.assignee_id = ?)",
+	},
+	{
+		typ: "comment",
+		query: "select 'comment' as type, comment.id, task.project_id, comment.task_id, task.title, " +
+			"ts_headline('simple', comment.body, q.query, " + headlineOptions + ") as snippet, " +
+			"ts_rank(comment.search, q.query) as rank, comment.created_at " +
+			"from q, comment join task on task.id = comment.task_id where comment.search @@ q.query",
+		projectCol: "task.project_id",
+		visibility: "(task.creator_id = ? or task.assignee_id = ?)",
+	},
+}
+
+// maxSearchTerms bounds the size of the generated tsquery.
+const maxSearchTerms = 16
+
+// Search searches projects, tasks, subtasks and comments
+// @Summary Full-text search
+// @Description Search the titles and descriptions of projects, tasks and subtasks and the bodies of comments. Every word matches as a prefix and all words must match. Results only include what the user could list.
+// @Tags Search
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Search text"
+// @Param type query string false "Comma separated result types: project, task, subtask, comment"
+// @Param project_id query string false "Only results in this project"
+// @Param limit query int false "Results per page, at most 100" default(20)
+// @Param page query int false "Page number" default(1)
+// @Success 200 {object} SearchResults
+// @Failure 400 {object} utils.Problem "Missing query or invalid project_id"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/search [get]
+func Search(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	q := r.URL.Query()
+	tsquery := searchQuery(q.Get("q"))
+	if tsquery == "" {
+		utils.SendError(w, r, http.StatusBadRequest, "search.query_required")
+		return
+	}
+	projectID := q.Get("project_id")
+	if projectID != "" {
+		if err := uuid.Validate(projectID); err != nil {
+			utils.SendError(w, r, http.StatusBadRequest, "search.invalid_project_id")
+			return
+		}
+	}
+	types := map[string]bool{}
+	for _, typ := range strings.Split(q.Get("type"), ",") {
+		if typ = strings.TrimSpace(typ); typ != "" {
+			types[typ] = true
+		}
+	}
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit < 1 {
+		limit = pagination.DefaultLimit
+	}
+	limit = min(limit, pagination.MaxLimit)
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	var parts []string
+	args := []any{tsquery}
+	for _, source := range searchSources {
+		if len(types) > 0 && !types[source.typ] {
+			continue
+		}
+		part := source.query
+		if projectID != "" {
+			part += " and " + source.projectCol + " = ?"
+			args = append(args, projectID)
+		}
+		if role != "admin" {
+			part += " and " + source.visibility
+			for range strings.Count(source.visibility, "?") {
+				args = append(args, userID)
+			}
+		}
+		parts = append(parts, part)
+	}
+	results := SearchResults{Data: []SearchResult{}}
+	if len(parts) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+	union := "with q as (select to_tsquery('simple', ?) as query) select * from (" + strings.Join(parts, " union all ") + ") results"
+
+	err = db.DB.Raw("select count(*) from ("+union+") counted", args...).Scan(&results.Total).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	err = db.DB.Raw(union+" order by rank desc, created_at desc, id limit ? offset ?",
+		append(args, limit, (page-1)*limit)...).Scan(&results.Data).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// searchQuery turns search text into a prefix tsquery: "fix log" becomes
+// "fix:* & log:*". Only letters and digits are kept, so the result is
+// always valid tsquery syntax.
+func searchQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
+	}
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
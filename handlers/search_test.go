@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchRejectsInvalidProjectID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/search?q=report&project_id=not-a-uuid", nil)
+	ctx := context.WithValue(r.Context(), "user_id", "7f6c0b8e-1c1e-4c55-9d7e-3f1f0c2a9b10")
+	ctx = context.WithValue(ctx, "role", "team_member")
+	w := httptest.NewRecorder()
+	Search(w, r.WithContext(ctx))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "search.invalid_project_id") {
+		t.Errorf("got %d %s, want 400 search.invalid_project_id", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,596 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+// SprintDetail is a sprint with its tasks and current estimate totals.
+type SprintDetail struct {
+	models.Sprint
+	Tasks           []models.Task `json:"tasks"`
+	EstimateMinutes int           `json:"estimate_minutes"`
+	DoneMinutes     int           `json:"done_minutes"`
+}
+
+// VelocityEntry is the outcome of one closed sprint.
+type VelocityEntry struct {
+	SprintID         string    `json:"sprint_id"`
+	Name             string    `json:"name"`
+	StartDate        time.Time `json:"start_date"`
+	EndDate          time.Time `json:"end_date"`
+	CommittedMinutes int       `json:"committed_minutes"`
+	CompletedMinutes int       `json:"completed_minutes"`
+	CompletedTasks   int       `json:"completed_tasks"`
+}
+
+// Velocity is the velocity history of a project.
+type Velocity struct {
+	Sprints []VelocityEntry `json:"sprints"`
+	// AverageMinutes is the mean completed estimate of the last three sprints.
+	AverageMinutes int `json:"average_minutes"`
+}
+
+var (
+	errSprintNotInProject = errors.New("sprint does not belong to the task's project")
+	errSprintClosed       = errors.New("sprint is closed")
+)
+
+// checkSprint verifies that a task may be put into the sprint.
+func checkSprint(sprintID *string, projectID string) error {
+	if sprintID == nil || *sprintID == "" {
+		return nil
+	}
+	var sprint models.Sprint
+	err := db.DB.First(&sprint, "id = ? and project_id = ?", *sprintID, projectID).Error
+	if err != nil {
+		return errSprintNotInProject
+	}
+	if sprint.State == "closed" {
+		return errSprintClosed
+	}
+	return nil
+}
+
+// sumEstimates returns the estimate total of the sprint's tasks, optionally
+// only of the completed ones, and how many tasks were counted.
+func sumEstimates(tx *gorm.DB, sprintID string, completedOnly bool) (minutes int, tasks int, err error) {
+	var row struct {
+		Minutes int
+		Tasks   int
+	}
+	query := tx.Model(&models.Task{}).
+		Select("coalesce(sum(estimate_minutes), 0) as minutes, count(*) as tasks").
+		Where("sprint_id = ?", sprintID)
+	if completedOnly {
+		query = query.Where("status = ?", "completed")
+	}
+	err = query.Scan(&row).Error
+	return row.Minutes, row.Tasks, err
+}
+
+// CreateSprint creates a planned sprint in a project
+// @Summary Create a sprint
+// @Description Plan a sprint with a goal, start date and end date
+// @Tags Sprints
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param sprint body models.Sprint true "Sprint data"
+// @Success 201 {object} models.Sprint
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/sprints [post]
+func CreateSprint(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if project.OwnerID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
+		return
+	}
+	var sprint models.Sprint
+	err = json.NewDecoder(r.Body).Decode(&sprint)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	err = validate.Struct(&sprint)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	sprint.ProjectID = project.ID
+	sprint.State = "planned"
+	sprint.CommittedMinutes = 0
+	sprint.CompletedMinutes = 0
+	sprint.CompletedTasks = 0
+	sprint.ClosedAt = nil
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sprint).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.SprintCreated, events.Key("sprint", sprint.ID), sprint.ProjectID, sprint)
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sprint)
+}
+
+// GetSprints lists the sprints of a project
+// @Summary List sprints
+// @Description List the sprints of a project ordered by start date
+// @Tags Sprints
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param state query string false "planned, active or closed"
+// @Success 200 {array} models.Sprint
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/sprints [get]
+func GetSprints(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	var sprints []models.Sprint
+	query := db.DB.Where("project_id = ?", project.ID)
+	if state := r.URL.Query().Get("state"); state != "" {
+		query = query.Where("state = ?", state)
+	}
+	err = query.Order("start_date").Find(&sprints).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprints)
+}
+
+// GetSprint returns a sprint with its tasks
+// @Summary Get a sprint
+// @Description Get a sprint with its tasks and estimate totals
+// @Tags Sprints
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param sprintId path string true "Sprint ID"
+// @Success 200 {object} SprintDetail
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/sprints/{sprintId} [get]
+func GetSprint(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var sprint models.Sprint
+	err := db.DB.First(&sprint, "id = ?", r.PathValue("sprintId")).Error
+	if err != nil || !inProject(r, sprint.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "sprint.not_found")
+		return
+	}
+	var project models.Project
+	err = db.DB.First(&project, "id = ?", sprint.ProjectID).Error
+	if err != nil || !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	detail := SprintDetail{Sprint: sprint, Tasks: []models.Task{}}
+	err = db.DB.Where("sprint_id = ?", sprint.ID).Find(&detail.Tasks).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	for _, task := range detail.Tasks {
+		detail.EstimateMinutes += task.EstimateMinutes
+		if task.Status == "completed" {
+			detail.DoneMinutes += task.EstimateMinutes
+		}
+	}
+	// The detail lists the tasks, so the ETag only serves If-Match on
+	// writes and If-None-Match is not answered.
+	utils.SetETag(w, sprint.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// UpdateSprint updates a sprint
+// @Summary Update a sprint
+// @Description Update the name, goal and dates of a sprint that is not closed
+// @Tags Sprints
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param sprintId path string true "Sprint ID"
+// @Param sprint body models.Sprint true "Updated sprint data"
+// @Param If-Match header string true "ETag of the sprint"
+// @Success 200 {object} models.Sprint
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Sprint is closed"
+// @Failure 412 {object} utils.Problem "Sprint changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/sprints/{sprintId} [put]
+func UpdateSprint(w http.ResponseWriter, r *http.Request) {
+	sprint, ok := loadManagedSprint(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, sprint.Version) {
+		return
+	}
+	if sprint.State == "closed" {
+		utils.SendError(w, r, http.StatusConflict, "sprint.closed")
+		return
+	}
+	var updateSprint models.Sprint
+	err := json.NewDecoder(r.Body).Decode(&updateSprint)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	saveSprint(w, r, sprint, updateSprint)
+}
+
+// PatchSprint partially updates a sprint
+// @Summary Patch a sprint
+// @Description Change some fields of the sprint with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: name, goal, start_date, end_date.
+// @Tags Sprints
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param sprintId path string true "Sprint ID"
+// @Param sprint body models.Sprint true "Fields to change"
+// @Param If-Match header string true "ETag of the sprint"
+// @Success 200 {object} models.Sprint
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Sprint is closed"
+// @Failure 412 {object} utils.Problem "Sprint changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/sprints/{sprintId} [patch]
+func PatchSprint(w http.ResponseWriter, r *http.Request) {
+	sprint, ok := loadManagedSprint(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, sprint.Version) {
+		return
+	}
+	if sprint.State == "closed" {
+		utils.SendError(w, r, http.StatusConflict, "sprint.closed")
+		return
+	}
+	updateSprint := sprint
+	if !applyMergePatch(w, r, &updateSprint, "name", "goal", "start_date", "end_date") {
+		return
+	}
+	saveSprint(w, r, sprint, updateSprint)
+}
+
+// saveSprint validates updateSprint and saves its editable fields to
+// sprint.
+func saveSprint(w http.ResponseWriter, r *http.Request, sprint, updateSprint models.Sprint) {
+	err := validate.Struct(&updateSprint)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	sprint.Name = updateSprint.Name
+	sprint.Goal = updateSprint.Goal
+	sprint.StartDate = updateSprint.StartDate
+	sprint.EndDate = updateSprint.EndDate
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &sprint, sprint.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.SprintUpdated, events.Key("sprint", sprint.ID), sprint.ProjectID, sprint)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	utils.SetETag(w, sprint.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprint)
+}
+
+// DeleteSprint deletes a planned sprint
+// @Summary Delete a sprint
+// @Description Delete a sprint that has not started; its tasks go back to the backlog
+// @Tags Sprints
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param sprintId path string true "Sprint ID"
+// @Param If-Match header string true "ETag of the sprint"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Sprint already started"
+// @Failure 412 {object} utils.Problem "Sprint changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/sprints/{sprintId} [delete]
+func DeleteSprint(w http.ResponseWriter, r *http.Request) {
+	sprint, ok := loadManagedSprint(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, sprint.Version) {
+		return
+	}
+	if sprint.State != "planned" {
+		utils.SendError(w, r, http.StatusConflict, "sprint.not_planned")
+		return
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := recordTasksChange(tx, r, "sprint_id", sprint.ID, nil, "sprint_id = ?", sprint.ID)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Task{}).Where("sprint_id = ?", sprint.ID).Update("sprint_id", nil).Error
+		if err != nil {
+			return err
+		}
+		if err := deleteVersioned(tx, &sprint, sprint.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.SprintDeleted, events.Key("sprint", sprint.ID), sprint.ProjectID, deleted{sprint.ID})
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartSprint starts a planned sprint
+// @Summary Start a sprint
+// @Description Start a planned sprint; a project can only have one active sprint
+// @Tags Sprints
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param sprintId path string true "Sprint ID"
+// @Success 200 {object} models.Sprint
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Another sprint is active"
+// @Router /v1/projects/{id}/sprints/{sprintId}/start [post]
+func StartSprint(w http.ResponseWriter, r *http.Request) {
+	sprint, ok := loadManagedSprint(w, r)
+	if !ok {
+		return
+	}
+	if sprint.State != "planned" {
+		utils.SendError(w, r, http.StatusConflict, "sprint.not_planned")
+		return
+	}
+	var active int64
+	db.DB.Model(&models.Sprint{}).Where("project_id = ? and state = ?", sprint.ProjectID, "active").Count(&active)
+	if active > 0 {
+		utils.SendError(w, r, http.StatusConflict, "sprint.already_active")
+		return
+	}
+	committed, _, err := sumEstimates(db.DB, sprint.ID, false)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	sprint.State = "active"
+	sprint.CommittedMinutes = committed
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &sprint, sprint.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.SprintStarted, events.Key("sprint", sprint.ID), sprint.ProjectID, sprint)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprint)
+}
+
+// CloseSprint closes the active sprint
+// @Summary Close a sprint
+// @Description Close a sprint, record its velocity and carry unfinished tasks over to the backlog or the next sprint
+// @Tags Sprints
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param sprintId path string true "Sprint ID"
+// @Param options body object false "carry_over (backlog or next) and optional next_sprint_id"
+// @Success 200 {object} models.Sprint
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Sprint is not active"
+// @Router /v1/projects/{id}/sprints/{sprintId}/close [post]
+func CloseSprint(w http.ResponseWriter, r *http.Request) {
+	sprint, ok := loadManagedSprint(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		CarryOver    string `json:"carry_over" validate:"omitempty,oneof=backlog next"`
+		NextSprintID string `json:"next_sprint_id"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+		if err := validate.Struct(&input); err != nil {
+			utils.SendValidationError(w, r, err)
+			return
+		}
+	}
+	if sprint.State != "active" {
+		utils.SendError(w, r, http.StatusConflict, "sprint.not_active")
+		return
+	}
+
+	var nextSprintID *string
+	if input.CarryOver == "next" {
+		var next models.Sprint
+		query := db.DB.Where("project_id = ? and state = ? and id <> ?", sprint.ProjectID, "planned", sprint.ID)
+		if input.NextSprintID != "" {
+			query = query.Where("id = ?", input.NextSprintID)
+		} else {
+			query = query.Order("start_date")
+		}
+		if err := query.First(&next).Error; err != nil {
+			utils.SendError(w, r, http.StatusBadRequest, "sprint.no_next_sprint")
+			return
+		}
+		nextSprintID = &next.ID
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		completed, tasks, err := sumEstimates(tx, sprint.ID, true)
+		if err != nil {
+			return err
+		}
+		err = recordTasksChange(tx, r, "sprint_id", sprint.ID, nextSprintID,
+			"sprint_id = ? and status <> ?", sprint.ID, "completed")
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Task{}).
+			Where("sprint_id = ? and status <> ?", sprint.ID, "completed").
+			Update("sprint_id", nextSprintID).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		sprint.State = "closed"
+		sprint.ClosedAt = &now
+		sprint.CompletedMinutes = completed
+		sprint.CompletedTasks = tasks
+		if err := saveVersioned(tx, &sprint, sprint.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.SprintClosed, events.Key("sprint", sprint.ID), sprint.ProjectID, sprint)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sprint)
+}
+
+// GetVelocity returns the velocity history of a project
+// @Summary Sprint velocity
+// @Description Committed and completed estimates of every closed sprint of a project
+// @Tags Sprints
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Success 200 {object} Velocity
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/velocity [get]
+func GetVelocity(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	var sprints []models.Sprint
+	err = db.DB.Where("project_id = ? and state = ?", project.ID, "closed").Order("end_date").Find(&sprints).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	velocity := Velocity{Sprints: []VelocityEntry{}}
+	for _, sprint := range sprints {
+		velocity.Sprints = append(velocity.Sprints, VelocityEntry{
+			SprintID:         sprint.ID,
+			Name:             sprint.Name,
+			StartDate:        sprint.StartDate,
+			EndDate:          sprint.EndDate,
+			CommittedMinutes: sprint.CommittedMinutes,
+			CompletedMinutes: sprint.CompletedMinutes,
+			CompletedTasks:   sprint.CompletedTasks,
+		})
+	}
+	recent := velocity.Sprints[max(len(velocity.Sprints)-3, 0):]
+	if len(recent) > 0 {
+		total := 0
+		for _, entry := range recent {
+			total += entry.CompletedMinutes
+		}
+		velocity.AverageMinutes = total / len(recent)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(velocity)
+}
+
+// loadManagedSprint loads the sprint from the path and checks that the user
+// owns its project or is admin.
+func loadManagedSprint(w http.ResponseWriter, r *http.Request) (models.Sprint, bool) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var sprint models.Sprint
+	err := db.DB.First(&sprint, "id = ?", r.PathValue("sprintId")).Error
+	if err != nil || !inProject(r, sprint.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "sprint.not_found")
+		return sprint, false
+	}
+	var project models.Project
+	err = db.DB.First(&project, "id = ?", sprint.ProjectID).Error
+	if err != nil || (project.OwnerID != userID && role != "admin") {
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
+		return sprint, false
+	}
+	return sprint, true
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/middleware"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/stream"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// heartbeat is how often an idle stream sends a comment line to keep
+// proxies from closing it.
+const heartbeat = 25 * time.Second
+
+// streamTokenTTL is how long a stream token can open a stream. It is only
+// checked when the stream opens.
+const streamTokenTTL = time.Minute
+
+// StreamToken opens an event stream from a browser.
+type StreamToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StreamEvent is the data of a server-sent event. ID is the event's
+// position in publication order, also sent as the SSE id.
+type StreamEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	ProjectID *string         `json:"project_id"`
+	ActorID   *string         `json:"actor_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// streamSubscription decides which events a stream client receives.
+type streamSubscription struct {
+	userID      string
+	role        string
+	projectID   string
+	owner       bool
+	assignments bool
+}
+
+func (s *streamSubscription) allows(event *stream.Event) bool {
+	if s.assignments {
+		return event.Task != nil && event.Task.AssignedTo(s.userID)
+	}
+	if event.ProjectID == nil || *event.ProjectID != s.projectID {
+		return false
+	}
+	if event.Task != nil {
+		return canReadTask(event.Task, s.userID, s.role)
+	}
+	switch {
+	case strings.HasPrefix(event.Type, "webhook."):
+		return s.owner || s.role == "admin"
+	case strings.HasPrefix(event.Type, "view."):
+		return false
+	}
+	return true
+}
+
+// Stream streams events as server-sent events
+// @Summary Stream events
+// @Description Stream create, update and delete events as server-sent events. scope=project
+// @Description streams the events of project_id the user can see, scope=assignments the events
+// @Description of tasks assigned to the user. A reconnecting client sends Last-Event-ID to
+// @Description receive the events it missed; when they are no longer available a "reset"
+// @Description event tells it to reload. Browsers, whose EventSource cannot send an
+// @Description Authorization header, pass a token from POST /v1/stream/tokens in the token
+// @Description parameter instead, and last_event_id when they reconnect with a new token.
+// @Tags Events
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param scope query string false "project (default) or assignments"
+// @Param project_id query string false "Project to stream, required for scope=project"
+// @Param token query string false "Stream token, instead of the Authorization header"
+// @Param Last-Event-ID header string false "Id of the last event received"
+// @Param last_event_id query string false "Id of the last event received, when Last-Event-ID cannot be sent"
+// @Success 200 {object} StreamEvent
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/stream [get]
+func Stream(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	sub := streamSubscription{userID: userID, role: role}
+	switch r.URL.Query().Get("scope") {
+	case "", "project":
+		var project models.Project
+		err := db.DB.First(&project, "id = ?", r.URL.Query().Get("project_id")).Error
+		if err != nil {
+			utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+			return
+		}
+		if !canAccessProject(&project, userID, role) {
+			utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+			return
+		}
+		sub.projectID = project.ID
+		sub.owner = project.OwnerID == userID
+	case "assignments":
+		sub.assignments = true
+	default:
+		utils.SendError(w, r, http.StatusBadRequest, "stream.invalid_scope")
+		return
+	}
+	var lastID int64 = -1
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("last_event_id")
+	}
+	if last != "" {
+		id, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || id < 0 {
+			utils.SendError(w, r, http.StatusBadRequest, "stream.invalid_last_event_id")
+			return
+		}
+		lastID = id
+	}
+
+	// Subscribe before replaying so nothing published in between is lost;
+	// events seen in the replay are skipped when they arrive live.
+	live, unsubscribe := stream.Subscribe()
+	defer unsubscribe()
+	var missed []stream.Event
+	reset := false
+	if lastID >= 0 {
+		var err error
+		missed, err = stream.Since(lastID)
+		if errors.Is(err, stream.ErrTooOld) {
+			reset = true
+		} else if err != nil {
+			utils.SendInternalError(w, r, err)
+			return
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	replayed := map[int64]struct{}{}
+	for i := range missed {
+		replayed[missed[i].Position()] = struct{}{}
+		if sub.allows(&missed[i]) {
+			writeStreamEvent(w, &missed[i])
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event, ok := <-live:
+			if !ok {
+				// Fell behind; the client reconnects and replays.
+				return
+			}
+			if _, ok := replayed[event.Position()]; ok || !sub.allows(&event) {
+				continue
+			}
+			writeStreamEvent(w, &event)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// CreateStreamToken issues a stream token
+// @Summary Create a stream token
+// @Description Issue a token that opens GET /v1/stream as the current user when passed in its
+// @Description token parameter. It expires after a minute and is accepted nowhere else, so it
+// @Description can appear in a URL. Request a new one for every connection.
+// @Tags Events
+// @Produce json
+// @Security BearerAuth
+// @Success 201 {object} StreamToken
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/stream/tokens [post]
+func CreateStreamToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	expiresAt := time.Now().Add(streamTokenTTL)
+	claims := &middleware.Claims{
+		UserID: userID,
+		Role:   role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{middleware.StreamAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(middleware.JwtKey)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(StreamToken{Token: token, ExpiresAt: expiresAt.UTC()})
+}
+
+func writeStreamEvent(w http.ResponseWriter, event *stream.Event) {
+	data, _ := json.Marshal(StreamEvent{
+		ID:        event.Position(),
+		Type:      event.Type,
+		ProjectID: event.ProjectID,
+		ActorID:   event.ActorID,
+		CreatedAt: event.CreatedAt.UTC(),
+		Data:      json.RawMessage(event.Payload),
+	})
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Position(), event.Type, data)
+}
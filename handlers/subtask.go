@@ -3,11 +3,13 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
 
 	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
 	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/pagination"
 	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
 )
 
 // CreateSubtask creates a new subtask
@@ -17,30 +19,54 @@ import (
 // @Accept json
 // @Produce json
 // @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
 // @Param subtask body models.Subtask true "Subtask data"
 // @Success 201 {object} models.Subtask
-// @Failure 400 {object} utils.ErrorResponse "Invalid request"
-// @Failure 401 {object} utils.ErrorResponse "Unauthorized"
-// @Failure 500 {object} utils.ErrorResponse "Server error"
-// @Router /subtasks [post]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Router /v1/projects/{id}/tasks/{taskId}/subtasks [post]
 func CreateSubTask(w http.ResponseWriter, r *http.Request) {	
 	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
 	var subtask models.Subtask
 	err := json.NewDecoder(r.Body).Decode(&subtask)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		utils.SendDecodeError(w, r, err)
 		return
 	}
+	subtask.TaskID = task.ID
+	subtask.AssigneeID = optionalID(subtask.AssigneeID)
+	subtask.Status = "pending"
 	err = validate.Struct(&subtask)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Validation error: "+err.Error())
+		utils.SendValidationError(w, r, err)
 		return
 	}
 	subtask.CreatorID = userID
-	subtask.Status = "pending"
-	err = db.DB.Create(&subtask).Error
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&subtask).Error; err != nil {
+			return err
+		}
+		if err := touch(tx, &models.Task{}, task.ID); err != nil {
+			return err
+		}
+		return record(tx, r, events.SubtaskCreated, events.Key("task", task.ID), task.ProjectID, subtask)
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error with creating subtask: "+err.Error())
+		utils.SendInternalError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -56,47 +82,99 @@ func CreateSubTask(w http.ResponseWriter, r *http.Request) {
 // @Tags Subtasks
 // @Produce json
 // @Security BearerAuth
-// @Param page query int false "Page number" default(1)
-// @Param per_page query int false "Items per page" default(10)
-// @Param task_id query string false "Filter by task ID"
-// @Success 200 {array} models.Subtask
-// @Failure 401 {object} utils.ErrorResponse "Unauthorized"
-// @Failure 500 {object} utils.ErrorResponse "Server error"
-// @Router /subtasks [get]
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param limit query int false "Items per page, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param include_total query bool false "Include the total count"
+// @Param page query int false "Page number (offset mode)"
+// @Param per_page query int false "Items per page (offset mode)"
+// @Param filter query string false "Filter expression, e.g. status!=completed and assignee=me"
+// @Success 200 {object} pagination.Page[models.Subtask]
+// @Failure 400 {object} utils.Problem "Invalid cursor or filter"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Router /v1/projects/{id}/tasks/{taskId}/subtasks [get]
 func GetSubtask(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
 
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
+	params, err := pagination.Parse(r)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "pagination.invalid_cursor")
+		return
 	}
-	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
-	if perPage < 1 {
-		perPage = 10
+	taskID := r.PathValue("taskId")
+	if taskID != "" {
+		if _, ok := loadTask(w, r); !ok {
+			return
+		}
 	}
-	offset := (page - 1) * perPage
-	taskID := r.URL.Query().Get("task_id")
 
-	var subtasks []models.Subtask
-	query := db.DB.Limit(perPage).Offset(offset)
+	query := db.DB.Model(&models.Subtask{})
 	if taskID != "" {
 		query = query.Where("task_id = ?", taskID)
 	}
-	if role == "admin" {
-		err := query.Find(&subtasks).Error
-		if err != nil {
-			utils.SendError(w, http.StatusInternalServerError, "Error fetching subtasks: "+err.Error())
-			return
-		}
-	} else {
-		if err := query.Where("creator_id = ? or assignee_id = ?", userID, userID).Find(&subtasks).Error; err != nil {
-			utils.SendError(w, http.StatusInternalServerError, "Error fetching subtasks: "+err.Error())
-			return
-		}
+	if role != "admin" {
+		query = query.Where("creator_id = ? or assignee_id = ?", userID, userID)
+	}
+	query, ok := applyFilter(w, r, query, subtaskFilterSchema)
+	if !ok {
+		return
+	}
+	total, err := pagination.Total(query, params)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	var subtasks []models.Subtask
+	err = params.Apply(query, "subtask").Find(&subtasks).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	pagination.Write(w, r, params, subtasks, subtaskCursor, total)
+}
+
+func subtaskCursor(subtask models.Subtask) pagination.Cursor {
+	return pagination.Cursor{CreatedAt: subtask.CreatedAt, ID: subtask.ID}
+}
+
+// GetSubtaskByID returns a single subtask
+// @Summary Get a subtask
+// @Description Get a subtask if the user is the creator, assignee, or admin
+// @Tags Subtasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param subId path string true "Subtask ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} models.Subtask
+// @Header 200 {string} ETag "Version of the subtask"
+// @Success 304 {string} string "Not modified"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/subtasks/{subId} [get]
+func GetSubtaskByID(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	subtask, ok := loadSubtask(w, r)
+	if !ok {
+		return
+	}
+	if subtask.CreatorID != userID && !subtask.AssignedTo(userID) && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "subtask.forbidden")
+		return
+	}
+	if utils.NotModified(w, r, subtask.Version) {
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(subtasks)
+	json.NewEncoder(w).Encode(subtask)
 }
 
 // UpdateSubtask updates a subtask
@@ -106,49 +184,132 @@ func GetSubtask(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param id path string true "Subtask پردی ID"
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param subId path string true "Subtask ID"
 // @Param subtask body models.Subtask true "Updated subtask data"
+// @Param If-Match header string true "ETag of the subtask"
 // @Success 200 {object} models.Subtask
-// @Failure 400 {object} utils.ErrorResponse "Invalid request"
-// @Failure 401 {object} utils.ErrorResponse "Unauthorized"
-// @Failure 403 {object} utils.ErrorResponse "Forbidden"
-// @Failure 404 {object} utils.ErrorResponse "Not found"
-// @Failure 500 {object} utils.ErrorResponse "Server error"
-// @Router /subtasks/{id} [put]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Failure 412 {object} utils.Problem "Subtask changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId}/subtasks/{subId} [put]
 func UpdateSubtask(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
-	role := r.Context().Value("role").(string)
-	id := r.URL.Path[len("/subtasks/"):]
-
-	var subtask models.Subtask
-	err := db.DB.First(&subtask, "id = ?", id).Error
+	subtask, ok := loadEditableSubtask(w, r)
+	if !ok {
+		return
+	}
+	var updateSubtask models.Subtask
+	err := json.NewDecoder(r.Body).Decode(&updateSubtask)
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "Subtask not found: "+err.Error())
+		utils.SendDecodeError(w, r, err)
 		return
 	}
-	if subtask.CreatorID != userID && subtask.AssigneeID != userID && role != "admin" {
-		utils.SendError(w, http.StatusForbidden, "Not authorized for update subtask")
+	saveSubtask(w, r, subtask, updateSubtask)
+}
+
+// PatchSubtask partially updates a subtask
+// @Summary Patch a subtask
+// @Description Change some fields of the subtask with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: title, status, assignee_id.
+// @Tags Subtasks
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param subId path string true "Subtask ID"
+// @Param subtask body models.Subtask true "Fields to change"
+// @Param If-Match header string true "ETag of the subtask"
+// @Success 200 {object} models.Subtask
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Subtask changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId}/subtasks/{subId} [patch]
+func PatchSubtask(w http.ResponseWriter, r *http.Request) {
+	subtask, ok := loadEditableSubtask(w, r)
+	if !ok {
 		return
 	}
-	var updateSubtask models.Subtask
-	err = json.NewDecoder(r.Body).Decode(&updateSubtask)
-	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+	updateSubtask := subtask
+	if !applyMergePatch(w, r, &updateSubtask, "title", "status", "assignee_id") {
 		return
 	}
-	err = validate.Struct(&updateSubtask)
+	saveSubtask(w, r, subtask, updateSubtask)
+}
+
+// loadEditableSubtask loads the subtask of the request for a write by its
+// creator, its assignee or an admin, checking If-Match.
+func loadEditableSubtask(w http.ResponseWriter, r *http.Request) (models.Subtask, bool) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	subtask, ok := loadSubtask(w, r)
+	if !ok {
+		return subtask, false
+	}
+	if subtask.CreatorID != userID && !subtask.AssignedTo(userID) && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "subtask.forbidden")
+		return subtask, false
+	}
+	if !utils.CheckIfMatch(w, r, subtask.Version) {
+		return subtask, false
+	}
+	return subtask, true
+}
+
+// saveSubtask validates updateSubtask and saves its editable fields to
+// subtask.
+func saveSubtask(w http.ResponseWriter, r *http.Request, subtask, updateSubtask models.Subtask) {
+	updateSubtask.TaskID = subtask.TaskID
+	err := validate.Struct(&updateSubtask)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Validation error: "+err.Error())
+		utils.SendValidationError(w, r, err)
 		return
 	}
+	completed := subtask.Status != "completed" && updateSubtask.Status == "completed"
+	previousAssigneeID := subtask.AssigneeID
 	subtask.Title = updateSubtask.Title
 	subtask.Status = updateSubtask.Status
-	subtask.AssigneeID = updateSubtask.AssigneeID
-	err = db.DB.Save(&subtask).Error
+	subtask.AssigneeID = optionalID(updateSubtask.AssigneeID)
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &subtask, subtask.Version); err != nil {
+			return err
+		}
+		if err := touch(tx, &models.Task{}, subtask.TaskID); err != nil {
+			return err
+		}
+		var task models.Task
+		if err := tx.Select("id", "project_id").First(&task, "id = ?", subtask.TaskID).Error; err != nil {
+			return err
+		}
+		key := events.Key("task", task.ID)
+		if err := record(tx, r, events.SubtaskUpdated, key, task.ProjectID, subtask); err != nil {
+			return err
+		}
+		if !equalStrings(subtask.AssigneeID, previousAssigneeID) {
+			err := record(tx, r, events.SubtaskAssigned, key, task.ProjectID, subtaskAssignment{subtask, previousAssigneeID})
+			if err != nil {
+				return err
+			}
+		}
+		if !completed {
+			return nil
+		}
+		return record(tx, r, events.SubtaskCompleted, key, task.ProjectID, subtask)
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Failed to update subtask")
+		sendWriteError(w, r, err)
 		return
 	}
+	utils.SetETag(w, subtask.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(subtask)
 }
@@ -159,32 +320,65 @@ func UpdateSubtask(w http.ResponseWriter, r *http.Request) {
 // @Tags Subtasks
 // @Produce json
 // @Security BearerAuth
-// @Param id path string true "Subtask ID"
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param subId path string true "Subtask ID"
+// @Param If-Match header string true "ETag of the subtask"
 // @Success 204 {string} string "No content"
-// @Failure 401 {object} utils.ErrorResponse "Unauthorized"
-// @Failure 403 {object} utils.ErrorResponse "Forbidden"
-// @Failure 404 {object} utils.ErrorResponse "Not found"
-// @Failure 500 {object} utils.ErrorResponse "Server error"
-// @Router /subtasks/{id} [delete]
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Failure 412 {object} utils.Problem "Subtask changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId}/subtasks/{subId} [delete]
 func DeleteSubtask(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
-	id := r.URL.Path[len("/subtasks/"):]
 
-	var subtask models.Subtask
-	err := db.DB.First(&subtask, "id = ?", id).Error
-	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "Subtask not found for deleting: "+err.Error())
+	subtask, ok := loadSubtask(w, r)
+	if !ok {
 		return
 	}
 	if subtask.CreatorID != userID && role != "admin" {
-		utils.SendError(w, http.StatusForbidden, "You are not admin for deleting subtask: "+err.Error())
+		utils.SendError(w, r, http.StatusForbidden, "subtask.forbidden")
 		return
 	}
-	err = db.DB.Delete(&subtask).Error
+	if !utils.CheckIfMatch(w, r, subtask.Version) {
+		return
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &subtask, subtask.Version); err != nil {
+			return err
+		}
+		if err := touch(tx, &models.Task{}, subtask.TaskID); err != nil {
+			return err
+		}
+		var task models.Task
+		if err := tx.Select("id", "project_id").First(&task, "id = ?", subtask.TaskID).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.SubtaskDeleted, events.Key("task", task.ID), task.ProjectID, deleted{subtask.ID})
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error with deleting subtask: "+err.Error())
+		sendWriteError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
+
+// loadSubtask loads the subtask named by the {subId} path value, checking
+// that it belongs to the {taskId} task of the {id} project.
+func loadSubtask(w http.ResponseWriter, r *http.Request) (models.Subtask, bool) {
+	var subtask models.Subtask
+	task, ok := loadTask(w, r)
+	if !ok {
+		return subtask, false
+	}
+	err := db.DB.First(&subtask, "id = ? and task_id = ?", r.PathValue("subId"), task.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "subtask.not_found")
+		return subtask, false
+	}
+	return subtask, true
+}
@@ -2,12 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"strconv"
+	"strings"
 
+	"github.com/Anwarjondev/task-management-api/board"
 	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
 	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/pagination"
+	"github.com/Anwarjondev/task-management-api/storage"
 	"github.com/Anwarjondev/task-management-api/utils"
+	"github.com/Anwarjondev/task-management-api/watchers"
+	"gorm.io/gorm"
 )
 
 // CreateTask creates a new task
@@ -17,30 +25,71 @@ import (
 // @Accept json
 // @Produce json
 // @Security BearerAuth
+// @Param id path string true "Project ID"
 // @Param task body models.Task true "Task data"
 // @Success 201 {object} models.Task
-// @Failure 400 {string} string "Invalid request"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 500 {string} string "Server error"
-// @Router /createtask [post]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 409 {object} utils.Problem "WIP limit reached"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Router /v1/projects/{id}/tasks [post]
 func CreateTask(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	var task models.Task
 	err := json.NewDecoder(r.Body).Decode(&task)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		utils.SendDecodeError(w, r, err)
 		return
 	}
+	if projectID := r.PathValue("id"); projectID != "" {
+		task.ProjectID = projectID
+	}
+	task.Status = "pending"
 	err = validate.Struct(&task)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	err = checkMilestone(task.MilestoneID, task.ProjectID)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, referenceCode(err))
+		return
+	}
+	err = checkSprint(task.SprintID, task.ProjectID)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, referenceCode(err))
 		return
 	}
 	task.CreatorID = userID
-	task.Status = "pending"
-	err = db.DB.Create(&task).Error
+	task.AssigneeID = optionalID(task.AssigneeID)
+	task.TemplateID = nil
+	task.EmailFrom = ""
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkWIPLimit(tx, task.ProjectID, task.Status); err != nil {
+			return err
+		}
+		rank, err := board.EndRank(tx, task.ProjectID, task.Status, "")
+		if err != nil {
+			return err
+		}
+		task.Rank = rank
+		if err := tx.Create(&task).Error; err != nil {
+			return err
+		}
+		if err := watchTask(tx, task, task.CreatorID, task.AssigneeUserID()); err != nil {
+			return err
+		}
+		if err := recordActivity(tx, r, task.ID, events.TaskCreated, "", nil); err != nil {
+			return err
+		}
+		return record(tx, r, events.TaskCreated, events.Key("task", task.ID), task.ProjectID, task)
+	})
+	if errors.Is(err, errWIPLimit) {
+		utils.SendError(w, r, http.StatusConflict, "board.wip_limit_reached", task.Status)
+		return
+	}
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error with creating task: "+err.Error())
+		utils.SendInternalError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -54,49 +103,118 @@ func CreateTask(w http.ResponseWriter, r *http.Request) {
 // @Tags Tasks
 // @Produce json
 // @Security BearerAuth
-// @Param page query int false "Page number" default(1)
-// @Param per_page query int false "Items per page" default(10)
+// @Param id path string true "Project ID"
+// @Param limit query int false "Items per page, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param include_total query bool false "Include the total count"
+// @Param page query int false "Page number (offset mode)"
+// @Param per_page query int false "Items per page (offset mode)"
 // @Param status query string false "Filter by status"
-// @Success 200 {array} models.Task
-// @Failure 401 {string} string "Unauthorized"
-// @Router /gettask [get]
+// @Param labels query string false "Comma separated label names"
+// @Param label_match query string false "any (default) or all" Enums(any, all)
+// @Param filter query string false "Filter expression, e.g. status in (pending,in_progress) and assignee=me and due<2026-11-01 and label:bug"
+// @Success 200 {object} pagination.Page[models.Task]
+// @Failure 400 {object} utils.Problem "Invalid cursor or filter"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/projects/{id}/tasks [get]
 func GetTask(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
 
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
-	}
-	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
-	if perPage < 1 {
-		perPage = 10
+	params, err := pagination.Parse(r)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "pagination.invalid_cursor")
+		return
 	}
-	offset := (page-1) * perPage
-	status := r.URL.Query().Get("status")
-
-	var tasks []models.Task
-	query := db.DB.Limit(perPage).Offset(offset)
-	if status != "" {
+	query := db.DB.Model(&models.Task{})
+	if status := r.URL.Query().Get("status"); status != "" {
 		query = query.Where("status = ?", status)
 	}
-	if role == "admin" {
-		err := query.Find(&tasks).Error
-		if err != nil {
-			utils.SendError(w, http.StatusInternalServerError, "Error with fetch task: "+err.Error())
-			return
-		}
-	} else {
-		err := query.Where("creator_id = ? or assignee_id = ?", userID, userID).Find(&tasks).Error
-		if err != nil {
-			utils.SendError(w, http.StatusInternalServerError, "Error with fetch task: "+err.Error())
-			return
+	if projectID := r.PathValue("id"); projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	if labels := r.URL.Query().Get("labels"); labels != "" {
+		var names []string
+		for _, name := range strings.Split(labels, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
 		}
+		query = filterByLabels(query, uniqueStrings(names), r.URL.Query().Get("label_match") == "all")
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tasks)
+	query, ok := applyFilter(w, r, query, taskFilterSchema)
+	if !ok {
+		return
+	}
+	if role != "admin" {
+		query = query.Where("creator_id = ? or assignee_id = ?", userID, userID)
+	}
+	total, err := pagination.Total(query, params)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	var tasks []models.Task
+	err = params.Apply(query.Preload("Labels"), "task").Find(&tasks).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	err = fillTimeTotals(tasks)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	pagination.Write(w, r, params, tasks, taskCursor, total)
 }
 
+func taskCursor(task models.Task) pagination.Cursor {
+	return pagination.Cursor{CreatedAt: task.CreatedAt, ID: task.ID}
+}
+
+// GetTaskByID returns a single task
+// @Summary Get a task
+// @Description Get a task if the user is the creator, assignee, or admin
+// @Tags Tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} models.Task
+// @Header 200 {string} ETag "Version of the task"
+// @Success 304 {string} string "Not modified"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId} [get]
+func GetTaskByID(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	err := db.DB.Preload("Labels").Preload("Subtasks").First(&task, "id = ?", task.ID).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	if utils.NotModified(w, r, task.Version) {
+		return
+	}
+	tasks := []models.Task{task}
+	if err := fillTimeTotals(tasks); err == nil {
+		task = tasks[0]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
 
 // UpdateTask updates a task
 // @Summary Update a task
@@ -105,49 +223,169 @@ func GetTask(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param id path string true "Task ID"
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
 // @Param task body models.Task true "Updated task data"
+// @Param If-Match header string true "ETag of the task"
 // @Success 200 {object} models.Task
-// @Failure 400 {string} string "Invalid request"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Forbidden"
-// @Failure 404 {string} string "Not found"
-// @Router /tasks/{id} [put]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Task changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId} [put]
 func Updatetask(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("user_id").(string)
-	role := r.Context().Value("role").(string)
-	id := r.URL.Path[len("/updatetask/"):]
-	
-	var task models.Task
-	err := db.DB.First(&task, "id = ?", id).Error
+	task, ok := loadEditableTask(w, r)
+	if !ok {
+		return
+	}
+	var updateTask models.Task
+	err := json.NewDecoder(r.Body).Decode(&updateTask)
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "task not found: "+err.Error())
+		utils.SendDecodeError(w, r, err)
 		return
 	}
-	if task.AssigneeID != userID && task.CreatorID != userID && role != "admin" {
-		utils.SendError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+	saveTask(w, r, task, updateTask)
+}
+
+// PatchTask partially updates a task
+// @Summary Patch a task
+// @Description Change some fields of the task with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: title, description, status, assignee_id, estimate_minutes, due_date, milestone_id, sprint_id.
+// @Tags Tasks
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param task body models.Task true "Fields to change"
+// @Param If-Match header string true "ETag of the task"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "WIP limit reached"
+// @Failure 412 {object} utils.Problem "Task changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId} [patch]
+func PatchTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadEditableTask(w, r)
+	if !ok {
 		return
 	}
-	var updateTask models.Task
-	err = json.NewDecoder(r.Body).Decode(&updateTask)
-	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+	updateTask := task
+	if !applyMergePatch(w, r, &updateTask, "title", "description", "status", "assignee_id", "estimate_minutes", "due_date", "milestone_id", "sprint_id") {
 		return
 	}
-	err = validate.Struct(&updateTask)
+	saveTask(w, r, task, updateTask)
+}
+
+// loadEditableTask loads the task of the request for a write by its
+// creator, its assignee or an admin, checking If-Match.
+func loadEditableTask(w http.ResponseWriter, r *http.Request) (models.Task, bool) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return task, false
+	}
+	if !task.AssignedTo(userID) && task.CreatorID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return task, false
+	}
+	if !utils.CheckIfMatch(w, r, task.Version) {
+		return task, false
+	}
+	return task, true
+}
+
+// saveTask validates updateTask and saves its editable fields to task.
+func saveTask(w http.ResponseWriter, r *http.Request, task, updateTask models.Task) {
+	before := task
+	err := validate.Struct(&updateTask)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request: "+err.Error())
+		utils.SendValidationError(w, r, err)
 		return
 	}
+	previousStatus := task.Status
+	previousAssigneeID := task.AssigneeID
 	task.Title = updateTask.Title
 	task.Description = updateTask.Description
 	task.Status = updateTask.Status
-	task.AssigneeID = updateTask.AssigneeID
-	err = db.DB.Save(&task).Error
+	task.AssigneeID = optionalID(updateTask.AssigneeID)
+	task.EstimateMinutes = updateTask.EstimateMinutes
+	task.DueDate = updateTask.DueDate
+	if updateTask.MilestoneID == nil || task.MilestoneID == nil || *updateTask.MilestoneID != *task.MilestoneID {
+		err = checkMilestone(updateTask.MilestoneID, task.ProjectID)
+		if err != nil {
+			utils.SendError(w, r, http.StatusBadRequest, referenceCode(err))
+			return
+		}
+	}
+	task.MilestoneID = updateTask.MilestoneID
+	if updateTask.SprintID == nil || task.SprintID == nil || *updateTask.SprintID != *task.SprintID {
+		err = checkSprint(updateTask.SprintID, task.ProjectID)
+		if err != nil {
+			utils.SendError(w, r, http.StatusBadRequest, referenceCode(err))
+			return
+		}
+	}
+	task.SprintID = updateTask.SprintID
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if task.Status != previousStatus {
+			if err := checkWIPLimit(tx, task.ProjectID, task.Status); err != nil {
+				return err
+			}
+			rank, err := board.EndRank(tx, task.ProjectID, task.Status, task.ID)
+			if err != nil {
+				return err
+			}
+			task.Rank = rank
+		}
+		if err := saveVersioned(tx, &task, before.Version); err != nil {
+			return err
+		}
+		if err := recordActivity(tx, r, task.ID, events.TaskUpdated, "", taskChanges(before, task)); err != nil {
+			return err
+		}
+		key := events.Key("task", task.ID)
+		if err := record(tx, r, events.TaskUpdated, key, task.ProjectID, task); err != nil {
+			return err
+		}
+		if !equalStrings(task.AssigneeID, previousAssigneeID) {
+			err := record(tx, r, events.TaskAssigned, key, task.ProjectID, taskAssignment{task, previousAssigneeID})
+			if err != nil {
+				return err
+			}
+			if err := watchTask(tx, task, task.AssigneeUserID()); err != nil {
+				return err
+			}
+		}
+		if task.Status == previousStatus {
+			return nil
+		}
+		return record(tx, r, events.TaskStatusChanged, key, task.ProjectID, taskStatusChange{task, previousStatus})
+	})
+	if errors.Is(err, errWIPLimit) {
+		utils.SendError(w, r, http.StatusConflict, "board.wip_limit_reached", task.Status)
+		return
+	}
+	if errors.Is(err, board.ErrRankExhausted) {
+		utils.SendError(w, r, http.StatusConflict, "board.rank_exhausted")
+		return
+	}
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error with supdating task: "+err.Error())
+		sendWriteError(w, r, err)
 		return
 	}
+	tasks := []models.Task{task}
+	if err := fillTimeTotals(tasks); err == nil {
+		task = tasks[0]
+	}
+	utils.SetETag(w, task.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
@@ -158,33 +396,108 @@ func Updatetask(w http.ResponseWriter, r *http.Request) {
 // @Tags Tasks
 // @Produce json
 // @Security BearerAuth
-// @Param id path string true "Task ID"
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param If-Match header string true "ETag of the task"
 // @Success 204 {string} string "No content"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Forbidden"
-// @Failure 404 {string} string "Not found"
-// @Router /tasks/{id} [delete]
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Task changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId} [delete]
 func DeleteTask(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
-	id := r.URL.Path[len("/deletetask/"):]
 
-	var task models.Task
-	err := db.DB.First(&task, "id = ?", id).Error
-	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "task not found: "+err.Error())
+	task, ok := loadTask(w, r)
+	if !ok {
 		return
 	}
 	if task.CreatorID != userID && role != "admin" {
-		utils.SendError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, task.Version) {
 		return
 	}
-	err = db.DB.Delete(&task).Error 
+	var attachments []models.Attachment
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&task).Association("Labels").Clear(); err != nil {
+			return err
+		}
+		// The stored files are removed after the commit, so the rows are
+		// read in the transaction that deletes them.
+		if err := tx.Where("task_id = ?", task.ID).Find(&attachments).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.Comment{}).Error; err != nil {
+			return err
+		}
+		if err := watchers.Forget(tx, watchers.Task, task.ID); err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.TaskActivity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.Timer{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.WorkLog{}).Error; err != nil {
+			return err
+		}
+		if err := deleteVersioned(tx, &task, task.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.TaskDeleted, events.Key("task", task.ID), task.ProjectID, task)
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error deleting task: "+err.Error())
+		sendWriteError(w, r, err)
 		return
 	}
+	for _, attachment := range attachments {
+		if err := storage.Store.Delete(r.Context(), attachment.StorageKey); err != nil {
+			log.Println("Error deleting attachment content:", err)
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// loadTask loads the task named by the {taskId} path value. On routes nested
+// under a project the task must also belong to the {id} project.
+func loadTask(w http.ResponseWriter, r *http.Request) (models.Task, bool) {
+	var task models.Task
+	err := db.DB.First(&task, "id = ?", r.PathValue("taskId")).Error
+	if err != nil || !inProject(r, task.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "task.not_found")
+		return task, false
+	}
+	return task, true
+}
+
+// referenceCode returns the error code for a failed checkMilestone or
+// checkSprint.
+func referenceCode(err error) string {
+	switch {
+	case errors.Is(err, errMilestoneNotInProject):
+		return "milestone.not_in_project"
+	case errors.Is(err, errMilestoneClosed):
+		return "milestone.closed"
+	case errors.Is(err, errSprintNotInProject):
+		return "sprint.not_in_project"
+	case errors.Is(err, errSprintClosed):
+		return "sprint.closed"
+	}
+	return utils.CodeValidation
+}
+
+// canReadTask reports whether the user may see the task, using the same
+// rule as the task list: admins see everything, others their own tasks.
+func canReadTask(task *models.Task, userID, role string) bool {
+	return role == "admin" || task.CreatorID == userID || task.AssignedTo(userID)
+}
+
 
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/recurrence"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+// scheduleTemplate validates the template's rule and sets NextRunAt to the
+// first occurrence that is not already in the past.
+func scheduleTemplate(template *models.TaskTemplate) error {
+	rule, err := recurrence.Parse(template.RRule)
+	if err != nil {
+		return err
+	}
+	template.RRule = rule.String()
+	if template.Trigger == "" {
+		template.Trigger = "schedule"
+	}
+	from := time.Now().UTC()
+	if template.StartAt.After(from) {
+		from = template.StartAt
+	}
+	template.NextRunAt = nil
+	template.Active = false
+	if next, ok := rule.Next(template.StartAt, from.Add(-time.Nanosecond)); ok {
+		template.NextRunAt = &next
+		template.Active = true
+	}
+	return nil
+}
+
+// CreateTaskTemplate creates a recurring task template
+// @Summary Create a recurring task
+// @Description Create a task template with an RRULE (DAILY, WEEKLY or MONTHLY) in a project
+// @Tags Recurring tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param template body models.TaskTemplate true "Template data"
+// @Success 201 {object} models.TaskTemplate
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/task-templates [post]
+func CreateTaskTemplate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	var template models.TaskTemplate
+	err = json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	err = validate.Struct(&template)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	err = scheduleTemplate(&template)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "template.invalid_rrule", err.Error())
+		return
+	}
+	template.ProjectID = project.ID
+	template.CreatorID = userID
+	template.AssigneeID = optionalID(template.AssigneeID)
+	template.OccurrenceCount = 0
+	template.LastTaskID = nil
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&template).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.TemplateCreated, events.Key("template", template.ID), template.ProjectID, template)
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+// GetTaskTemplates lists the recurring task templates of a project
+// @Summary List recurring tasks
+// @Description List the task templates of a project
+// @Tags Recurring tasks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Success 200 {array} models.TaskTemplate
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/task-templates [get]
+func GetTaskTemplates(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return
+	}
+	var templates []models.TaskTemplate
+	err = db.DB.Where("project_id = ?", project.ID).Order("created_at").Find(&templates).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// UpdateTaskTemplate updates a recurring task template
+// @Summary Update a recurring task
+// @Description Update a task template if the user created it, owns the project, or is admin
+// @Tags Recurring tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param templateId path string true "Template ID"
+// @Param template body models.TaskTemplate true "Updated template data"
+// @Param If-Match header string true "ETag of the template"
+// @Success 200 {object} models.TaskTemplate
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Template changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/task-templates/{templateId} [put]
+func UpdateTaskTemplate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var template models.TaskTemplate
+	err := db.DB.First(&template, "id = ?", r.PathValue("templateId")).Error
+	if err != nil || !inProject(r, template.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "template.not_found")
+		return
+	}
+	if !canManageTemplate(&template, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "template.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, template.Version) {
+		return
+	}
+	var updateTemplate models.TaskTemplate
+	err = json.NewDecoder(r.Body).Decode(&updateTemplate)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	saveTaskTemplate(w, r, template, updateTemplate)
+}
+
+// PatchTaskTemplate partially updates a recurring task template
+// @Summary Patch a recurring task
+// @Description Change some fields of the template with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: title, description, assignee_id, estimate_minutes, rrule, start_at, trigger.
+// @Tags Recurring tasks
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param templateId path string true "Template ID"
+// @Param template body models.TaskTemplate true "Fields to change"
+// @Param If-Match header string true "ETag of the template"
+// @Success 200 {object} models.TaskTemplate
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Template changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/task-templates/{templateId} [patch]
+func PatchTaskTemplate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var template models.TaskTemplate
+	err := db.DB.First(&template, "id = ?", r.PathValue("templateId")).Error
+	if err != nil || !inProject(r, template.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "template.not_found")
+		return
+	}
+	if !canManageTemplate(&template, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "template.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, template.Version) {
+		return
+	}
+	updateTemplate := template
+	if !applyMergePatch(w, r, &updateTemplate, "title", "description", "assignee_id", "estimate_minutes", "rrule", "start_at", "trigger") {
+		return
+	}
+	saveTaskTemplate(w, r, template, updateTemplate)
+}
+
+// saveTaskTemplate validates updateTemplate, saves its editable fields to
+// template and reschedules it.
+func saveTaskTemplate(w http.ResponseWriter, r *http.Request, template, updateTemplate models.TaskTemplate) {
+	err := validate.Struct(&updateTemplate)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	template.Title = updateTemplate.Title
+	template.Description = updateTemplate.Description
+	template.AssigneeID = optionalID(updateTemplate.AssigneeID)
+	template.EstimateMinutes = updateTemplate.EstimateMinutes
+	template.RRule = updateTemplate.RRule
+	template.StartAt = updateTemplate.StartAt
+	template.Trigger = updateTemplate.Trigger
+	err = scheduleTemplate(&template)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "template.invalid_rrule", err.Error())
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &template, template.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.TemplateUpdated, events.Key("template", template.ID), template.ProjectID, template)
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	utils.SetETag(w, template.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+// DeleteTaskTemplate deletes a recurring task template
+// @Summary Delete a recurring task
+// @Description Stop a recurrence; tasks already generated are kept
+// @Tags Recurring tasks
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param templateId path string true "Template ID"
+// @Param If-Match header string true "ETag of the template"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Template changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/task-templates/{templateId} [delete]
+func DeleteTaskTemplate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var template models.TaskTemplate
+	err := db.DB.First(&template, "id = ?", r.PathValue("templateId")).Error
+	if err != nil || !inProject(r, template.ProjectID) {
+		utils.SendError(w, r, http.StatusNotFound, "template.not_found")
+		return
+	}
+	if !canManageTemplate(&template, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "template.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, template.Version) {
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &template, template.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.TemplateDeleted, events.Key("template", template.ID), template.ProjectID, deleted{template.ID})
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func canManageTemplate(template *models.TaskTemplate, userID, role string) bool {
+	if role == "admin" || template.CreatorID == userID {
+		return true
+	}
+	var project models.Project
+	if err := db.DB.First(&project, "id = ?", template.ProjectID).Error; err != nil {
+		return false
+	}
+	return project.OwnerID == userID
+}
@@ -5,9 +5,11 @@ import (
 	"net/http"
 
 	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
 	"github.com/Anwarjondev/task-management-api/models"
 	"github.com/Anwarjondev/task-management-api/utils"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // GetUsers lists all users (admin only)
@@ -17,15 +19,15 @@ import (
 // @Produce json
 // @Security BearerAuth
 // @Success 200 {array} models.User
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Forbidden"
-// @Failure 500 {string} string "Server error"
-// @Router /admin/users [get]
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Router /v1/admin/users [get]
 func GetUsers(w http.ResponseWriter, r *http.Request) {
 	var users []models.User
 	err := db.DB.Find(&users).Error
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "error with fetching users: "+err.Error())
+		utils.SendInternalError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -34,61 +36,126 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUser updates a user (admin or self)
 // @Summary Update user
-// @Description Update a user's details
+// @Description Update a user's details. Only admins can change the role.
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param id path string true "User ID"
+// @Param userId path string true "User ID"
 // @Param user body models.User true "Updated user data"
+// @Param If-Match header string true "ETag of the user"
 // @Success 200 {object} models.User
-// @Failure 400 {string} string "Invalid request"
-// @Failure 401 {string} string "Unauthorized"
-// @Failure 403 {string} string "Forbidden"
-// @Failure 404 {string} string "Not found"
-// @Failure 500 {object} utils.ErrorResponse "Server error"
-// @Router /users/{id} [put]
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Failure 412 {object} utils.Problem "User changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/users/{userId} [put]
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadEditableUser(w, r)
+	if !ok {
+		return
+	}
+	var updateUser models.User
+	err := json.NewDecoder(r.Body).Decode(&updateUser)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	saveUser(w, r, user, updateUser)
+}
+
+// PatchUser partially updates a user
+// @Summary Patch a user
+// @Description Change some fields of the user with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: username, password, email, role.
+// @Tags Users
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param userId path string true "User ID"
+// @Param user body models.User true "Fields to change"
+// @Param If-Match header string true "ETag of the user"
+// @Success 200 {object} models.User
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "User changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/users/{userId} [patch]
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadEditableUser(w, r)
+	if !ok {
+		return
+	}
+	updateUser := user
+	if !applyMergePatch(w, r, &updateUser, "username", "password", "email", "role") {
+		return
+	}
+	saveUser(w, r, user, updateUser)
+}
+
+// loadEditableUser loads the {userId} user for a write by that user or an
+// admin, checking If-Match.
+func loadEditableUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
 	userID := r.Context().Value("user_id").(string)
 	role := r.Context().Value("role").(string)
-	id := r.URL.Path[len("/updateuser/"):]
 
-	var user models.User 
-	err := db.DB.First(&user, "id = ?", id).Error
+	var user models.User
+	err := db.DB.First(&user, "id = ?", r.PathValue("userId")).Error
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "User not found: "+err.Error())
-		return
+		utils.SendError(w, r, http.StatusNotFound, "user.not_found")
+		return user, false
 	}
 	if user.ID != userID && role != "admin" {
-		utils.SendError(w, http.StatusForbidden, "Forbidden for updating user")
-		return
+		utils.SendError(w, r, http.StatusForbidden, "user.forbidden")
+		return user, false
 	}
-	var updateUser models.User
-	err = json.NewDecoder(r.Body).Decode(&updateUser)
+	if !utils.CheckIfMatch(w, r, user.Version) {
+		return user, false
+	}
+	return user, true
+}
+
+// saveUser validates updateUser and saves its editable fields to user.
+// Only admins may change a role.
+func saveUser(w http.ResponseWriter, r *http.Request, user, updateUser models.User) {
+	role := r.Context().Value("role").(string)
+
+	err := validate.Struct(&updateUser)
 	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Invalid request")
+		utils.SendValidationError(w, r, err)
 		return
 	}
-	err = validate.Struct(&updateUser)
-	if err != nil {
-		utils.SendError(w, http.StatusBadRequest, "Validation error: "+err.Error())
+	if updateUser.Role != user.Role && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "user.role_forbidden")
 		return
 	}
 	user.Username = updateUser.Username
-	if updateUser.Password != "" {
+	if updateUser.Password != "" && updateUser.Password != user.Password {
 		hashedpassword, err := bcrypt.GenerateFromPassword([]byte(updateUser.Password), bcrypt.DefaultCost)
 		if err != nil {
-			utils.SendError(w, http.StatusInternalServerError, "Error with hashing password: "+err.Error())
+			utils.SendInternalError(w, r, err)
 			return
 		}
 		user.Password = string(hashedpassword)
 	}
 	user.Role = updateUser.Role
-	err = db.DB.Save(&user).Error
+	user.Email = updateUser.Email
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &user, user.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.UserUpdated, events.Key("user", user.ID), "", newUserData(user))
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error with updating: "+err.Error())
+		sendWriteError(w, r, err)
 		return
 	}
+	utils.SetETag(w, user.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
@@ -99,24 +166,35 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 // @Tags Users
 // @Produce json
 // @Security BearerAuth
-// @Param id path string true "User ID"
+// @Param userId path string true "User ID"
+// @Param If-Match header string true "ETag of the user"
 // @Success 204 {string} string "No content"
-// @Failure 401 {object} utils.ErrorResponse "Unauthorized"
-// @Failure 403 {object} utils.ErrorResponse "Forbidden"
-// @Failure 404 {object} utils.ErrorResponse "Not found"
-// @Failure 500 {object} utils.ErrorResponse "Server error"
-// @Router /admin/users/{id} [delete]
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 500 {object} utils.Problem "Server error"
+// @Failure 412 {object} utils.Problem "User changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/admin/users/{userId} [delete]
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/deleteusers/"):]
+	id := r.PathValue("userId")
 	var user models.User
 	err := db.DB.First(&user, "id = ?", id).Error
 	if err != nil {
-		utils.SendError(w, http.StatusNotFound, "User not found: "+err.Error())
+		utils.SendError(w, r, http.StatusNotFound, "user.not_found")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, user.Version) {
 		return
 	}
-	err = db.DB.Delete(&user).Error
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &user, user.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.UserDeleted, events.Key("user", user.ID), "", deleted{user.ID})
+	})
 	if err != nil {
-		utils.SendError(w, http.StatusInternalServerError, "Error with deleting user: "+err.Error())
+		sendWriteError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errStale is returned by a versioned write to a row that changed after it
+// was loaded.
+var errStale = errors.New("stale version")
+
+// saveVersioned saves all columns of value, a model loaded at version, if
+// the row is still at that version. Postgres bumps the version, which is
+// read back into value.
+func saveVersioned(tx *gorm.DB, value any, version int) error {
+	result := tx.Model(value).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "version"}}}).
+		Where("version = ?", version).
+		Select("*").Omit(clause.Associations, "version").
+		Updates(value)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errStale
+	}
+	return result.Error
+}
+
+// deleteVersioned deletes value, a model loaded at version, if the row is
+// still at that version.
+func deleteVersioned(tx *gorm.DB, value any, version int) error {
+	result := tx.Where("version = ?", version).Delete(value)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errStale
+	}
+	return result.Error
+}
+
+// bumpVersioned bumps the version of value, a model loaded at version, if
+// the row is still at that version, for changes to what its
+// representation embeds, such as the labels of a task.
+func bumpVersioned(tx *gorm.DB, value any, version int) error {
+	result := tx.Model(value).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "version"}}}).
+		Where("version = ?", version).
+		Omit(clause.Associations).
+		Update("version", gorm.Expr("version + 1"))
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errStale
+	}
+	return result.Error
+}
+
+// touch is bumpVersioned without the version check, for changes made
+// without a precondition.
+func touch(tx *gorm.DB, model any, id string) error {
+	return tx.Model(model).Where("id = ?", id).Update("version", gorm.Expr("version + 1")).Error
+}
+
+// sendWriteError answers a failed versioned write: 412 when the row
+// changed under the request, 500 otherwise.
+func sendWriteError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errStale) {
+		utils.SendError(w, r, http.StatusPreconditionFailed, utils.CodeStale)
+		return
+	}
+	utils.SendInternalError(w, r, err)
+}
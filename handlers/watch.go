@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/pagination"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"github.com/Anwarjondev/task-management-api/watchers"
+	"gorm.io/gorm"
+)
+
+// watcher is a user watching a task or project.
+type watcher struct {
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	Since    time.Time `json:"since"`
+}
+
+// WatchTask makes the user watch a task
+// @Summary Watch a task
+// @Description Get notified of the activity of a task the user can see
+// @Tags Watchers
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Success 204 {string} string "No content"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/watch [post]
+func WatchTask(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	task, ok := loadReadableTask(w, r)
+	if !ok {
+		return
+	}
+	err := watchers.Watch(db.DB, userID, watchers.Task, task.ID, task.ProjectID)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnwatchTask stops the user watching a task
+// @Summary Unwatch a task
+// @Tags Watchers
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Success 204 {string} string "No content"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/watch [delete]
+func UnwatchTask(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if err := watchers.Unwatch(db.DB, userID, watchers.Task, task.ID); err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTaskWatchers lists the watchers of a task
+// @Summary List task watchers
+// @Tags Watchers
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Success 200 {array} watcher
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/watchers [get]
+func GetTaskWatchers(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadReadableTask(w, r)
+	if !ok {
+		return
+	}
+	sendWatchers(w, r, watchers.Task, task.ID)
+}
+
+// WatchProject makes the user watch a project
+// @Summary Watch a project
+// @Description Get notified of the activity of a project the user owns or is a member of
+// @Tags Watchers
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Success 204 {string} string "No content"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/watch [post]
+func WatchProject(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	project, ok := loadAccessibleProject(w, r)
+	if !ok {
+		return
+	}
+	err := watchers.Watch(db.DB, userID, watchers.Project, project.ID, project.ID)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnwatchProject stops the user watching a project
+// @Summary Unwatch a project
+// @Tags Watchers
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Success 204 {string} string "No content"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/projects/{id}/watch [delete]
+func UnwatchProject(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	if err := watchers.Unwatch(db.DB, userID, watchers.Project, r.PathValue("id")); err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetProjectWatchers lists the watchers of a project
+// @Summary List project watchers
+// @Tags Watchers
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Success 200 {array} watcher
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/watchers [get]
+func GetProjectWatchers(w http.ResponseWriter, r *http.Request) {
+	project, ok := loadAccessibleProject(w, r)
+	if !ok {
+		return
+	}
+	sendWatchers(w, r, watchers.Project, project.ID)
+}
+
+// GetWatching lists what the user watches
+// @Summary List watched tasks and projects
+// @Description List the tasks and projects the user watches, newest first
+// @Tags Watchers
+// @Produce json
+// @Security BearerAuth
+// @Param type query string false "Only tasks or only projects" Enums(task, project)
+// @Param limit query int false "Items per page, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param include_total query bool false "Include the total count"
+// @Success 200 {object} pagination.Page[models.Watch]
+// @Failure 400 {object} utils.Problem "Invalid type or cursor"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Router /v1/watching [get]
+func GetWatching(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	params, err := pagination.Parse(r)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "pagination.invalid_cursor")
+		return
+	}
+	query := db.DB.Model(&models.Watch{}).Where("user_id = ?", userID)
+	switch typ := r.URL.Query().Get("type"); typ {
+	case "":
+	case watchers.Task, watchers.Project:
+		query = query.Where("target_type = ?", typ)
+	default:
+		utils.SendError(w, r, http.StatusBadRequest, "watch.invalid_type", typ)
+		return
+	}
+	total, err := pagination.Total(query, params)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	var items []models.Watch
+	err = params.ApplyOrder(query, "watch", pagination.Order{Desc: true}).Find(&items).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	if err := fillWatchTitles(items); err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	pagination.Write(w, r, params, items, watchCursor, total)
+}
+
+func watchCursor(watch models.Watch) pagination.Cursor {
+	return pagination.Cursor{CreatedAt: watch.CreatedAt, ID: watch.ID}
+}
+
+// fillWatchTitles sets the title of each watch to the title of its task or
+// the name of its project.
+func fillWatchTitles(items []models.Watch) error {
+	var taskIDs, projectIDs []string
+	for _, watch := range items {
+		if watch.TargetType == watchers.Task {
+			taskIDs = append(taskIDs, watch.TargetID)
+		} else {
+			projectIDs = append(projectIDs, watch.TargetID)
+		}
+	}
+	titles := map[string]string{}
+	if len(taskIDs) > 0 {
+		var tasks []models.Task
+		if err := db.DB.Select("id", "title").Find(&tasks, "id in ?", taskIDs).Error; err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			titles[task.ID] = task.Title
+		}
+	}
+	if len(projectIDs) > 0 {
+		var projects []models.Project
+		if err := db.DB.Select("id", "name").Find(&projects, "id in ?", projectIDs).Error; err != nil {
+			return err
+		}
+		for _, project := range projects {
+			titles[project.ID] = project.Name
+		}
+	}
+	for i := range items {
+		items[i].Title = titles[items[i].TargetID]
+	}
+	return nil
+}
+
+func sendWatchers(w http.ResponseWriter, r *http.Request, targetType, targetID string) {
+	list := []watcher{}
+	err := db.DB.Table("watch").
+		Select(`watch.user_id, "user".username, watch.created_at as since`).
+		Joins(`join "user" on "user".id = watch.user_id`).
+		Where("watch.target_type = ? and watch.target_id = ?", targetType, targetID).
+		Order("watch.created_at").
+		Scan(&list).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// watchTask makes users watch task, skipping empty ids.
+func watchTask(tx *gorm.DB, task models.Task, userIDs ...string) error {
+	for _, userID := range userIDs {
+		if err := watchers.Watch(tx, userID, watchers.Task, task.ID, task.ProjectID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadReadableTask loads the task of the request if the user may see it.
+func loadReadableTask(w http.ResponseWriter, r *http.Request) (models.Task, bool) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return task, false
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return task, false
+	}
+	return task, true
+}
+
+// loadAccessibleProject loads the {id} project if the user may access it.
+func loadAccessibleProject(w http.ResponseWriter, r *http.Request) (models.Project, bool) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return project, false
+	}
+	if !canAccessProject(&project, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "project.forbidden")
+		return project, false
+	}
+	return project, true
+}
@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/pagination"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"github.com/Anwarjondev/task-management-api/webhooks"
+	"gorm.io/gorm"
+)
+
+// CreateWebhook subscribes a URL to project events
+// @Summary Create a webhook
+// @Description Subscribe a URL to events of a project. The URL must resolve to a public address. The signing secret is generated unless given and is only returned here. Only the project owner can manage webhooks.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param webhook body models.Webhook true "URL, events and optional secret"
+// @Success 201 {object} models.Webhook
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/webhooks [post]
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	project, ok := loadOwnedProject(w, r)
+	if !ok {
+		return
+	}
+	var hook models.Webhook
+	err := json.NewDecoder(r.Body).Decode(&hook)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	hook.Events = uniqueStrings(hook.Events)
+	err = validate.Struct(&hook)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if !checkWebhookURL(w, r, hook.URL) {
+		return
+	}
+	if hook.Secret == "" {
+		hook.Secret = webhooks.NewSecret()
+	}
+	hook.ProjectID = project.ID
+	hook.CreatorID = userID
+	hook.Active = true
+	hook.ConsecutiveFailures = 0
+	hook.DisabledAt = nil
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&hook).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.WebhookCreated, events.Key("webhook", hook.ID), hook.ProjectID, withoutSecret(hook))
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// GetWebhooks lists the webhooks of a project
+// @Summary List webhooks
+// @Description List the webhooks of a project without their secrets
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Success 200 {array} models.Webhook
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/webhooks [get]
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	project, ok := loadOwnedProject(w, r)
+	if !ok {
+		return
+	}
+	hooks := []models.Webhook{}
+	err := db.DB.Where("project_id = ?", project.ID).Order("created_at").Find(&hooks).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// GetWebhook returns a webhook
+// @Summary Get a webhook
+// @Description Get a webhook without its secret
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param webhookId path string true "Webhook ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
+// @Success 200 {object} models.Webhook
+// @Header 200 {string} ETag "Version of the webhook"
+// @Success 304 {string} string "Not modified"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/webhooks/{webhookId} [get]
+func GetWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if utils.NotModified(w, r, hook.Version) {
+		return
+	}
+	hook.Secret = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hook)
+}
+
+// UpdateWebhook updates a webhook
+// @Summary Update a webhook
+// @Description Change the URL, events or secret of a webhook. Setting active to true re-enables a webhook that was switched off after failing; an empty secret keeps the current one.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param webhookId path string true "Webhook ID"
+// @Param webhook body models.Webhook true "Updated webhook data"
+// @Param If-Match header string true "ETag of the webhook"
+// @Success 200 {object} models.Webhook
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Webhook changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/webhooks/{webhookId} [put]
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, hook.Version) {
+		return
+	}
+	var updateHook models.Webhook
+	err := json.NewDecoder(r.Body).Decode(&updateHook)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	saveWebhook(w, r, hook, updateHook)
+}
+
+// PatchWebhook partially updates a webhook
+// @Summary Patch a webhook
+// @Description Change some fields of the webhook with a JSON merge patch (RFC 7396): fields left out are kept and null clears a field. Patchable fields: url, events, secret, active.
+// @Tags Webhooks
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param webhookId path string true "Webhook ID"
+// @Param webhook body models.Webhook true "Fields to change"
+// @Param If-Match header string true "ETag of the webhook"
+// @Success 200 {object} models.Webhook
+// @Failure 400 {object} utils.Problem "Invalid patch or read-only field"
+// @Failure 401 {object} utils.Problem "Unauthorized"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Webhook changed since it was read"
+// @Failure 415 {object} utils.Problem "Not a JSON merge patch"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/webhooks/{webhookId} [patch]
+func PatchWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, hook.Version) {
+		return
+	}
+	updateHook := hook
+	if !applyMergePatch(w, r, &updateHook, "url", "events", "secret", "active") {
+		return
+	}
+	saveWebhook(w, r, hook, updateHook)
+}
+
+// checkWebhookURL refuses a webhook URL whose host does not resolve or
+// resolves to an address that is not public. The dispatcher checks the
+// address again when it connects.
+func checkWebhookURL(w http.ResponseWriter, r *http.Request, rawURL string) bool {
+	err := webhooks.CheckURL(r.Context(), rawURL)
+	if errors.Is(err, webhooks.ErrForbiddenAddress) {
+		utils.SendError(w, r, http.StatusBadRequest, "webhook.forbidden_address")
+		return false
+	}
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "webhook.unresolvable_host")
+		return false
+	}
+	return true
+}
+
+// saveWebhook validates updateHook and saves its editable fields to hook.
+func saveWebhook(w http.ResponseWriter, r *http.Request, hook, updateHook models.Webhook) {
+	updateHook.Events = uniqueStrings(updateHook.Events)
+	err := validate.Struct(&updateHook)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if !checkWebhookURL(w, r, updateHook.URL) {
+		return
+	}
+	hook.URL = updateHook.URL
+	hook.Events = updateHook.Events
+	if updateHook.Secret != "" {
+		hook.Secret = updateHook.Secret
+	}
+	if updateHook.Active && !hook.Active {
+		hook.ConsecutiveFailures = 0
+		hook.DisabledAt = nil
+	}
+	hook.Active = updateHook.Active
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx, &hook, hook.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.WebhookUpdated, events.Key("webhook", hook.ID), hook.ProjectID, withoutSecret(hook))
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	hook.Secret = ""
+	utils.SetETag(w, hook.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hook)
+}
+
+// DeleteWebhook deletes a webhook
+// @Summary Delete a webhook
+// @Description Delete a webhook and its delivery log
+// @Tags Webhooks
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param webhookId path string true "Webhook ID"
+// @Param If-Match header string true "ETag of the webhook"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Webhook changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/webhooks/{webhookId} [delete]
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if !utils.CheckIfMatch(w, r, hook.Version) {
+		return
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		if err := deleteVersioned(tx, &hook, hook.Version); err != nil {
+			return err
+		}
+		return record(tx, r, events.WebhookDeleted, events.Key("webhook", hook.ID), hook.ProjectID, deleted{hook.ID})
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries lists the delivery log of a webhook
+// @Summary List webhook deliveries
+// @Description List the deliveries of a webhook, newest first, with the response of their last attempt
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param webhookId path string true "Webhook ID"
+// @Param status query string false "pending, succeeded or failed"
+// @Param limit query int false "Items per page, at most 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param include_total query bool false "Include the total count"
+// @Success 200 {object} pagination.Page[models.WebhookDelivery]
+// @Failure 400 {object} utils.Problem "Invalid cursor"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/webhooks/{webhookId}/deliveries [get]
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	params, err := pagination.Parse(r)
+	if err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "pagination.invalid_cursor")
+		return
+	}
+	query := db.DB.Model(&models.WebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	total, err := pagination.Total(query, params)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	var deliveries []models.WebhookDelivery
+	err = params.ApplyOrder(query, "webhook_delivery", pagination.Order{Desc: true}).Find(&deliveries).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	pagination.Write(w, r, params, deliveries, deliveryCursor, total)
+}
+
+func deliveryCursor(delivery models.WebhookDelivery) pagination.Cursor {
+	return pagination.Cursor{CreatedAt: delivery.CreatedAt, ID: delivery.ID}
+}
+
+// GetWebhookDelivery returns one delivery
+// @Summary Get a webhook delivery
+// @Description Get a delivery with its payload and the response of its last attempt
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param webhookId path string true "Webhook ID"
+// @Param deliveryId path string true "Delivery ID"
+// @Success 200 {object} models.WebhookDelivery
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/webhooks/{webhookId}/deliveries/{deliveryId} [get]
+func GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := loadWebhookDelivery(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// RedeliverWebhook sends a delivery again
+// @Summary Redeliver a webhook event
+// @Description Queue a new delivery with the same event and payload. The original delivery is kept in the log.
+// @Tags Webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param webhookId path string true "Webhook ID"
+// @Param deliveryId path string true "Delivery ID"
+// @Success 202 {object} models.WebhookDelivery
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/webhooks/{webhookId}/deliveries/{deliveryId}/redeliver [post]
+func RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := loadWebhookDelivery(w, r)
+	if !ok {
+		return
+	}
+	redelivery, err := webhooks.Redeliver(db.DB, &delivery)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(redelivery)
+}
+
+// loadOwnedProject loads the project of the route and checks that the user
+// owns it.
+func loadOwnedProject(w http.ResponseWriter, r *http.Request) (models.Project, bool) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	var project models.Project
+	err := db.DB.First(&project, "id = ?", r.PathValue("id")).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "project.not_found")
+		return project, false
+	}
+	if project.OwnerID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "project.owner_only")
+		return project, false
+	}
+	return project, true
+}
+
+func loadWebhook(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	var hook models.Webhook
+	project, ok := loadOwnedProject(w, r)
+	if !ok {
+		return hook, false
+	}
+	err := db.DB.First(&hook, "id = ? and project_id = ?", r.PathValue("webhookId"), project.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "webhook.not_found")
+		return hook, false
+	}
+	return hook, true
+}
+
+func loadWebhookDelivery(w http.ResponseWriter, r *http.Request) (models.WebhookDelivery, bool) {
+	var delivery models.WebhookDelivery
+	hook, ok := loadWebhook(w, r)
+	if !ok {
+		return delivery, false
+	}
+	err := db.DB.First(&delivery, "id = ? and webhook_id = ?", r.PathValue("deliveryId"), hook.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "webhook.delivery_not_found")
+		return delivery, false
+	}
+	return delivery, true
+}
@@ -0,0 +1,449 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+// TimeSummary is the estimated, logged and remaining time of a task in minutes.
+type TimeSummary struct {
+	TaskID           string `json:"task_id"`
+	EstimateMinutes  int    `json:"estimate_minutes"`
+	LoggedMinutes    int    `json:"logged_minutes"`
+	RemainingMinutes int    `json:"remaining_minutes"`
+}
+
+// fillTimeTotals sets LoggedMinutes and RemainingMinutes on the given tasks.
+// Logged time includes entries made on the tasks' subtasks.
+func fillTimeTotals(tasks []models.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	ids := make([]string, len(tasks))
+	for i := range tasks {
+		ids[i] = tasks[i].ID
+	}
+	var rows []struct {
+		TaskID  string
+		Minutes int
+	}
+	err := db.DB.Model(&models.WorkLog{}).
+		Select("task_id, sum(minutes) as minutes").
+		Where("task_id in ?", ids).
+		Group("task_id").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	logged := make(map[string]int, len(rows))
+	for _, row := range rows {
+		logged[row.TaskID] = row.Minutes
+	}
+	for i := range tasks {
+		tasks[i].LoggedMinutes = logged[tasks[i].ID]
+		tasks[i].RemainingMinutes = max(tasks[i].EstimateMinutes-tasks[i].LoggedMinutes, 0)
+	}
+	return nil
+}
+
+// loadSubtaskOf returns the subtask if it belongs to the task.
+func loadSubtaskOf(taskID string, subtaskID *string) (*models.Subtask, error) {
+	if subtaskID == nil || *subtaskID == "" {
+		return nil, nil
+	}
+	var subtask models.Subtask
+	err := db.DB.First(&subtask, "id = ? and task_id = ?", *subtaskID, taskID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &subtask, nil
+}
+
+// CreateWorkLog logs time on a task
+// @Summary Log work
+// @Description Log time spent on a task or one of its subtasks
+// @Tags Time tracking
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param worklog body object true "Minutes, date (YYYY-MM-DD), note and optional subtask_id"
+// @Success 201 {object} models.WorkLog
+// @Failure 400 {object} utils.Problem "Invalid request"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/worklogs [post]
+func CreateWorkLog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	var input struct {
+		Minutes   int     `json:"minutes" validate:"required,min=1,max=1440"`
+		Date      string  `json:"date" validate:"omitempty,datetime=2006-01-02"`
+		Note      string  `json:"note" validate:"max=500"`
+		SubtaskID *string `json:"subtask_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		utils.SendDecodeError(w, r, err)
+		return
+	}
+	err = validate.Struct(&input)
+	if err != nil {
+		utils.SendValidationError(w, r, err)
+		return
+	}
+	if _, err := loadSubtaskOf(task.ID, input.SubtaskID); err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "subtask.not_in_task")
+		return
+	}
+	date := time.Now().UTC().Truncate(24 * time.Hour)
+	if input.Date != "" {
+		date, _ = time.Parse("2006-01-02", input.Date)
+	}
+	workLog := models.WorkLog{
+		TaskID:    task.ID,
+		SubtaskID: input.SubtaskID,
+		UserID:    userID,
+		Minutes:   input.Minutes,
+		Date:      date,
+		Note:      input.Note,
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&workLog).Error; err != nil {
+			return err
+		}
+		if err := touch(tx, &models.Task{}, task.ID); err != nil {
+			return err
+		}
+		return record(tx, r, events.WorkLogCreated, events.Key("task", task.ID), task.ProjectID, workLog)
+	})
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workLog)
+}
+
+// GetWorkLogs lists time logged on a task
+// @Summary List work logs
+// @Description List work logs of a task, including those on its subtasks
+// @Tags Time tracking
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Success 200 {array} models.WorkLog
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/worklogs [get]
+func GetWorkLogs(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	var workLogs []models.WorkLog
+	err := db.DB.Where("task_id = ?", task.ID).Order("date, created_at").Find(&workLogs).Error
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workLogs)
+}
+
+// DeleteWorkLog deletes a work log entry
+// @Summary Delete a work log
+// @Description Delete a work log if the user created it or is admin
+// @Tags Time tracking
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param worklogId path string true "Work log ID"
+// @Param If-Match header string true "ETag of the work log"
+// @Success 204 {string} string "No content"
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 412 {object} utils.Problem "Work log changed since it was read"
+// @Failure 428 {object} utils.Problem "If-Match missing"
+// @Router /v1/projects/{id}/tasks/{taskId}/worklogs/{worklogId} [delete]
+func DeleteWorkLog(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	var workLog models.WorkLog
+	err := db.DB.First(&workLog, "id = ? and task_id = ?", r.PathValue("worklogId"), task.ID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "worklog.not_found")
+		return
+	}
+	if workLog.UserID != userID && role != "admin" {
+		utils.SendError(w, r, http.StatusForbidden, "worklog.forbidden")
+		return
+	}
+	if !utils.CheckIfMatch(w, r, workLog.Version) {
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &workLog, workLog.Version); err != nil {
+			return err
+		}
+		if err := touch(tx, &models.Task{}, task.ID); err != nil {
+			return err
+		}
+		return record(tx, r, events.WorkLogDeleted, events.Key("task", task.ID), task.ProjectID, deleted{workLog.ID})
+	})
+	if err != nil {
+		sendWriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTaskTime returns time totals for a task
+// @Summary Task time totals
+// @Description Estimated, logged and remaining minutes of a task
+// @Tags Time tracking
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Success 200 {object} TimeSummary
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Router /v1/projects/{id}/tasks/{taskId}/time [get]
+func GetTaskTime(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	tasks := []models.Task{task}
+	err := fillTimeTotals(tasks)
+	if err != nil {
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TimeSummary{
+		TaskID:           tasks[0].ID,
+		EstimateMinutes:  tasks[0].EstimateMinutes,
+		LoggedMinutes:    tasks[0].LoggedMinutes,
+		RemainingMinutes: tasks[0].RemainingMinutes,
+	})
+}
+
+// StartTimer starts the user's timer on a task
+// @Summary Start timer
+// @Description Start a timer on a task or subtask; a user can only run one timer at a time
+// @Tags Time tracking
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Project ID"
+// @Param taskId path string true "Task ID"
+// @Param timer body object false "Optional subtask_id"
+// @Success 201 {object} models.Timer
+// @Failure 403 {object} utils.Problem "Forbidden"
+// @Failure 404 {object} utils.Problem "Not found"
+// @Failure 409 {object} utils.Problem "Timer already running"
+// @Router /v1/projects/{id}/tasks/{taskId}/timer [post]
+func StartTimer(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	role := r.Context().Value("role").(string)
+
+	task, ok := loadTask(w, r)
+	if !ok {
+		return
+	}
+	if !canReadTask(&task, userID, role) {
+		utils.SendError(w, r, http.StatusForbidden, "task.forbidden")
+		return
+	}
+	var input struct {
+		SubtaskID *string `json:"subtask_id"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+	}
+	if _, err := loadSubtaskOf(task.ID, input.SubtaskID); err != nil {
+		utils.SendError(w, r, http.StatusBadRequest, "subtask.not_in_task")
+		return
+	}
+	timer := models.Timer{
+		UserID:    userID,
+		TaskID:    task.ID,
+		SubtaskID: input.SubtaskID,
+		StartedAt: time.Now().UTC(),
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&timer).Error; err != nil {
+			return err
+		}
+		return record(tx, r, events.TimerStarted, events.Key("task", task.ID), task.ProjectID, timer)
+	})
+	if err != nil {
+		if db.DB.First(&models.Timer{}, "user_id = ?", userID).Error == nil {
+			utils.SendError(w, r, http.StatusConflict, "timer.already_running")
+			return
+		}
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(timer)
+}
+
+// GetTimer returns the user's running timer
+// @Summary Running timer
+// @Description Get the timer the user is currently running
+// @Tags Time tracking
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.Timer
+// @Failure 404 {object} utils.Problem "No running timer"
+// @Router /v1/timer [get]
+func GetTimer(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	var timer models.Timer
+	err := db.DB.First(&timer, "user_id = ?", userID).Error
+	if err != nil {
+		utils.SendError(w, r, http.StatusNotFound, "timer.not_running")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timer)
+}
+
+// StopTimer stops the user's timer and logs the elapsed time
+// @Summary Stop timer
+// @Description Stop the running timer and turn it into a work log entry
+// @Tags Time tracking
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param timer body object false "Optional note"
+// @Success 201 {object} models.WorkLog
+// @Failure 404 {object} utils.Problem "No running timer, or its task was deleted and the timer discarded"
+// @Router /v1/timer/stop [post]
+func StopTimer(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	var input struct {
+		Note string `json:"note" validate:"max=500"`
+	}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil {
+			utils.SendDecodeError(w, r, err)
+			return
+		}
+		if err := validate.Struct(&input); err != nil {
+			utils.SendValidationError(w, r, err)
+			return
+		}
+	}
+	var workLog models.WorkLog
+	// taskDeleted is set when the timer ran on a task that was deleted
+	// since. The timer is discarded without logging time.
+	taskDeleted := false
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var timer models.Timer
+		if err := tx.First(&timer, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&timer)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		var task models.Task
+		if err := tx.Select("id", "project_id").Limit(1).Find(&task, "id = ?", timer.TaskID).Error; err != nil {
+			return err
+		}
+		if task.ID == "" {
+			taskDeleted = true
+			return nil
+		}
+		now := time.Now().UTC()
+		workLog = models.WorkLog{
+			TaskID:    timer.TaskID,
+			SubtaskID: timer.SubtaskID,
+			UserID:    userID,
+			Minutes:   max(int(math.Ceil(now.Sub(timer.StartedAt).Minutes())), 1),
+			Date:      timer.StartedAt.Truncate(24 * time.Hour),
+			Note:      input.Note,
+		}
+		if err := tx.Create(&workLog).Error; err != nil {
+			return err
+		}
+		if err := touch(tx, &models.Task{}, timer.TaskID); err != nil {
+			return err
+		}
+		err := record(tx, r, events.TimerStopped, events.Key("task", task.ID), task.ProjectID, timer)
+		if err != nil {
+			return err
+		}
+		return record(tx, r, events.WorkLogCreated, events.Key("task", task.ID), task.ProjectID, workLog)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(w, r, http.StatusNotFound, "timer.not_running")
+			return
+		}
+		utils.SendInternalError(w, r, err)
+		return
+	}
+	if taskDeleted {
+		utils.SendError(w, r, http.StatusNotFound, "timer.task_deleted")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(workLog)
+}
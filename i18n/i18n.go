@@ -0,0 +1,146 @@
+// Package i18n translates API messages based on the Accept-Language header.
+//
+// Messages live in JSON catalogs, one file per locale (en.json, ru.json,
+// uz.json), mapping a key to a text with {0}, {1}... placeholders. The
+// catalogs in locales/ are embedded in the binary; files with the same
+// names in I18N_DIR are merged over them, so messages can be reworded or
+// added without a rebuild.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/locales"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/ru"
+	"github.com/go-playground/locales/uz"
+	ut "github.com/go-playground/universal-translator"
+)
+
+//go:embed locales/*.json
+var embedded embed.FS
+
+// maxParams pads parameters so that a catalog text with more placeholders
+// than the caller passed renders them empty instead of panicking.
+const maxParams = 8
+
+var (
+	fallback  = en.New()
+	supported = []locales.Translator{fallback, ru.New(), uz.New()}
+	universal = ut.New(fallback, supported...)
+)
+
+func init() {
+	if err := load(embedded, "locales"); err != nil {
+		panic("Error with loading embedded message catalogs: " + err.Error())
+	}
+}
+
+// Load merges the catalogs found in I18N_DIR over the embedded ones.
+func Load() {
+	dir := os.Getenv("I18N_DIR")
+	if dir == "" {
+		return
+	}
+	if err := load(os.DirFS(dir), "."); err != nil {
+		log.Fatal("Error with loading message catalogs: ", err)
+	}
+	log.Println("Message catalogs loaded from", dir)
+}
+
+func load(fsys fs.FS, dir string) error {
+	for _, locale := range supported {
+		name := locale.Locale() + ".json"
+		data, err := fs.ReadFile(fsys, filepath.ToSlash(filepath.Join(dir, name)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		trans, _ := universal.GetTranslator(locale.Locale())
+		for key, text := range catalog {
+			if err := trans.Add(key, text, true); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Locale returns the best supported locale for the request, "en" when
+// Accept-Language names none of them.
+func Locale(r *http.Request) string {
+	return translator(r).Locale()
+}
+
+// T translates key for the request's locale, falling back to English and
+// then to def when no catalog has the key.
+func T(r *http.Request, key, def string, params ...string) string {
+	padded := make([]string, maxParams)
+	copy(padded, params)
+	for _, trans := range []ut.Translator{translator(r), universal.GetFallback()} {
+		if text, err := trans.T(key, padded...); err == nil {
+			return text
+		}
+	}
+	return def
+}
+
+func translator(r *http.Request) ut.Translator {
+	if r == nil {
+		return universal.GetFallback()
+	}
+	trans, _ := universal.FindTranslator(acceptedLocales(r.Header.Get("Accept-Language"))...)
+	return trans
+}
+
+// acceptedLocales orders the tags of an Accept-Language header by quality.
+// "ru-RU" is offered as "ru_RU" and then as "ru".
+func acceptedLocales(header string) []string {
+	type tag struct {
+		name    string
+		quality float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" || name == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if v, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = v
+			}
+		}
+		if quality > 0 {
+			tags = append(tags, tag{strings.ReplaceAll(name, "-", "_"), quality})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+
+	var names []string
+	for _, t := range tags {
+		names = append(names, t.name)
+		if base, _, found := strings.Cut(t.name, "_"); found {
+			names = append(names, base)
+		}
+	}
+	return names
+}
@@ -0,0 +1,204 @@
+// Package inbound receives email: it parses MIME messages and runs a small
+// SMTP listener that hands accepted messages to a Handler.
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// Message is a parsed email. Text is the plain text body, or the HTML body
+// with its markup removed when the message has no plain text.
+type Message struct {
+	From        string
+	FromName    string
+	To          []string
+	Subject     string
+	Text        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	FileName    string
+	ContentType string
+	Content     []byte
+}
+
+var decoder = mime.WordDecoder{}
+
+// Parse parses a raw RFC 5322 message.
+func Parse(raw []byte) (*Message, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if from, err := mail.ParseAddress(m.Header.Get("From")); err == nil {
+		msg.From = strings.ToLower(from.Address)
+		msg.FromName = from.Name
+	}
+	for _, field := range []string{"To", "Cc", "Delivered-To", "X-Original-To"} {
+		list, err := m.Header.AddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, addr := range list {
+			msg.To = append(msg.To, strings.ToLower(addr.Address))
+		}
+	}
+	msg.Subject, err = decoder.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		msg.Subject = m.Header.Get("Subject")
+	}
+	msg.Subject = strings.TrimSpace(msg.Subject)
+
+	var htmlBody string
+	err = walk(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Header.Get("Content-Disposition"), m.Body, msg, &htmlBody)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Text == "" && htmlBody != "" {
+		msg.Text = htmlToText(htmlBody)
+	}
+	msg.Text = strings.TrimSpace(strings.ReplaceAll(msg.Text, "\r\n", "\n"))
+	return msg, nil
+}
+
+// walk collects the bodies and attachments of a MIME part and its
+// children.
+func walk(contentType, encoding, disposition string, body io.Reader, msg *Message, htmlBody *string) error {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = walk(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part.Header.Get("Content-Disposition"), part, msg, htmlBody)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decode(body, encoding))
+	if err != nil {
+		return err
+	}
+	dispositionType, dispositionParams, _ := mime.ParseMediaType(disposition)
+	fileName := dispositionParams["filename"]
+	if fileName == "" {
+		fileName = params["name"]
+	}
+	if name, err := decoder.DecodeHeader(fileName); err == nil {
+		fileName = name
+	}
+	switch {
+	case dispositionType == "attachment" || fileName != "":
+		msg.Attachments = append(msg.Attachments, Attachment{FileName: fileName, ContentType: mediaType, Content: content})
+	case mediaType == "text/plain" && msg.Text == "":
+		msg.Text = string(content)
+	case mediaType == "text/html" && *htmlBody == "":
+		*htmlBody = string(content)
+	}
+	return nil
+}
+
+func decode(body io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineSkipper{bufio.NewReader(body)})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// newlineSkipper drops the line breaks of base64 content.
+type newlineSkipper struct {
+	r *bufio.Reader
+}
+
+func (s *newlineSkipper) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+			p[n] = b
+			n++
+		}
+	}
+	return n, nil
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>|</li>|</tr>`)
+	htmlTags   = regexp.MustCompile(`(?s)<style.*?</style>|<script.*?</script>|<[^>]*>`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+func htmlToText(s string) string {
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	return blankLines.ReplaceAllString(s, "\n\n")
+}
+
+var replyHeader = regexp.MustCompile(`^(On .+ wrote:|-+ ?Original Message ?-+)$`)
+
+// StripQuoted returns the text of a reply without the quoted message it
+// answers.
+func StripQuoted(text string) string {
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if replyHeader.MatchString(trimmed) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+var taskReference = regexp.MustCompile(`(?i)\[task:([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\]`)
+
+// TaskReference returns the subject tag naming a task. Mail whose subject
+// carries it is filed as a comment on the task.
+func TaskReference(taskID string) string {
+	return "[task:" + taskID + "]"
+}
+
+// FindTaskReference returns the id of the task tagged in subject.
+func FindTaskReference(subject string) (string, bool) {
+	match := taskReference.FindStringSubmatch(subject)
+	if match == nil {
+		return "", false
+	}
+	return strings.ToLower(match[1]), true
+}
@@ -0,0 +1,185 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnknownRecipient is returned by a Handler for a recipient it
+	// does not receive mail for.
+	ErrUnknownRecipient = errors.New("inbound: unknown recipient")
+	// ErrRejected is wrapped by Handler errors that trying again will
+	// not fix, such as a message that cannot be parsed.
+	ErrRejected = errors.New("inbound: message rejected")
+)
+
+// Handler receives a message for one recipient.
+type Handler func(recipient string, raw []byte) error
+
+// Server is a minimal SMTP server for receiving mail, without TLS or
+// authentication; run it behind the MX that faces the internet. Accept
+// decides which recipients are taken; every accepted message is passed to
+// Handle once per recipient.
+type Server struct {
+	Addr     string
+	Hostname string
+	MaxSize  int64
+	Accept   func(recipient string) bool
+	Handle   Handler
+}
+
+// ListenAndServe serves SMTP on s.Addr until ctx is cancelled. An empty
+// Hostname defaults to the host name of the machine.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if s.Hostname == "" {
+		s.Hostname, _ = os.Hostname()
+	}
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	log.Println("Receiving email on", s.Addr)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	reply := func(code int, message string) {
+		text.PrintfLine("%d %s", code, message)
+	}
+	var from string
+	var recipients []string
+	started := false
+	reset := func() {
+		from, recipients, started = "", nil, false
+	}
+
+	reply(220, s.Hostname+" ESMTP ready")
+	for {
+		conn.SetDeadline(time.Now().Add(5 * time.Minute))
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reset()
+			reply(250, s.Hostname)
+		case "EHLO":
+			reset()
+			text.PrintfLine("250-%s", s.Hostname)
+			text.PrintfLine("250-8BITMIME")
+			text.PrintfLine("250 SIZE %d", s.MaxSize)
+		case "MAIL":
+			addr, ok := pathArg(arg, "FROM:")
+			if !ok {
+				reply(501, "Syntax: MAIL FROM:<address>")
+				continue
+			}
+			reset()
+			from, started = addr, true
+			reply(250, "OK")
+		case "RCPT":
+			addr, ok := pathArg(arg, "TO:")
+			switch {
+			case !ok:
+				reply(501, "Syntax: RCPT TO:<address>")
+			case !started:
+				reply(503, "MAIL first")
+			case addr == "":
+				reply(501, "Syntax: RCPT TO:<address>")
+			case !s.Accept(addr):
+				reply(550, "No such mailbox")
+			default:
+				recipients = append(recipients, addr)
+				reply(250, "OK")
+			}
+		case "DATA":
+			if len(recipients) == 0 {
+				reply(503, "RCPT first")
+				continue
+			}
+			reply(354, "End data with <CR><LF>.<CR><LF>")
+			dot := text.DotReader()
+			raw, err := io.ReadAll(io.LimitReader(dot, s.MaxSize+1))
+			if err != nil {
+				return
+			}
+			if int64(len(raw)) > s.MaxSize {
+				io.Copy(io.Discard, dot)
+				reply(552, "Message too large")
+				reset()
+				continue
+			}
+			var failed error
+			for _, rcpt := range recipients {
+				if err := s.Handle(rcpt, raw); err != nil {
+					log.Printf("Inbound email from %s to %s failed: %v", from, rcpt, err)
+					failed = err
+				}
+			}
+			switch {
+			case failed == nil:
+				reply(250, "OK")
+			case errors.Is(failed, ErrRejected), errors.Is(failed, ErrUnknownRecipient):
+				reply(554, "Message rejected")
+			default:
+				reply(451, "Could not process message, try again later")
+			}
+			reset()
+		case "RSET":
+			reset()
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			reply(502, "Command not implemented")
+		}
+	}
+}
+
+// pathArg parses the address of "FROM:<address>" or "TO:<address>",
+// ignoring ESMTP parameters after it.
+func pathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	path, _, _ = strings.Cut(path, " ")
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "<"), ">")
+	if path == "" {
+		return "", true
+	}
+	addr, err := mail.ParseAddress("<" + path + ">")
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(addr.Address), true
+}
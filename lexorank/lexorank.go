@@ -0,0 +1,100 @@
+// Package lexorank generates string ranks for manual ordering. A new rank
+// always fits between two existing ones, so moving an item only rewrites
+// that item's rank. Ranks grow when items keep landing in the same spot;
+// once one passes MaxLength the list is respread with Spread.
+package lexorank
+
+import (
+	"errors"
+	"strings"
+)
+
+const digits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+var ErrInvalidRange = errors.New("lexorank: prev must sort before next")
+
+// MaxLength is the longest rank worth storing. Past it, callers should
+// respread the list instead.
+const MaxLength = 32
+
+// Between returns a rank that sorts after prev and before next using byte
+// order. An empty prev means the start of the list, an empty next the end.
+// Ranks produced here never end in '0', which keeps room on both sides.
+func Between(prev, next string) (string, error) {
+	if next != "" && prev >= next {
+		return "", ErrInvalidRange
+	}
+	if strings.HasSuffix(prev, "0") || strings.HasSuffix(next, "0") {
+		return "", ErrInvalidRange
+	}
+	return midpoint(prev, next, next != ""), nil
+}
+
+func midpoint(a, b string, hasB bool) string {
+	if hasB {
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midpoint(rest, b[n:], true)
+		}
+	}
+	lo := 0
+	if a != "" {
+		lo = strings.IndexByte(digits, a[0])
+	}
+	hi := len(digits)
+	if hasB {
+		hi = strings.IndexByte(digits, b[0])
+	}
+	if hi-lo > 1 {
+		return string(digits[(lo+hi+1)/2])
+	}
+	if hasB && len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if a != "" {
+		rest = a[1:]
+	}
+	return string(digits[lo]) + midpoint(rest, "", false)
+}
+
+// Spread returns n ranks in increasing order, evenly spaced with room for
+// at least one single-digit step between neighbours.
+func Spread(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	width, space := 1, len(digits)
+	for space/(n+1) < len(digits) {
+		width++
+		space *= len(digits)
+	}
+	step := space / (n + 1)
+	ranks := make([]string, n)
+	buf := make([]byte, width)
+	for i := range ranks {
+		value := step * (i + 1)
+		for j := width - 1; j >= 0; j-- {
+			buf[j] = digits[value%len(digits)]
+			value /= len(digits)
+		}
+		// Dropping trailing zeros keeps the order and the rule that
+		// ranks never end in '0'.
+		ranks[i] = strings.TrimRight(string(buf), "0")
+	}
+	return ranks
+}
+
+func digitAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return digits[0]
+}
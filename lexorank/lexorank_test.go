@@ -0,0 +1,70 @@
+package lexorank
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBetween(t *testing.T) {
+	tests := []struct{ prev, next string }{
+		{"", ""},
+		{"i", ""},
+		{"", "i"},
+		{"a", "b"},
+		{"z", ""},
+		{"a", "a1"},
+		{"ai", "b"},
+	}
+	for _, tt := range tests {
+		rank, err := Between(tt.prev, tt.next)
+		if err != nil {
+			t.Fatalf("Between(%q, %q): %v", tt.prev, tt.next, err)
+		}
+		if rank <= tt.prev || tt.next != "" && rank >= tt.next || strings.HasSuffix(rank, "0") {
+			t.Errorf("Between(%q, %q) = %q", tt.prev, tt.next, rank)
+		}
+	}
+	for _, tt := range []struct{ prev, next string }{{"b", "a"}, {"a", "a"}, {"a0", ""}} {
+		if _, err := Between(tt.prev, tt.next); err != ErrInvalidRange {
+			t.Errorf("Between(%q, %q) err = %v, want ErrInvalidRange", tt.prev, tt.next, err)
+		}
+	}
+}
+
+// TestAppendGrows shows why lists need Spread: appending keeps making
+// ranks longer.
+func TestAppendGrows(t *testing.T) {
+	rank := ""
+	for i := 0; i < 500; i++ {
+		next, err := Between(rank, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rank = next
+	}
+	if len(rank) <= MaxLength {
+		t.Errorf("500 appends gave a rank of %d characters", len(rank))
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 35, 36, 1000, 50000} {
+		ranks := Spread(n)
+		if len(ranks) != n {
+			t.Fatalf("Spread(%d) returned %d ranks", n, len(ranks))
+		}
+		for i, rank := range ranks {
+			if rank == "" || strings.HasSuffix(rank, "0") || len(rank) > MaxLength {
+				t.Fatalf("Spread(%d)[%d] = %q", n, i, rank)
+			}
+			if i > 0 && ranks[i-1] >= rank {
+				t.Fatalf("Spread(%d) is not increasing at %d: %q >= %q", n, i, ranks[i-1], rank)
+			}
+			if i > 0 {
+				if _, err := Between(ranks[i-1], rank); err != nil {
+					t.Fatalf("Between(%q, %q): %v", ranks[i-1], rank, err)
+				}
+			}
+		}
+	}
+}
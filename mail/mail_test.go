@@ -0,0 +1,69 @@
+package mail_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Anwarjondev/task-management-api/mail"
+	"github.com/Anwarjondev/task-management-api/mail/mailtest"
+)
+
+func TestSMTPSenderSendsMultipart(t *testing.T) {
+	server := mailtest.NewServer(t)
+	sender := &mail.SMTPSender{Addr: server.Addr, From: "Tasks <tasks@example.com>"}
+
+	err := sender.Send(mail.Message{
+		To:      "ali@example.com",
+		Subject: "Vazifa tayinlandi: Отчёт",
+		Text:    "Hi Ali, a line long enough to be wrapped by quoted-printable encoding, which keeps lines under 76 characters.",
+		HTML:    "<p>Hi <strong>Ali</strong></p>",
+		Header:  map[string]string{"List-Unsubscribe": "<https://api.example.com/v1/unsubscribe?user=1&sig=x>"},
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("captured %d messages, want 1", len(messages))
+	}
+	if messages[0].From != "tasks@example.com" || strings.Join(messages[0].To, ",") != "ali@example.com" {
+		t.Errorf("envelope = %s -> %v", messages[0].From, messages[0].To)
+	}
+
+	msg, err := messages[0].Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Vazifa tayinlandi: Отчёт" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if got := msg.Header.Get("List-Unsubscribe"); got != "<https://api.example.com/v1/unsubscribe?user=1&sig=x>" {
+		t.Errorf("List-Unsubscribe = %q", got)
+	}
+	parts := msg.Parts
+	if !strings.HasPrefix(parts["text/plain"], "Hi Ali, a line long enough") || !strings.HasSuffix(parts["text/plain"], "76 characters.") {
+		t.Errorf("text part = %q", parts["text/plain"])
+	}
+	if parts["text/html"] != "<p>Hi <strong>Ali</strong></p>" {
+		t.Errorf("HTML part = %q", parts["text/html"])
+	}
+}
+
+func TestPermanentRejection(t *testing.T) {
+	server := mailtest.NewServer(t)
+	server.Reject = func(rcpt string) bool { return rcpt == "gone@example.com" }
+	sender := &mail.SMTPSender{Addr: server.Addr, From: "tasks@example.com"}
+
+	err := sender.Send(mail.Message{To: "gone@example.com", Subject: "s", Text: "t", HTML: "h"})
+	if err == nil || !mail.Permanent(err) {
+		t.Errorf("Send to a rejected recipient = %v, want a permanent error", err)
+	}
+	if len(server.Messages()) != 0 {
+		t.Error("rejected message was captured")
+	}
+
+	sender.Addr = "127.0.0.1:1"
+	if err := sender.Send(mail.Message{To: "ali@example.com"}); err == nil || mail.Permanent(err) {
+		t.Errorf("Send to a closed port = %v, want a temporary error", err)
+	}
+}
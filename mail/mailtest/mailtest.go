@@ -0,0 +1,188 @@
+// Package mailtest provides an in-process SMTP server that captures the
+// messages sent to it, in the spirit of net/http/httptest.
+package mailtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Message is one captured email.
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// Parsed is a captured message decoded for assertions.
+type Parsed struct {
+	Header  mail.Header
+	Subject string
+	// Parts maps the content types of the parts of a multipart message to
+	// their decoded bodies.
+	Parts map[string]string
+}
+
+// Parse decodes the headers and the parts of m.
+func (m Message) Parse() (Parsed, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return Parsed{}, err
+	}
+	p := Parsed{Header: msg.Header, Parts: map[string]string{}}
+	p.Subject, err = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return p, err
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return p, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return p, fmt.Errorf("mailtest: %s is not multipart", mediaType)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return p, err
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		// NextPart undoes the quoted-printable transfer encoding.
+		body, err := io.ReadAll(part)
+		if err != nil {
+			return p, err
+		}
+		p.Parts[contentType] = string(body)
+	}
+}
+
+// Server is a minimal SMTP server listening on a loopback address. It
+// supports no extensions, so clients send plain SMTP without TLS or auth.
+type Server struct {
+	// Addr is the host:port the server listens on.
+	Addr string
+	// Reject, when set, is asked for every recipient; rejected recipients
+	// are refused with a permanent 550 reply.
+	Reject func(rcpt string) bool
+
+	listener net.Listener
+	mu       sync.Mutex
+	messages []Message
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("mailtest: listen: %v", err)
+	}
+	s := &Server{Addr: listener.Addr().String(), listener: listener}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the server and waits for open sessions to end.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Messages returns the messages accepted so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.session(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *Server) session(conn *textproto.Conn) {
+	conn.PrintfLine("220 mailtest ready")
+	var msg Message
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250 mailtest")
+		case "MAIL":
+			msg = Message{From: address(arg)}
+			conn.PrintfLine("250 OK")
+		case "RCPT":
+			rcpt := address(arg)
+			if s.Reject != nil && s.Reject(rcpt) {
+				conn.PrintfLine("550 5.1.1 %s: no such user", rcpt)
+				continue
+			}
+			msg.To = append(msg.To, rcpt)
+			conn.PrintfLine("250 OK")
+		case "DATA":
+			if len(msg.To) == 0 {
+				conn.PrintfLine("503 5.5.1 no valid recipients")
+				continue
+			}
+			conn.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(conn.DotReader())
+			if err != nil {
+				return
+			}
+			msg.Data = data
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = Message{}
+			conn.PrintfLine("250 OK")
+		case "RSET":
+			msg = Message{}
+			conn.PrintfLine("250 OK")
+		case "NOOP":
+			conn.PrintfLine("250 OK")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+			return
+		default:
+			conn.PrintfLine("502 5.5.2 command not implemented")
+		}
+	}
+}
+
+// address extracts the mailbox from "FROM:<a@b>" or "TO:<a@b>".
+func address(arg string) string {
+	_, value, _ := strings.Cut(arg, ":")
+	value, _, _ = strings.Cut(strings.TrimSpace(value), " ")
+	return strings.Trim(value, "<>")
+}
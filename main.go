@@ -1,11 +1,25 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/handlers"
+	"github.com/Anwarjondev/task-management-api/i18n"
+	"github.com/Anwarjondev/task-management-api/inbound"
+	"github.com/Anwarjondev/task-management-api/mail"
+	"github.com/Anwarjondev/task-management-api/middleware"
+	"github.com/Anwarjondev/task-management-api/notifications"
 	"github.com/Anwarjondev/task-management-api/routes"
+	"github.com/Anwarjondev/task-management-api/scheduler"
+	"github.com/Anwarjondev/task-management-api/storage"
+	"github.com/Anwarjondev/task-management-api/stream"
+	"github.com/Anwarjondev/task-management-api/webhooks"
 	_ "github.com/Anwarjondev/task-management-api/docs" // Import generated docs
     httpSwagger "github.com/swaggo/http-swagger"
 )
@@ -13,13 +27,39 @@ import (
 func main() {
 	db.Connect()
 	db.AutoMigrate()
+	storage.Connect()
+	i18n.Load()
+	mail.Connect()
+	go scheduler.Start(context.Background(), time.Minute)
+	events.Register("webhooks", events.SinkFunc(webhooks.Publish))
+	events.Register("notifications", events.SinkFunc(notifications.Publish))
+	go events.Start(context.Background(), time.Second)
+	go stream.Listen(context.Background(), 5*time.Second)
+	if mail.Default != nil {
+		go notifications.StartEmail(context.Background(), time.Minute)
+	}
+	if addr := os.Getenv("INBOUND_SMTP_ADDR"); addr != "" {
+		server := &inbound.Server{
+			Addr:     addr,
+			Hostname: os.Getenv("INBOUND_EMAIL_DOMAIN"),
+			MaxSize:  handlers.MaxInboundEmailSize,
+			Accept:   handlers.AcceptsInboundEmail,
+			Handle:   handlers.DeliverInboundEmail,
+		}
+		go func() {
+			if err := server.ListenAndServe(context.Background()); err != nil {
+				log.Println("Inbound SMTP listener failed:", err)
+			}
+		}()
+	}
+	go webhooks.Start(context.Background(), 5*time.Second)
 	mux := routes.SetUpRoutes()
 
 
 	mux.HandleFunc("/swagger/", httpSwagger.WrapHandler)
 
 	log.Println("Server is running on port :8080")
-	err := http.ListenAndServe(":8080", mux)
+	err := http.ListenAndServe(":8080", middleware.APIVersion(routes.Versions(), mux))
 	if err != nil {
 		log.Fatal("Server Failed: ", err)
 	}
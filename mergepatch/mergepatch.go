@@ -0,0 +1,153 @@
+// Package mergepatch applies JSON merge patches (RFC 7396) to models.
+//
+// A patch is a JSON object naming the fields to change. Fields left out
+// keep their value, null resets a field to its zero value (nil for
+// pointers, "" for strings), and an object is merged into an object field
+// with the same rules. Any other value, arrays included, replaces the
+// field.
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ErrNotObject is returned for a patch that is not a JSON object. RFC 7396
+// lets such a patch replace the whole document, which no resource allows.
+var ErrNotObject = errors.New("merge patch is not an object")
+
+// FieldError is returned for a patch naming a field that does not exist
+// or may not be changed.
+type FieldError struct {
+	Field string
+}
+
+func (e *FieldError) Error() string {
+	return "field " + e.Field + " cannot be patched"
+}
+
+// Apply applies patch to target, a pointer to a struct. Only the top-level
+// JSON fields listed in writable may appear in the patch. Values of the
+// wrong type are reported as *json.UnmarshalTypeError with Field set to
+// the JSON path of the field. target is not revalidated.
+func Apply(target any, patch []byte, writable ...string) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return err
+	}
+	if fields == nil {
+		return ErrNotObject
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	// Sorted so that the error for a bad patch does not vary.
+	slices.Sort(names)
+
+	v := reflect.ValueOf(target).Elem()
+	byName := jsonFields(v.Type())
+	for _, name := range names {
+		index, ok := byName[name]
+		if !ok || !slices.Contains(writable, name) {
+			return &FieldError{Field: name}
+		}
+		if err := mergeField(v.Field(index), fields[name]); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				typeErr.Field = strings.TrimSuffix(name+"."+typeErr.Field, ".")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeField applies the patch value raw to field.
+func mergeField(field reflect.Value, raw json.RawMessage) error {
+	raw = bytes.TrimSpace(raw)
+	if bytes.Equal(raw, []byte("null")) {
+		field.SetZero()
+		return nil
+	}
+	if raw[0] == '{' && !field.IsZero() {
+		current, err := json.Marshal(field.Interface())
+		if err != nil {
+			return err
+		}
+		if raw, err = mergeDocuments(current, raw); err != nil {
+			return err
+		}
+	}
+	value := reflect.New(field.Type())
+	if err := json.Unmarshal(raw, value.Interface()); err != nil {
+		return err
+	}
+	field.Set(value.Elem())
+	return nil
+}
+
+// mergeDocuments returns the JSON document target patched with patch.
+func mergeDocuments(target, patch []byte) ([]byte, error) {
+	var t, p any
+	if err := unmarshalNumbers(target, &t); err != nil {
+		return nil, err
+	}
+	if err := unmarshalNumbers(patch, &p); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeValues(t, p))
+}
+
+// mergeValues is the MergePatch function of RFC 7396.
+func mergeValues(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergeValues(t[name], value)
+		}
+	}
+	return t
+}
+
+// unmarshalNumbers decodes data keeping numbers as json.Number, so that
+// large integers survive the round trip.
+func unmarshalNumbers(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// jsonFields maps the JSON names of the fields of a struct type to their
+// index.
+func jsonFields(t reflect.Type) map[string]int {
+	fields := map[string]int{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
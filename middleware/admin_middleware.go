@@ -11,7 +11,7 @@ func AdminMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		role := r.Context().Value("role").(string)
 		if role != "admin" {
-			utils.SendError(w, http.StatusForbidden, "Forbidden: Admins only")
+			utils.SendError(w, r, http.StatusForbidden, utils.CodeAdminOnly)
 			return
 		}
 		next.ServeHTTP(w, r)
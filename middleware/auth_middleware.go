@@ -2,8 +2,11 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"io/fs"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/Anwarjondev/task-management-api/utils"
@@ -15,8 +18,10 @@ import (
 var JwtKey []byte
 
 func init() {
+	// Without a .env file the variables come from the environment, as
+	// in containers and tests.
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		panic("Error with loading .env file")
 	}
 	JwtKey = []byte(os.Getenv("JWT_KEY")) 
@@ -28,28 +33,64 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// StreamAudience is the audience of the short-lived tokens that open an
+// event stream. They are only accepted by QueryTokenAuth.
+const StreamAudience = "stream"
+
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			utils.SendError(w, http.StatusUnauthorized, "Unauthorized: Missing token")
+			utils.SendError(w, r, http.StatusUnauthorized, utils.CodeTokenMissing)
 			return
 		}
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-			return JwtKey, nil
-		}) 
-		if err != nil || !token.Valid {
-			utils.SendError(w, http.StatusUnauthorized, "Unathorized: "+err.Error())
+		authenticate(w, r, next, strings.TrimPrefix(authHeader, "Bearer "), "")
+	})
+}
+
+// QueryTokenAuth authenticates like AuthMiddleware, but a request without
+// an Authorization header may pass a token issued for audience in the
+// token query parameter, as a browser's EventSource cannot set headers.
+// Such tokens are short-lived and accepted nowhere else.
+func QueryTokenAuth(audience string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			AuthMiddleware(next).ServeHTTP(w, r)
 			return
 		}
-		if claims.UserID == "" {
-			utils.SendError(w, http.StatusUnauthorized, "Unathorized: Missing user id")
+		tokenString := r.URL.Query().Get("token")
+		if tokenString == "" {
+			utils.SendError(w, r, http.StatusUnauthorized, utils.CodeTokenMissing)
 			return
 		}
-		ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
-		ctx = context.WithValue(ctx, "role", claims.Role)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		authenticate(w, r, next, tokenString, audience)
+	})
+}
+
+// authenticate serves next as the user of tokenString. A token with an
+// audience is only valid where that audience is expected.
+func authenticate(w http.ResponseWriter, r *http.Request, next http.Handler, tokenString, audience string) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return JwtKey, nil
 	})
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		utils.SendError(w, r, http.StatusUnauthorized, utils.CodeTokenExpired)
+		return
+	}
+	if err != nil || !token.Valid {
+		utils.SendError(w, r, http.StatusUnauthorized, utils.CodeTokenInvalid)
+		return
+	}
+	if claims.UserID == "" {
+		utils.SendError(w, r, http.StatusUnauthorized, utils.CodeTokenInvalid)
+		return
+	}
+	if audience == "" && len(claims.Audience) > 0 || audience != "" && !slices.Contains(claims.Audience, audience) {
+		utils.SendError(w, r, http.StatusUnauthorized, utils.CodeTokenInvalid)
+		return
+	}
+	ctx := context.WithValue(r.Context(), "user_id", claims.UserID)
+	ctx = context.WithValue(ctx, "role", claims.Role)
+	next.ServeHTTP(w, r.WithContext(ctx))
 }
\ No newline at end of file
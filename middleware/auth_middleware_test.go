@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signedToken(t *testing.T, audience ...string) string {
+	t.Helper()
+	claims := &Claims{
+		UserID: "u1",
+		Role:   "user",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JwtKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func serve(handler http.Handler, target, authorization string) (int, string) {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", "Bearer "+authorization)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code, w.Body.String()
+}
+
+func TestStreamTokens(t *testing.T) {
+	JwtKey = []byte("test-key")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Context().Value("user_id").(string)))
+	})
+	access := signedToken(t)
+	streamToken := signedToken(t, StreamAudience)
+
+	tests := []struct {
+		name          string
+		handler       http.Handler
+		target        string
+		authorization string
+		want          int
+	}{
+		{"stream token in query", QueryTokenAuth(StreamAudience, ok), "/v1/stream?token=" + streamToken, "", http.StatusOK},
+		{"access token in header", QueryTokenAuth(StreamAudience, ok), "/v1/stream", access, http.StatusOK},
+		{"access token in query", QueryTokenAuth(StreamAudience, ok), "/v1/stream?token=" + access, "", http.StatusUnauthorized},
+		{"no token", QueryTokenAuth(StreamAudience, ok), "/v1/stream", "", http.StatusUnauthorized},
+		{"stream token elsewhere", AuthMiddleware(ok), "/v1/projects", streamToken, http.StatusUnauthorized},
+		{"query token elsewhere", AuthMiddleware(ok), "/v1/projects?token=" + streamToken, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		code, body := serve(tt.handler, tt.target, tt.authorization)
+		if code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+		if code == http.StatusOK && body != "u1" {
+			t.Errorf("%s: served as %q, want u1", tt.name, body)
+		}
+	}
+}
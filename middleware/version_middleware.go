@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/utils"
+)
+
+// VersionHeader carries the API version in requests and responses.
+const VersionHeader = "API-Version"
+
+var versionPattern = regexp.MustCompile(`^v[0-9]+$`)
+
+// vendorMediaType matches Accept values like application/vnd.taskapi.v2+json.
+var vendorMediaType = regexp.MustCompile(`^application/vnd\.taskapi\.(v[0-9]+)\+json$`)
+
+// APIVersion negotiates the API version. A version in the path (/v1/...)
+// always wins; otherwise the API-Version header or a vendor media type in
+// Accept routes an unversioned path to that version, e.g. GET /projects
+// with "API-Version: 2" is served as GET /v2/projects. Requests without any
+// version are passed through unchanged so deprecated aliases keep working.
+func APIVersion(supported []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested := requestedVersion(r)
+		if requested != "" && !slices.Contains(supported, requested) {
+			utils.SendError(w, r, http.StatusBadRequest, utils.CodeUnsupportedVersion, requested)
+			return
+		}
+		if version := pathVersion(r.URL.Path); version != "" {
+			if requested != "" && requested != version {
+				utils.SendError(w, r, http.StatusBadRequest, "api.version_mismatch")
+				return
+			}
+			w.Header().Set(VersionHeader, version)
+		} else if requested != "" {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/" + requested + r.URL.Path
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = "/" + requested + r.URL.RawPath
+			}
+			w.Header().Set(VersionHeader, requested)
+		}
+		w.Header().Add("Vary", VersionHeader)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func pathVersion(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if versionPattern.MatchString(segment) {
+		return segment
+	}
+	return ""
+}
+
+// requestedVersion reads "API-Version: 2" (or "v2") and falls back to
+// "Accept: application/vnd.taskapi.v2+json".
+func requestedVersion(r *http.Request) string {
+	if value := strings.ToLower(strings.TrimSpace(r.Header.Get(VersionHeader))); value != "" {
+		if !strings.HasPrefix(value, "v") {
+			value = "v" + value
+		}
+		return value
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if m := vendorMediaType.FindStringSubmatch(mediaType); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// DeprecationPolicy describes when a route was deprecated and when it goes away.
+type DeprecationPolicy struct {
+	Deprecated time.Time
+	Sunset     time.Time
+	// Successor optionally links to what clients should migrate to.
+	Successor string
+}
+
+// Deprecated marks every response of next with the Deprecation (RFC 9745)
+// and Sunset (RFC 8594) headers of the policy.
+func Deprecated(policy DeprecationPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(policy.Deprecated.Unix(), 10))
+		if !policy.Sunset.IsZero() {
+			w.Header().Set("Sunset", policy.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if policy.Successor != "" {
+			w.Header().Add("Link", "<"+policy.Successor+`>; rel="successor-version"`)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Legacy marks requests to next with utils.WithLegacy, so handlers keep the
+// behaviour the unversioned aliases had before /v1.
+func Legacy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(utils.WithLegacy(r.Context())))
+	})
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/utils"
+)
+
+func TestDeprecatedOnlyAddsHeaders(t *testing.T) {
+	policy := DeprecationPolicy{
+		Deprecated: time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+		Sunset:     time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC),
+		Successor:  "/v2/",
+	}
+	var legacy bool
+	handler := Deprecated(policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		legacy = utils.IsLegacy(r)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/projects", nil))
+	if legacy {
+		t.Error("Deprecated marked the request as legacy")
+	}
+	if got := w.Header().Get("Deprecation"); got != "@1792368000" {
+		t.Errorf("Deprecation = %q", got)
+	}
+	if got := w.Header().Get("Sunset"); got != "Thu, 01 Apr 2027 00:00:00 GMT" {
+		t.Errorf("Sunset = %q", got)
+	}
+	if got := w.Header().Get("Link"); got != `</v2/>; rel="successor-version"` {
+		t.Errorf("Link = %q", got)
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	var legacy bool
+	handler := Legacy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		legacy = utils.IsLegacy(r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/gettask", nil))
+	if !legacy {
+		t.Error("Legacy did not mark the request")
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Attachment struct {
+	ID          string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TaskID      string    `gorm:"type:uuid;index" json:"task_id"`
+	FileName    string    `gorm:"type:varchar(255)" json:"file_name"`
+	ContentType string    `gorm:"type:varchar(255)" json:"content_type"`
+	Size        int64     `json:"size"`
+	StorageKey  string    `gorm:"type:varchar(512)" json:"-"`
+	UploaderID  string    `gorm:"type:uuid" json:"uploader_id"`
+	Version     int       `gorm:"not null;default:1" json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (a *Attachment) BeforeCreate(tx *gorm.DB) error {
+	a.ID = uuid.New().String()
+	a.Version = 1
+	return nil
+}
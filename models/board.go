@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BoardColumn holds per-project settings of a board column. Columns are the
+// task statuses; a WIPLimit of 0 means the column has no limit.
+type BoardColumn struct {
+	ID        string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID string `gorm:"type:uuid;uniqueIndex:idx_board_column_project_status" json:"project_id"`
+	Status    string `gorm:"type:varchar(50);uniqueIndex:idx_board_column_project_status" json:"status"`
+	WIPLimit  int    `gorm:"not null;default:0" json:"wip_limit" validate:"min=0"`
+	Version   int    `gorm:"not null;default:1" json:"version"`
+}
+
+func (c *BoardColumn) BeforeCreate(tx *gorm.DB) error {
+	c.ID = uuid.New().String()
+	c.Version = 1
+	return nil
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Comment is a message a user left on a task. EmailFrom is the sender of
+// a comment received by email.
+type Comment struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TaskID    string    `gorm:"type:uuid;index" json:"task_id"`
+	AuthorID  string    `gorm:"type:uuid;index" json:"author_id"`
+	Body      string    `gorm:"type:text" json:"body" validate:"required,max=5000"`
+	EmailFrom string    `gorm:"type:varchar(255)" json:"email_from,omitempty"`
+	Version   int       `gorm:"not null;default:1" json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *Comment) BeforeCreate(tx *gorm.DB) error {
+	c.ID = uuid.New().String()
+	c.Version = 1
+	return nil
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// EmailSettings says how a user is emailed about notifications:
+// "immediate" sends one email per notification, "daily" a digest once a
+// day and "off" nothing. Users without settings get immediate emails.
+type EmailSettings struct {
+	UserID       string     `gorm:"primaryKey;type:uuid" json:"-"`
+	Delivery     string     `gorm:"type:varchar(20);not null;default:immediate" json:"delivery" validate:"required,oneof=immediate daily off"`
+	LastDigestAt *time.Time `gorm:"index" json:"last_digest_at"`
+}
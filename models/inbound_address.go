@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InboundAddress is the email address of a project that turns incoming
+// mail into tasks. Mail is filed under CreatorID, the user who set the
+// address up, whoever the sender claims to be.
+type InboundAddress struct {
+	ProjectID string    `gorm:"primaryKey;type:uuid" json:"project_id"`
+	Token     string    `gorm:"type:varchar(64);uniqueIndex" json:"-"`
+	Address   string    `gorm:"-" json:"address"`
+	CreatorID string    `gorm:"type:uuid" json:"creator_id"`
+	Version   int       `gorm:"not null;default:1" json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (a *InboundAddress) BeforeCreate(tx *gorm.DB) error {
+	a.Version = 1
+	return nil
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Label categorizes tasks (bug, feature, tech-debt, ...). Names are unique
+// within a project.
+type Label struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID string    `gorm:"type:uuid;uniqueIndex:idx_label_project_name" json:"project_id"`
+	Name      string    `gorm:"type:varchar(50);uniqueIndex:idx_label_project_name" json:"name" validate:"required,min=1,max=50"`
+	Color     string    `gorm:"type:varchar(7)" json:"color" validate:"required,hexcolor"`
+	Version   int       `gorm:"not null;default:1" json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (l *Label) BeforeCreate(tx *gorm.DB) error {
+	l.ID = uuid.New().String()
+	l.Version = 1
+	return nil
+}
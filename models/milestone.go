@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Milestone groups the tasks of a project that belong to one release.
+type Milestone struct {
+	ID          string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID   string     `gorm:"type:uuid;index" json:"project_id"`
+	Title       string     `gorm:"type:varchar(255)" json:"title" validate:"required,min=1,max=100"`
+	Description string     `gorm:"type:text" json:"description" validate:"max=500"`
+	TargetDate  *time.Time `json:"target_date"`
+	State       string     `gorm:"type:varchar(20);default:open" json:"state"`
+	ClosedAt    *time.Time `json:"closed_at"`
+	Version     int        `gorm:"not null;default:1" json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (m *Milestone) BeforeCreate(tx *gorm.DB) error {
+	m.ID = uuid.New().String()
+	m.Version = 1
+	return nil
+}
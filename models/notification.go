@@ -0,0 +1,44 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Notification tells a user about an event that concerns them. EventID is
+// the outbox event it was made from; a user gets at most one notification
+// per event. Title names the task, subtask, project, milestone or sprint
+// for display and Data is the event's data. Watching is set when the user
+// is only notified because they watch the task or project.
+type Notification struct {
+	ID        string          `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID    string          `gorm:"type:uuid;index;uniqueIndex:idx_notification_event" json:"user_id"`
+	EventID   string          `gorm:"type:uuid;uniqueIndex:idx_notification_event" json:"event_id"`
+	Type      string          `gorm:"type:varchar(100)" json:"type"`
+	ProjectID *string         `gorm:"type:uuid" json:"project_id"`
+	TaskID    *string         `gorm:"type:uuid" json:"task_id"`
+	ActorID   *string         `gorm:"type:uuid" json:"actor_id"`
+	Title     string          `gorm:"type:varchar(255)" json:"title"`
+	Watching  bool            `gorm:"not null;default:false" json:"watching"`
+	Data      json.RawMessage `gorm:"type:text;serializer:json" json:"data" swaggertype:"object"`
+	ReadAt    *time.Time      `gorm:"index" json:"read_at"`
+	EmailedAt *time.Time      `gorm:"index" json:"-"`
+	CreatedAt time.Time       `gorm:"not null;default:now();index" json:"created_at"`
+}
+
+func (n *Notification) BeforeCreate(tx *gorm.DB) error {
+	n.ID = uuid.New().String()
+	n.CreatedAt = time.Now()
+	return nil
+}
+
+// NotificationPreference turns notifications of one type on or off for a
+// user. Types without a preference are on.
+type NotificationPreference struct {
+	UserID  string `gorm:"primaryKey;type:uuid" json:"-"`
+	Type    string `gorm:"primaryKey;type:varchar(100)" json:"type" validate:"oneof=task.assigned subtask.assigned project.member_added comment.created task.status_changed milestone.closed sprint.started sprint.closed"`
+	Enabled bool   `gorm:"not null" json:"enabled"`
+}
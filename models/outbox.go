@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OutboxEvent is a domain event written in the transaction of the change
+// it describes. Seq orders events; Key names the aggregate the event
+// belongs to, e.g. "task:<id>", and KeySeq numbers the events of one key
+// in commit order. Events of one key are published in KeySeq order.
+// PublishedAt is set once every sink accepted the event, together with
+// PublishSeq, which numbers events in the order they were published.
+type OutboxEvent struct {
+	ID            string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Seq           int64      `gorm:"autoIncrement;uniqueIndex" json:"seq"`
+	Type          string     `gorm:"type:varchar(100)" json:"type"`
+	Key           string     `gorm:"type:varchar(100);index;index:idx_outbox_event_key_seq,priority:1" json:"key"`
+	KeySeq        int64      `gorm:"not null;default:0;index:idx_outbox_event_key_seq,priority:2" json:"key_seq"`
+	ProjectID     *string    `gorm:"type:uuid;index" json:"project_id"`
+	ActorID       *string    `gorm:"type:uuid" json:"actor_id"`
+	Payload       string     `gorm:"type:text" json:"payload"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	LastError     string     `gorm:"type:text" json:"last_error"`
+	NextAttemptAt time.Time  `gorm:"not null;default:now();index" json:"next_attempt_at"`
+	PublishedAt   *time.Time `gorm:"index" json:"published_at"`
+	PublishSeq    *int64     `gorm:"uniqueIndex" json:"publish_seq"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (e *OutboxEvent) BeforeCreate(tx *gorm.DB) error {
+	e.ID = uuid.New().String()
+	return nil
+}
+
+// OutboxKey counts the events recorded for a key. Recording an event
+// locks its key's row until the transaction ends, so transactions writing
+// events of one key commit one after another and KeySeq follows commit
+// order, which the global Seq does not.
+type OutboxKey struct {
+	Key string `gorm:"primaryKey;type:varchar(100)"`
+	Seq int64  `gorm:"not null;default:0"`
+}
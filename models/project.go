@@ -1,21 +1,27 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type Project struct {
-	ID          string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	Name        string `gorm:"type:varchar(255)" json:"name" validate:"required,min=3,max=10"`
-	Description string `gorm:"type:text" json:"description" validate:"max=500"`
-	OwnerID     string `gorm:"type:uuid" json:"owner_id"`
-	Owner       User   `gorm:"foreignKey:OwnerID" json:"owner"`
-	Members     []User `gorm:"many2many:project_members;" json:"members"`
-	Tasks       []Task `gorm:"foreignKey:ProjectID" json:"tasks"`
+	ID          string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name        string    `gorm:"type:varchar(255)" json:"name" validate:"required,min=3,max=10"`
+	Description string    `gorm:"type:text" json:"description" validate:"max=500"`
+	OwnerID     string    `gorm:"type:uuid" json:"owner_id"`
+	Owner       User      `gorm:"foreignKey:OwnerID" json:"owner" validate:"-"`
+	Members     []User    `gorm:"many2many:project_members;" json:"members"`
+	Tasks       []Task    `gorm:"foreignKey:ProjectID" json:"tasks"`
+	Version     int       `gorm:"not null;default:1" json:"version"`
+	CreatedAt   time.Time `gorm:"not null;default:now();index" json:"created_at"`
 }
 
 func (p *Project) BeforeCreate(tx *gorm.DB) error {
 	p.ID = uuid.New().String()
+	p.Version = 1
+	p.CreatedAt = time.Now()
 	return nil
 }
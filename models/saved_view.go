@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SavedView is a named task filter with a sort and the columns a client
+// shows. A view belongs to its owner; with Shared set every member of
+// ProjectID can see and run it. Valid and InvalidReferences are filled in
+// when the view is read, as labels and users named by the filter may have
+// been deleted since it was saved.
+type SavedView struct {
+	ID                string          `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name              string          `gorm:"type:varchar(100)" json:"name" validate:"required,max=100"`
+	OwnerID           string          `gorm:"type:uuid;index" json:"owner_id"`
+	ProjectID         *string         `gorm:"type:uuid;index" json:"project_id" validate:"required_if=Shared true"`
+	Shared            bool            `gorm:"not null;default:false" json:"shared"`
+	Filter            string          `gorm:"type:text" json:"filter" validate:"max=2000"`
+	Sort              string          `gorm:"type:varchar(20)" json:"sort" validate:"omitempty,oneof=created -created due -due title -title status -status estimate -estimate"`
+	Columns           []string        `gorm:"type:text;serializer:json" json:"columns" validate:"dive,oneof=title description status assignee creator project due estimate logged remaining labels milestone sprint created"`
+	Valid             bool            `gorm:"-" json:"valid"`
+	InvalidReferences []ViewReference `gorm:"-" json:"invalid_references,omitempty"`
+	Version           int             `gorm:"not null;default:1" json:"version"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+// ViewReference is a value in a view's filter that names a row which no
+// longer exists, e.g. Field "label" and Value "bug".
+type ViewReference struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+func (v *SavedView) BeforeCreate(tx *gorm.DB) error {
+	v.ID = uuid.New().String()
+	v.Version = 1
+	return nil
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Sprint is a time-boxed iteration of a project. The minute totals are
+// snapshots taken when the sprint starts (committed) and closes (completed)
+// and feed the project's velocity history.
+type Sprint struct {
+	ID               string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID        string     `gorm:"type:uuid;index" json:"project_id"`
+	Name             string     `gorm:"type:varchar(255)" json:"name" validate:"required,min=1,max=100"`
+	Goal             string     `gorm:"type:text" json:"goal" validate:"max=500"`
+	StartDate        time.Time  `json:"start_date" validate:"required"`
+	EndDate          time.Time  `json:"end_date" validate:"required,gtfield=StartDate"`
+	State            string     `gorm:"type:varchar(20);default:planned" json:"state"`
+	CommittedMinutes int        `gorm:"not null;default:0" json:"committed_minutes"`
+	CompletedMinutes int        `gorm:"not null;default:0" json:"completed_minutes"`
+	CompletedTasks   int        `gorm:"not null;default:0" json:"completed_tasks"`
+	ClosedAt         *time.Time `json:"closed_at"`
+	Version          int        `gorm:"not null;default:1" json:"version"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+func (s *Sprint) BeforeCreate(tx *gorm.DB) error {
+	s.ID = uuid.New().String()
+	s.Version = 1
+	return nil
+}
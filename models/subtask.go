@@ -1,24 +1,43 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type Subtask struct {
-	ID         string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	Title      string `gorm:"type:varchar(255)" json:"title" validate:"required,min=3, max=100"`
-	Status     string `gorm:"type:varchar(50)" json:"status" validate:"required, oneof=pending, in_progress completed"`
-	TaskID     string `gorm:"type uuid" json:"task_id" validate:"required"`
-	Task       Task   `gorm:"foreignKey:TaskID" json:"task"`
-	AssigneeID string `gorm:"type:uuid" json:"assignee_id"`
-	Assignee   User   `gorm:"foreignKey:AssigneeID" json:"assignee"`
-	CreatorID  string `gorm:"type:uuid" json:"creator_id"`
-	Creator    User   `gorm:"foreignKey:CreatorID" json:"creator"`
+	ID         string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Title      string    `gorm:"type:varchar(255)" json:"title" validate:"required,min=3,max=100"`
+	Status     string    `gorm:"type:varchar(50)" json:"status" validate:"required,oneof=pending in_progress completed"`
+	TaskID     string    `gorm:"type uuid" json:"task_id" validate:"required"`
+	Task       Task      `gorm:"foreignKey:TaskID" json:"task" validate:"-"`
+	AssigneeID *string   `gorm:"type:uuid" json:"assignee_id"`
+	Assignee   User      `gorm:"foreignKey:AssigneeID" json:"assignee" validate:"-"`
+	CreatorID  string    `gorm:"type:uuid" json:"creator_id"`
+	Creator    User      `gorm:"foreignKey:CreatorID" json:"creator" validate:"-"`
+	Version    int       `gorm:"not null;default:1" json:"version"`
+	CreatedAt  time.Time `gorm:"not null;default:now();index" json:"created_at"`
 }
 
-
 func (s *Subtask) BeforeCreate(tx *gorm.DB) error {
 	s.ID = uuid.New().String()
+	s.Version = 1
+	s.CreatedAt = time.Now()
 	return nil
 }
+
+// AssignedTo reports whether userID is the assignee of the subtask.
+func (s *Subtask) AssignedTo(userID string) bool {
+	return s.AssigneeID != nil && *s.AssigneeID == userID
+}
+
+// AssigneeUserID returns the id of the assignee, "" for an unassigned
+// subtask.
+func (s *Subtask) AssigneeUserID() string {
+	if s.AssigneeID == nil {
+		return ""
+	}
+	return *s.AssigneeID
+}
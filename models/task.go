@@ -1,25 +1,64 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type Task struct {
 	ID          string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	Title       string    `gorm:"type:varchar(255)" json:"title" validate:"required, min=3, max=100"`
+	Title       string    `gorm:"type:varchar(255)" json:"title" validate:"required,min=3,max=100"`
 	Description string    `gorm:"type:text" json:"description" validate:"max=500"`
 	Status      string    `gorm:"type:varchar(50)" json:"status" validate:"required,oneof=pending in_progress completed"`
 	ProjectID   string    `gorm:"type:uuid" json:"project_id" validate:"required"`
-	Project     Project   `gorm:"foreignKey:ProjectID" json:"project"`
-	AssigneeID  string    `gorm:"type:uuid" json:"assignee_id"`
-	Assignee    User      `gorm:"foreignKey:AssigneeID" json:"assignee"`
+	Project     Project   `gorm:"foreignKey:ProjectID" json:"project" validate:"-"`
+	AssigneeID  *string   `gorm:"type:uuid" json:"assignee_id"`
+	Assignee    User      `gorm:"foreignKey:AssigneeID" json:"assignee" validate:"-"`
 	CreatorID   string    `gorm:"type:uuid" json:"creator_id"`
-	Creator     User      `gorm:"foreignKey:CreatorID" json:"creator"`
+	Creator     User      `gorm:"foreignKey:CreatorID" json:"creator" validate:"-"`
 	Subtasks    []Subtask `gorm:"foreignKey:TaskID" json:"subtasks"`
+	Labels      []Label   `gorm:"many2many:task_labels;" json:"labels"`
+
+	EstimateMinutes  int `gorm:"not null;default:0" json:"estimate_minutes" validate:"min=0"`
+	LoggedMinutes    int `gorm:"-" json:"logged_minutes"`
+	RemainingMinutes int `gorm:"-" json:"remaining_minutes"`
+
+	DueDate    *time.Time `gorm:"uniqueIndex:idx_task_template_occurrence" json:"due_date"`
+	TemplateID *string    `gorm:"type:uuid;uniqueIndex:idx_task_template_occurrence" json:"template_id"`
+
+	MilestoneID *string `gorm:"type:uuid;index" json:"milestone_id"`
+	SprintID    *string `gorm:"type:uuid;index" json:"sprint_id"`
+
+	// Rank orders tasks inside their board column, see packages lexorank
+	// and board. Compare ranks with byte order (collate "C").
+	Rank string `gorm:"type:varchar(255);index" json:"rank"`
+
+	// EmailFrom is the sender of a task created from inbound email.
+	EmailFrom string `gorm:"type:varchar(255)" json:"email_from,omitempty"`
+
+	// Version is kept by Postgres, see db.AutoMigrate; it is the ETag.
+	Version   int       `gorm:"not null;default:1" json:"version"`
+	CreatedAt time.Time `gorm:"not null;default:now();index" json:"created_at"`
 }
 
 func (t *Task) BeforeCreate(tx *gorm.DB) error {
 	t.ID = uuid.New().String()
+	t.Version = 1
+	t.CreatedAt = time.Now()
 	return nil
 }
+
+// AssignedTo reports whether userID is the assignee of the task.
+func (t *Task) AssignedTo(userID string) bool {
+	return t.AssigneeID != nil && *t.AssigneeID == userID
+}
+
+// AssigneeUserID returns the id of the assignee, "" for an unassigned task.
+func (t *Task) AssigneeUserID() string {
+	if t.AssigneeID == nil {
+		return ""
+	}
+	return *t.AssigneeID
+}
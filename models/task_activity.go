@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TaskActivity is one entry of the history of a task. Action is the
+// event type, e.g. task.updated or attachment.created; Changes lists the
+// fields it changed and Subject names the attachment or comment it is
+// about. ActorID is empty for changes made by the system.
+type TaskActivity struct {
+	ID        string        `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TaskID    string        `gorm:"type:uuid;index" json:"task_id"`
+	ActorID   *string       `gorm:"type:uuid" json:"actor_id"`
+	Action    string        `gorm:"type:varchar(100)" json:"action"`
+	Subject   string        `gorm:"type:varchar(255)" json:"subject,omitempty"`
+	Changes   []FieldChange `gorm:"type:text;serializer:json" json:"changes,omitempty"`
+	CreatedAt time.Time     `gorm:"not null;default:now();index" json:"created_at"`
+}
+
+// FieldChange is the change of one field of a task, by its JSON name.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old" swaggertype:"object"`
+	New   any    `json:"new" swaggertype:"object"`
+}
+
+func (a *TaskActivity) BeforeCreate(tx *gorm.DB) error {
+	a.ID = uuid.New().String()
+	a.CreatedAt = time.Now()
+	return nil
+}
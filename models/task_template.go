@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TaskTemplate describes a recurring task. The scheduler creates a Task from
+// it whenever NextRunAt arrives (Trigger "schedule") or once the previously
+// generated task is completed or deleted (Trigger "completion").
+type TaskTemplate struct {
+	ID              string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID       string     `gorm:"type:uuid;index" json:"project_id"`
+	Title           string     `gorm:"type:varchar(255)" json:"title" validate:"required,min=3,max=100"`
+	Description     string     `gorm:"type:text" json:"description" validate:"max=500"`
+	AssigneeID      *string    `gorm:"type:uuid" json:"assignee_id"`
+	CreatorID       string     `gorm:"type:uuid" json:"creator_id"`
+	EstimateMinutes int        `gorm:"not null;default:0" json:"estimate_minutes" validate:"min=0"`
+	RRule           string     `gorm:"type:varchar(255)" json:"rrule" validate:"required"`
+	StartAt         time.Time  `json:"start_at" validate:"required"`
+	Trigger         string     `gorm:"type:varchar(20);default:schedule" json:"trigger" validate:"omitempty,oneof=schedule completion"`
+	Active          bool       `gorm:"not null;default:true" json:"active"`
+	NextRunAt       *time.Time `gorm:"index" json:"next_run_at"`
+	OccurrenceCount int        `gorm:"not null;default:0" json:"occurrence_count"`
+	LastTaskID      *string    `gorm:"type:uuid" json:"last_task_id"`
+	Version         int        `gorm:"not null;default:1" json:"version"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+func (t *TaskTemplate) BeforeCreate(tx *gorm.DB) error {
+	t.ID = uuid.New().String()
+	t.Version = 1
+	return nil
+}
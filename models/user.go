@@ -10,10 +10,13 @@ type User struct {
 	Username string    `gorm:"type:varchar(255);unique" json:"username" validate:"required,min=3,max=50"`
 	Password string    `gorm:"type:varchar(255)" json:"password" validate:"required,min=6"`
 	Role     string    `gorm:"type:varchar(50)" json:"role" validate:"required,oneof=admin manager team_member"`
+	Email    string    `gorm:"type:varchar(255)" json:"email" validate:"omitempty,email,max=255"`
+	Version  int       `gorm:"not null;default:1" json:"version"`
 	Projects []Project `gorm:"many2many:project_members;" json:"projects"`
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	u.ID = uuid.New().String()
+	u.Version = 1
 	return nil
 }
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Watch subscribes a user to the activity of a task or project. TargetType
+// is "task" or "project"; ProjectID is the project of the target. Title
+// names the target in lists and is not stored.
+type Watch struct {
+	ID         string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID     string    `gorm:"type:uuid;uniqueIndex:idx_watch_user_target" json:"user_id"`
+	TargetType string    `gorm:"type:varchar(20);uniqueIndex:idx_watch_user_target;index:idx_watch_target" json:"target_type"`
+	TargetID   string    `gorm:"type:uuid;uniqueIndex:idx_watch_user_target;index:idx_watch_target" json:"target_id"`
+	ProjectID  string    `gorm:"type:uuid;index" json:"project_id"`
+	Title      string    `gorm:"-" json:"title"`
+	CreatedAt  time.Time `gorm:"not null;default:now();index" json:"created_at"`
+}
+
+func (w *Watch) BeforeCreate(tx *gorm.DB) error {
+	w.ID = uuid.New().String()
+	w.CreatedAt = time.Now()
+	return nil
+}
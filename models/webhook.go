@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook subscribes a URL to events of a project. Secret signs every
+// delivery and is only shown when the webhook is created. A webhook whose
+// deliveries keep failing is switched off by setting Active to false and
+// DisabledAt.
+type Webhook struct {
+	ID                  string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID           string     `gorm:"type:uuid;index" json:"project_id"`
+	URL                 string     `gorm:"type:varchar(2048)" json:"url" validate:"required,http_url,max=2048"`
+	Secret              string     `gorm:"type:varchar(255)" json:"secret,omitempty" validate:"omitempty,min=16,max=255"`
+	Events              []string   `gorm:"type:text;serializer:json" json:"events" validate:"required,min=1,dive,oneof=task.created task.status_changed project.member_added subtask.completed"`
+	Active              bool       `gorm:"not null;default:true" json:"active"`
+	ConsecutiveFailures int        `gorm:"not null;default:0" json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+	CreatorID           string     `gorm:"type:uuid" json:"creator_id"`
+	Version             int        `gorm:"not null;default:1" json:"version"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+func (h *Webhook) BeforeCreate(tx *gorm.DB) error {
+	h.ID = uuid.New().String()
+	h.Version = 1
+	return nil
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook. Status
+// is "pending" until the event is delivered ("succeeded") or every attempt
+// has failed ("failed"). The response fields describe the last attempt.
+type WebhookDelivery struct {
+	ID             string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	WebhookID      string     `gorm:"type:uuid;index" json:"webhook_id"`
+	EventID        string     `gorm:"type:uuid;index" json:"event_id"`
+	Event          string     `gorm:"type:varchar(100)" json:"event"`
+	Payload        string     `gorm:"type:text" json:"payload"`
+	Status         string     `gorm:"type:varchar(20);index" json:"status"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  *time.Time `gorm:"index" json:"next_attempt_at"`
+	ResponseStatus int        `json:"response_status"`
+	ResponseBody   string     `gorm:"type:text" json:"response_body"`
+	Error          string     `gorm:"type:text" json:"error"`
+	DurationMs     int        `json:"duration_ms"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `gorm:"not null;default:now();index" json:"created_at"`
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	d.ID = uuid.New().String()
+	d.CreatedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WorkLog is time a user spent on a task. Entries logged against a subtask
+// also carry the parent TaskID so they roll up into the task totals.
+type WorkLog struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	TaskID    string    `gorm:"type:uuid;index" json:"task_id"`
+	SubtaskID *string   `gorm:"type:uuid;index" json:"subtask_id"`
+	UserID    string    `gorm:"type:uuid;index" json:"user_id"`
+	Minutes   int       `gorm:"not null" json:"minutes"`
+	Date      time.Time `gorm:"type:date" json:"date"`
+	Note      string    `gorm:"type:text" json:"note"`
+	Version   int       `gorm:"not null;default:1" json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (l *WorkLog) BeforeCreate(tx *gorm.DB) error {
+	l.ID = uuid.New().String()
+	l.Version = 1
+	return nil
+}
+
+// Timer is a running stopwatch. The unique index on UserID keeps it to one
+// running timer per user.
+type Timer struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID    string    `gorm:"type:uuid;uniqueIndex" json:"user_id"`
+	TaskID    string    `gorm:"type:uuid" json:"task_id"`
+	SubtaskID *string   `gorm:"type:uuid" json:"subtask_id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (t *Timer) BeforeCreate(tx *gorm.DB) error {
+	t.ID = uuid.New().String()
+	return nil
+}
@@ -0,0 +1,285 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/i18n"
+	"github.com/Anwarjondev/task-management-api/inbound"
+	"github.com/Anwarjondev/task-management-api/mail"
+	"github.com/Anwarjondev/task-management-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DigestInterval is the time between two digests of a user.
+	DigestInterval = 24 * time.Hour
+	// EmailWindow is how old a notification may get and still be emailed,
+	// so turning email on does not send a backlog.
+	EmailWindow = 48 * time.Hour
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+)
+
+// emailData is the data of the email templates. A single notification is
+// the only item of Items.
+type emailData struct {
+	Username       string
+	Items          []emailItem
+	UnsubscribeURL string
+}
+
+type emailItem struct {
+	Summary string
+	Title   string
+	Time    time.Time
+}
+
+// StartEmail runs SendEmails every interval until ctx is cancelled.
+func StartEmail(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := SendEmails(time.Now().UTC()); err != nil {
+			log.Println("Notification email failed:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ErrNoUnsubscribeKey is returned by SendEmails when neither
+// UNSUBSCRIBE_KEY nor JWT_KEY is set. Without a key unsubscribe links
+// could be forged, so no email is sent.
+var ErrNoUnsubscribeKey = errors.New("notifications: UNSUBSCRIBE_KEY is not set, refusing to send email")
+
+// SendEmails emails the notifications not emailed yet: one by one to users
+// who want them immediately, as a digest to users whose daily digest is
+// due. Notifications of users who turned email off or have no address are
+// marked without sending. A notification or digest is handled in its own
+// transaction holding its row lock, taken with SKIP LOCKED.
+func SendEmails(now time.Time) error {
+	if mail.Default == nil {
+		return nil
+	}
+	if len(unsubscribeKey()) == 0 {
+		return ErrNoUnsubscribeKey
+	}
+	for _, next := range []func(*gorm.DB, time.Time) error{emailNext, digestNext} {
+		for {
+			err := db.DB.Transaction(func(tx *gorm.DB) error {
+				return next(tx, now)
+			})
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func emailNext(tx *gorm.DB, now time.Time) error {
+	var n models.Notification
+	err := tx.Clauses(clause.Locking{
+		Strength: "UPDATE",
+		Table:    clause.Table{Name: "notification"},
+		Options:  "SKIP LOCKED",
+	}).
+		Joins("left join email_settings on email_settings.user_id = notification.user_id").
+		Where("notification.emailed_at is null and notification.created_at > ?", now.Add(-EmailWindow)).
+		Where("coalesce(email_settings.delivery, ?) <> ?", "immediate", "daily").
+		Order("notification.created_at").
+		First(&n).Error
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err := tx.First(&user, "id = ?", n.UserID).Error; err != nil {
+		return err
+	}
+	var settings models.EmailSettings
+	tx.Limit(1).Find(&settings, "user_id = ?", user.ID)
+	if user.Email != "" && settings.Delivery != "off" {
+		items := []emailItem{itemOf(n)}
+		subject := fmt.Sprintf("%s: %s", items[0].Summary, items[0].Title)
+		if n.TaskID != nil {
+			// Replies with the reference become comments, see package inbound.
+			subject += " " + inbound.TaskReference(*n.TaskID)
+		}
+		err := send(user, subject, "notification", items)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Model(&n).Update("emailed_at", now).Error
+}
+
+func digestNext(tx *gorm.DB, now time.Time) error {
+	var settings models.EmailSettings
+	err := tx.Clauses(clause.Locking{
+		Strength: "UPDATE",
+		Options:  "SKIP LOCKED",
+	}).
+		Where("delivery = ? and (last_digest_at is null or last_digest_at <= ?)", "daily", now.Add(-DigestInterval)).
+		Order("last_digest_at nulls first").
+		First(&settings).Error
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err := tx.First(&user, "id = ?", settings.UserID).Error; err != nil {
+		return err
+	}
+	var pending []models.Notification
+	err = tx.Where("user_id = ? and emailed_at is null and created_at > ?", user.ID, now.Add(-EmailWindow)).
+		Order("created_at").
+		Find(&pending).Error
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		if user.Email != "" {
+			items := make([]emailItem, len(pending))
+			for i, n := range pending {
+				items[i] = itemOf(n)
+			}
+			subject := i18n.T(nil, "email.digest_subject", "", strconv.Itoa(len(items)))
+			if len(items) == 1 {
+				subject = i18n.T(nil, "email.digest_subject_one", "")
+			}
+			if err := send(user, subject, "digest", items); err != nil {
+				return err
+			}
+		}
+		ids := make([]string, len(pending))
+		for i, n := range pending {
+			ids[i] = n.ID
+		}
+		err = tx.Model(&models.Notification{}).Where("id in ?", ids).Update("emailed_at", now).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Model(&settings).Update("last_digest_at", now).Error
+}
+
+// send renders the named templates for user and sends the email. A
+// permanent rejection is logged and treated as sent, so one bad address
+// does not hold up everyone else.
+func send(user models.User, subject, name string, items []emailItem) error {
+	data := emailData{Username: user.Username, Items: items, UnsubscribeURL: UnsubscribeURL(user.ID)}
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return err
+	}
+	err := mail.Default.Send(mail.Message{
+		To:      user.Email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Header: map[string]string{
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if err != nil && mail.Permanent(err) {
+		log.Printf("Email to user %s rejected: %v", user.ID, err)
+		return nil
+	}
+	return err
+}
+
+// itemOf summarizes a notification with the catalog text
+// email.<event type>, or email.watching.<event type> for a notification
+// about something the user watches. Unknown types show the event type.
+func itemOf(n models.Notification) emailItem {
+	key := "email." + n.Type
+	if n.Watching {
+		key = "email.watching." + n.Type
+	}
+	return emailItem{Summary: i18n.T(nil, key, n.Type), Title: n.Title, Time: n.CreatedAt.UTC()}
+}
+
+// UnsubscribePage is the data of the page an unsubscribe link opens.
+// Until Done it asks to confirm with a form posting to Action.
+type UnsubscribePage struct {
+	Lang    string
+	Title   string
+	Message string
+	Button  string
+	Action  string
+	Done    bool
+}
+
+// RenderUnsubscribePage writes the unsubscribe page as HTML.
+func RenderUnsubscribePage(w io.Writer, page UnsubscribePage) error {
+	return htmlTemplates.ExecuteTemplate(w, "unsubscribe.html", page)
+}
+
+// UnsubscribeURL returns the signed link that turns off email for a user.
+// Links are made with APP_URL, the public base URL of the API.
+func UnsubscribeURL(userID string) string {
+	base := strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	query := url.Values{"user": {userID}, "sig": {unsubscribeSignature(userID)}}
+	return base + "/v1/unsubscribe?" + query.Encode()
+}
+
+// VerifyUnsubscribe reports whether sig is the unsubscribe signature of
+// userID. No signature is valid while no key is configured.
+func VerifyUnsubscribe(userID, sig string) bool {
+	if len(unsubscribeKey()) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(unsubscribeSignature(userID)))
+}
+
+// unsubscribeKey is UNSUBSCRIBE_KEY, or JWT_KEY when that is not set.
+func unsubscribeKey() []byte {
+	key := os.Getenv("UNSUBSCRIBE_KEY")
+	if key == "" {
+		key = os.Getenv("JWT_KEY")
+	}
+	return []byte(key)
+}
+
+// unsubscribeSignature signs userID with unsubscribeKey.
+func unsubscribeSignature(userID string) string {
+	mac := hmac.New(sha256.New, unsubscribeKey())
+	mac.Write([]byte("unsubscribe:" + userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,256 @@
+package notifications
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/mail"
+	"github.com/Anwarjondev/task-management-api/mail/mailtest"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// captureEmail points mail.Default at an in-process SMTP server for the
+// rest of the test.
+func captureEmail(t *testing.T) *mailtest.Server {
+	t.Helper()
+	t.Setenv("UNSUBSCRIBE_KEY", "test-unsubscribe-key")
+	t.Setenv("APP_URL", "https://tasks.example.com/")
+	server := mailtest.NewServer(t)
+	previous := mail.Default
+	mail.Default = &mail.SMTPSender{Addr: server.Addr, From: "Tasks <tasks@example.com>"}
+	t.Cleanup(func() { mail.Default = previous })
+	return server
+}
+
+func parse(t *testing.T, msg mailtest.Message) mailtest.Parsed {
+	t.Helper()
+	parsed, err := msg.Parse()
+	if err != nil {
+		t.Fatalf("captured message does not parse: %v", err)
+	}
+	return parsed
+}
+
+func TestSendIsMultipartWithUnsubscribeHeaders(t *testing.T) {
+	server := captureEmail(t)
+	user := models.User{ID: "9b2f6c1e-5d7a-4c1b-8e0a-2f3d4c5b6a79", Username: "ali", Email: "ali@example.com"}
+	items := []emailItem{{Summary: "You were assigned to a task", Title: "Write <report>", Time: time.Now()}}
+
+	if err := send(user, "You were assigned to a task: Write <report>", "notification", items); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("captured %d messages, want 1", len(messages))
+	}
+	msg := parse(t, messages[0])
+
+	text, html := msg.Parts["text/plain"], msg.Parts["text/html"]
+	if !strings.Contains(text, "Hi ali") || !strings.Contains(text, "You were assigned to a task: Write <report>") {
+		t.Errorf("text part = %q", text)
+	}
+	if !strings.Contains(html, "<strong>Write &lt;report&gt;</strong>") {
+		t.Errorf("HTML part does not escape the title: %q", html)
+	}
+	link := UnsubscribeURL(user.ID)
+	if !strings.HasPrefix(link, "https://tasks.example.com/v1/unsubscribe?") {
+		t.Errorf("UnsubscribeURL = %s", link)
+	}
+	if got := msg.Header.Get("List-Unsubscribe"); got != "<"+link+">" {
+		t.Errorf("List-Unsubscribe = %q, want <%s>", got, link)
+	}
+	if got := msg.Header.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe-Post = %q", got)
+	}
+	if !strings.Contains(text, link) {
+		t.Error("text part lacks the unsubscribe link")
+	}
+}
+
+func TestSendTreatsPermanentRejectionAsSent(t *testing.T) {
+	server := captureEmail(t)
+	server.Reject = func(rcpt string) bool { return true }
+	user := models.User{ID: "9b2f6c1e-5d7a-4c1b-8e0a-2f3d4c5b6a79", Username: "ali", Email: "gone@example.com"}
+	items := []emailItem{{Summary: "s", Title: "t"}}
+
+	if err := send(user, "subject", "notification", items); err != nil {
+		t.Errorf("send to a rejected address = %v, want nil", err)
+	}
+
+	mail.Default = &mail.SMTPSender{Addr: "127.0.0.1:1", From: "tasks@example.com"}
+	if err := send(user, "subject", "notification", items); err == nil {
+		t.Error("send to an unreachable server = nil, want an error to retry")
+	}
+}
+
+func TestSendEmailsRefusesWithoutKey(t *testing.T) {
+	server := captureEmail(t)
+	t.Setenv("UNSUBSCRIBE_KEY", "")
+	t.Setenv("JWT_KEY", "")
+
+	if err := SendEmails(time.Now()); !errors.Is(err, ErrNoUnsubscribeKey) {
+		t.Errorf("SendEmails = %v, want ErrNoUnsubscribeKey", err)
+	}
+	if len(server.Messages()) != 0 {
+		t.Error("email was sent without an unsubscribe key")
+	}
+	if VerifyUnsubscribe("u1", unsubscribeSignature("u1")) {
+		t.Error("a signature made with an empty key was accepted")
+	}
+}
+
+func TestItemOfUsesCatalog(t *testing.T) {
+	tests := []struct {
+		n    models.Notification
+		want string
+	}{
+		{models.Notification{Type: "task.assigned"}, "You were assigned to a task"},
+		{models.Notification{Type: "task.assigned", Watching: true}, "A task you watch was reassigned"},
+		{models.Notification{Type: "sprint.closed", Watching: true}, "A sprint was closed in a project you watch"},
+		{models.Notification{Type: "something.new"}, "something.new"},
+	}
+	for _, tt := range tests {
+		if got := itemOf(tt.n).Summary; got != tt.want {
+			t.Errorf("itemOf(%s, watching=%v) = %q, want %q", tt.n.Type, tt.n.Watching, got, tt.want)
+		}
+	}
+}
+
+// useTestDatabase connects db.DB to TEST_DATABASE_DSN, a Postgres database
+// the test may fill and empty, and skips the test when it is not set.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = conn
+	t.Cleanup(func() { db.DB = previous })
+	conn.Exec(`create extension if not exists "uuid-ossp"`)
+	db.AutoMigrate()
+	for _, table := range []string{"notification", "email_settings"} {
+		conn.Exec("delete from " + table)
+	}
+}
+
+func createUser(t *testing.T, email, delivery string) models.User {
+	t.Helper()
+	user := models.User{Username: "mail-" + email + "-" + time.Now().Format("150405.000000"), Role: "team_member", Email: email}
+	if err := db.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Delete(&user) })
+	if delivery != "" {
+		settings := models.EmailSettings{UserID: user.ID, Delivery: delivery}
+		if err := db.DB.Create(&settings).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+func notify(t *testing.T, user models.User, title string) models.Notification {
+	t.Helper()
+	n := models.Notification{UserID: user.ID, EventID: uuid.New().String(), Type: "task.assigned", Title: title}
+	if err := db.DB.Create(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func emailed(t *testing.T, n models.Notification) bool {
+	t.Helper()
+	var stored models.Notification
+	if err := db.DB.First(&stored, "id = ?", n.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return stored.EmailedAt != nil
+}
+
+func TestSendEmailsImmediateAndDigest(t *testing.T) {
+	useTestDatabase(t)
+	server := captureEmail(t)
+	now := time.Now().UTC()
+
+	immediate := createUser(t, "now@example.com", "")
+	daily := createUser(t, "daily@example.com", "daily")
+	single := notify(t, immediate, "Fix login")
+	first := notify(t, daily, "Plan sprint")
+	second := notify(t, daily, "Review budget")
+
+	if err := SendEmails(now); err != nil {
+		t.Fatalf("SendEmails: %v", err)
+	}
+	byRecipient := map[string][]mailtest.Parsed{}
+	for _, msg := range server.Messages() {
+		byRecipient[msg.To[0]] = append(byRecipient[msg.To[0]], parse(t, msg))
+	}
+	if got := byRecipient["now@example.com"]; len(got) != 1 || !strings.HasPrefix(got[0].Subject, "You were assigned to a task: Fix login") {
+		t.Errorf("immediate user got %d messages %v", len(got), got)
+	}
+	digest := byRecipient["daily@example.com"]
+	if len(digest) != 1 {
+		t.Fatalf("daily user got %d messages, want one digest", len(digest))
+	}
+	if digest[0].Subject != "Your daily summary: 2 updates" {
+		t.Errorf("digest subject = %q", digest[0].Subject)
+	}
+	for _, title := range []string{"Plan sprint", "Review budget"} {
+		if !strings.Contains(digest[0].Parts["text/plain"], title) {
+			t.Errorf("digest lacks %q", title)
+		}
+	}
+	for _, n := range []models.Notification{single, first, second} {
+		if !emailed(t, n) {
+			t.Errorf("notification %q not marked emailed", n.Title)
+		}
+	}
+
+	// The next digest is a day away; a new notification waits for it.
+	later := notify(t, daily, "Ship release")
+	if err := SendEmails(now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Messages()) != 2 || emailed(t, later) {
+		t.Errorf("a digest was sent before DigestInterval passed")
+	}
+	if err := SendEmails(now.Add(DigestInterval)); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.Messages()) != 3 || !emailed(t, later) {
+		t.Errorf("the next digest was not sent after DigestInterval")
+	}
+}
+
+func TestSendEmailsMarksPermanentRejectionSent(t *testing.T) {
+	useTestDatabase(t)
+	server := captureEmail(t)
+	server.Reject = func(rcpt string) bool { return rcpt == "gone@example.com" }
+
+	gone := createUser(t, "gone@example.com", "")
+	n := notify(t, gone, "Fix login")
+	if err := SendEmails(time.Now().UTC()); err != nil {
+		t.Fatalf("SendEmails: %v", err)
+	}
+	if len(server.Messages()) != 0 {
+		t.Errorf("captured %d messages for a rejected address", len(server.Messages()))
+	}
+	if !emailed(t, n) {
+		t.Error("permanently rejected notification is not marked emailed, it would be retried forever")
+	}
+}
@@ -0,0 +1,174 @@
+// Package notifications turns domain events into in-app notifications.
+//
+// Publish, registered as a sink of the events outbox, notifies
+//
+//	task.assigned, subtask.assigned: the new assignee
+//	project.member_added:            the added user
+//	comment.created:                 the creator and assignee of the task
+//
+// and the watchers of the task or project the event is about, which is
+// all that task.status_changed, milestone.closed, sprint.started and
+// sprint.closed notify. It leaves out the user who caused the event and
+// users who turned the event's type off.
+package notifications
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/watchers"
+	"gorm.io/gorm/clause"
+)
+
+// Types are the event types that notify, each of which a user can turn off.
+var Types = []string{
+	events.TaskAssigned,
+	events.SubtaskAssigned,
+	events.ProjectMemberAdded,
+	events.CommentCreated,
+	events.TaskStatusChanged,
+	events.MilestoneClosed,
+	events.SprintStarted,
+	events.SprintClosed,
+}
+
+// Publish is the events sink of notifications. Publishing an event again
+// does not notify twice.
+func Publish(event models.OutboxEvent) error {
+	if !slices.Contains(Types, event.Type) {
+		return nil
+	}
+	direct, template, err := recipientsOf(event)
+	if err != nil {
+		return err
+	}
+	watching, err := watchers.Of(db.DB, event)
+	if err != nil {
+		return err
+	}
+	recipients := slices.Concat(direct, watching)
+	recipients = slices.DeleteFunc(recipients, func(userID string) bool {
+		return userID == "" || event.ActorID != nil && userID == *event.ActorID
+	})
+	slices.Sort(recipients)
+	recipients = slices.Compact(recipients)
+	if len(recipients) == 0 {
+		return nil
+	}
+	var disabled []string
+	err = db.DB.Model(&models.NotificationPreference{}).
+		Where("user_id in ? and type = ? and not enabled", recipients, event.Type).
+		Pluck("user_id", &disabled).Error
+	if err != nil {
+		return err
+	}
+	var rows []models.Notification
+	for _, userID := range recipients {
+		if slices.Contains(disabled, userID) {
+			continue
+		}
+		row := template
+		row.UserID = userID
+		row.Watching = !slices.Contains(direct, userID)
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// recipientsOf returns the users event concerns directly and the
+// notification to send without its user.
+func recipientsOf(event models.OutboxEvent) ([]string, models.Notification, error) {
+	n := models.Notification{
+		EventID:   event.ID,
+		Type:      event.Type,
+		ProjectID: event.ProjectID,
+		ActorID:   event.ActorID,
+		Data:      json.RawMessage(event.Payload),
+	}
+	switch event.Type {
+	case events.TaskAssigned:
+		var data struct {
+			Task models.Task `json:"task"`
+		}
+		if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+			return nil, n, err
+		}
+		n.TaskID = &data.Task.ID
+		n.Title = data.Task.Title
+		return []string{data.Task.AssigneeUserID()}, n, nil
+
+	case events.SubtaskAssigned:
+		var data struct {
+			Subtask models.Subtask `json:"subtask"`
+		}
+		if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+			return nil, n, err
+		}
+		n.TaskID = &data.Subtask.TaskID
+		n.Title = data.Subtask.Title
+		return []string{data.Subtask.AssigneeUserID()}, n, nil
+
+	case events.ProjectMemberAdded:
+		var data struct {
+			ProjectID string `json:"project_id"`
+			UserID    string `json:"user_id"`
+		}
+		if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+			return nil, n, err
+		}
+		var project models.Project
+		if err := db.DB.Select("id", "name").Limit(1).Find(&project, "id = ?", data.ProjectID).Error; err != nil {
+			return nil, n, err
+		}
+		n.Title = project.Name
+		return []string{data.UserID}, n, nil
+
+	case events.CommentCreated:
+		var comment models.Comment
+		if err := json.Unmarshal([]byte(event.Payload), &comment); err != nil {
+			return nil, n, err
+		}
+		var tasks []models.Task
+		err := db.DB.Select("id", "title", "creator_id", "assignee_id").Limit(1).Find(&tasks, "id = ?", comment.TaskID).Error
+		if err != nil || len(tasks) == 0 {
+			return nil, n, err
+		}
+		n.TaskID = &tasks[0].ID
+		n.Title = tasks[0].Title
+		return []string{tasks[0].CreatorID, tasks[0].AssigneeUserID()}, n, nil
+
+	case events.TaskStatusChanged:
+		var data struct {
+			Task models.Task `json:"task"`
+		}
+		if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+			return nil, n, err
+		}
+		n.TaskID = &data.Task.ID
+		n.Title = data.Task.Title
+		return nil, n, nil
+
+	case events.MilestoneClosed:
+		var milestone models.Milestone
+		if err := json.Unmarshal([]byte(event.Payload), &milestone); err != nil {
+			return nil, n, err
+		}
+		n.Title = milestone.Title
+		return nil, n, nil
+
+	case events.SprintStarted, events.SprintClosed:
+		var sprint models.Sprint
+		if err := json.Unmarshal([]byte(event.Payload), &sprint); err != nil {
+			return nil, n, err
+		}
+		n.Title = sprint.Name
+		return nil, n, nil
+	}
+	return nil, n, nil
+}
@@ -0,0 +1,234 @@
+// Package pagination implements keyset (cursor) pagination over
+// (created_at, id) and the older page/per_page offset pagination.
+//
+// Cursor mode is the default: a list answers with an envelope holding the
+// page, an opaque next_cursor and, when include_total=true, the total
+// count. Passing page or per_page selects offset mode, which keeps the old
+// bare JSON array body and reports the total in X-Total-Count. Deprecated
+// unversioned aliases default to offset mode instead, so their clients keep
+// getting a bare array; limit, cursor or include_total opt them into the
+// envelope. Both modes send RFC 8288 Link headers.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ErrInvalidCursor is returned for a cursor this package did not issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the sort key of the last row of a page. Value holds the
+// primary sort value when the list is sorted by an Order.
+type Cursor struct {
+	Value     string    `json:"v,omitempty"`
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// Encode returns the opaque form of c handed to clients.
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.ID == "" || c.CreatedAt.IsZero() {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Page is the response envelope of cursor mode.
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// Params are the pagination parameters of a list request.
+type Params struct {
+	Limit     int
+	After     *Cursor
+	Offset    bool
+	Page      int
+	WithTotal bool
+}
+
+// Parse reads limit, cursor and include_total, or page and per_page for
+// offset mode. Limits are capped at MaxLimit.
+func Parse(r *http.Request) (Params, error) {
+	q := r.URL.Query()
+	p := Params{Limit: DefaultLimit, WithTotal: q.Get("include_total") == "true"}
+	cursorMode := q.Has("limit") || q.Has("cursor") || q.Has("include_total")
+	if q.Has("page") || q.Has("per_page") || (utils.IsLegacy(r) && !cursorMode) {
+		p.Offset = true
+		p.WithTotal = true
+		p.Page, _ = strconv.Atoi(q.Get("page"))
+		if p.Page < 1 {
+			p.Page = 1
+		}
+		p.Limit = clamp(q.Get("per_page"), 10)
+		return p, nil
+	}
+	p.Limit = clamp(q.Get("limit"), DefaultLimit)
+	if s := q.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return p, err
+		}
+		p.After = &c
+	}
+	return p, nil
+}
+
+func clamp(value string, def int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return def
+	}
+	return min(n, MaxLimit)
+}
+
+// Total counts the rows of query when p asks for a total, nil otherwise.
+func Total(query *gorm.DB, p Params) (*int64, error) {
+	if !p.WithTotal {
+		return nil, nil
+	}
+	var total int64
+	err := query.Session(&gorm.Session{}).Count(&total).Error
+	return &total, err
+}
+
+// Order sorts a list by Expr before (created_at, id). Expr must never be
+// null; Type is its SQL type, used to cast the cursor value back. The zero
+// Order keeps the default ascending (created_at, id) sort.
+type Order struct {
+	Expr string
+	Type string
+	Desc bool
+}
+
+// Apply orders query by (created_at, id) of table and limits it to the
+// requested page. Cursor mode fetches one extra row to detect a next page.
+func (p Params) Apply(query *gorm.DB, table string) *gorm.DB {
+	return p.ApplyOrder(query, table, Order{})
+}
+
+// ApplyOrder is Apply with order in front of (created_at, id). A cursor
+// without a Value cannot continue a list sorted by an expression and is
+// rejected by CheckOrder.
+func (p Params) ApplyOrder(query *gorm.DB, table string, order Order) *gorm.DB {
+	dir, cmp := "", ">"
+	if order.Desc {
+		dir, cmp = " desc", "<"
+	}
+	if order.Expr != "" {
+		query = query.Order(order.Expr + dir)
+	}
+	query = query.Order(table + ".created_at" + dir).Order(table + ".id" + dir)
+	if p.Offset {
+		return query.Limit(p.Limit).Offset((p.Page - 1) * p.Limit)
+	}
+	if p.After != nil {
+		keys := fmt.Sprintf("%s.created_at, %s.id", table, table)
+		if order.Expr == "" {
+			query = query.Where(fmt.Sprintf("(%s) %s (?, ?)", keys, cmp), p.After.CreatedAt, p.After.ID)
+		} else {
+			query = query.Where(fmt.Sprintf("(%s, %s) %s (cast(? as %s), ?, ?)", order.Expr, keys, cmp, order.Type),
+				p.After.Value, p.After.CreatedAt, p.After.ID)
+		}
+	}
+	return query.Limit(p.Limit + 1)
+}
+
+// CheckOrder reports ErrInvalidCursor when the cursor of p was issued for a
+// list with a different kind of sort than order.
+func (p Params) CheckOrder(order Order) error {
+	if p.After != nil && (p.After.Value == "") != (order.Expr == "") {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// Write sends items as the response of a list request. key returns the
+// sort key of an item; total may be nil when it was not requested.
+func Write[T any](w http.ResponseWriter, r *http.Request, p Params, items []T, key func(T) Cursor, total *int64) {
+	w.Header().Set("Content-Type", "application/json")
+	if p.Offset {
+		if items == nil {
+			items = []T{}
+		}
+		writeOffsetLinks(w, r, p, *total)
+		w.Header().Set("X-Total-Count", strconv.FormatInt(*total, 10))
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+
+	page := Page[T]{Data: items, Total: total}
+	if len(items) > p.Limit {
+		page.Data = items[:p.Limit]
+		page.NextCursor = key(page.Data[p.Limit-1]).Encode()
+	}
+	if page.Data == nil {
+		page.Data = []T{}
+	}
+	links := []string{link(r, "first", map[string]string{"cursor": ""})}
+	if page.NextCursor != "" {
+		links = append(links, link(r, "next", map[string]string{"cursor": page.NextCursor}))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+	json.NewEncoder(w).Encode(page)
+}
+
+func writeOffsetLinks(w http.ResponseWriter, r *http.Request, p Params, total int64) {
+	last := int((total + int64(p.Limit) - 1) / int64(p.Limit))
+	if last < 1 {
+		last = 1
+	}
+	pageLink := func(rel string, page int) string {
+		return link(r, rel, map[string]string{"page": strconv.Itoa(page), "per_page": strconv.Itoa(p.Limit)})
+	}
+	links := []string{pageLink("first", 1)}
+	if p.Page > 1 {
+		links = append(links, pageLink("prev", min(p.Page-1, last)))
+	}
+	if p.Page < last {
+		links = append(links, pageLink("next", p.Page+1))
+	}
+	links = append(links, pageLink("last", last))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// link renders one Link header value pointing at the request URL with the
+// given query parameters replaced; an empty value removes the parameter.
+func link(r *http.Request, rel string, params map[string]string) string {
+	u := url.URL{Path: r.URL.Path}
+	q := r.URL.Query()
+	for name, value := range params {
+		if value == "" {
+			q.Del(name)
+		} else {
+			q.Set(name, value)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+}
@@ -0,0 +1,61 @@
+package pagination
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Anwarjondev/task-management-api/utils"
+)
+
+func request(target string, legacy bool) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if legacy {
+		r = r.WithContext(utils.WithLegacy(r.Context()))
+	}
+	return r
+}
+
+func TestParseModes(t *testing.T) {
+	tests := []struct {
+		target string
+		legacy bool
+		offset bool
+		limit  int
+	}{
+		{"/v1/tasks", false, false, DefaultLimit},
+		{"/v1/tasks?page=2", false, true, 10},
+		{"/v1/tasks?limit=500", false, false, MaxLimit},
+		{"/gettask", true, true, 10},
+		{"/gettask?per_page=5", true, true, 5},
+		{"/gettask?limit=5", true, false, 5},
+		{"/gettask?include_total=true", true, false, DefaultLimit},
+	}
+	for _, tt := range tests {
+		p, err := Parse(request(tt.target, tt.legacy))
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", tt.target, err)
+		}
+		if p.Offset != tt.offset || p.Limit != tt.limit {
+			t.Errorf("Parse(%s, legacy=%v) = offset %v limit %d, want offset %v limit %d",
+				tt.target, tt.legacy, p.Offset, p.Limit, tt.offset, tt.limit)
+		}
+	}
+}
+
+func TestWriteLegacyDefaultIsBareArray(t *testing.T) {
+	r := request("/gettask", true)
+	p, _ := Parse(r)
+	total := int64(1)
+	w := httptest.NewRecorder()
+	Write(w, r, p, []string{"a"}, func(string) Cursor { return Cursor{} }, &total)
+
+	var items []string
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatalf("body is not a bare array: %v", err)
+	}
+	if len(items) != 1 || w.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("items = %v, X-Total-Count = %q", items, w.Header().Get("X-Total-Count"))
+	}
+}
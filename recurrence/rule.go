@@ -0,0 +1,222 @@
+// Package recurrence implements the subset of RFC 5545 RRULE used for
+// recurring tasks: DAILY, WEEKLY and MONTHLY frequencies with INTERVAL,
+// BYDAY, BYMONTHDAY and the COUNT or UNTIL end conditions.
+package recurrence
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+)
+
+// maxPeriods bounds how far Next searches, so a rule that can never match
+// (e.g. BYMONTHDAY=31 with INTERVAL=12 starting in April) terminates.
+const maxPeriods = 10000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	Count      int
+	Until      time.Time
+}
+
+// Parse reads a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10".
+// An optional "RRULE:" prefix is accepted.
+func Parse(value string) (*Rule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	rule := &Rule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("recurrence: malformed part %q", part)
+		}
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("recurrence: invalid INTERVAL %q", val)
+			}
+			rule.Interval = n
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("recurrence: invalid BYDAY %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("recurrence: invalid BYMONTHDAY %q", day)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("recurrence: invalid COUNT %q", val)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseUntil(val)
+			if err != nil {
+				return nil, err
+			}
+			rule.Until = until
+		default:
+			return nil, fmt.Errorf("recurrence: unsupported part %q", name)
+		}
+	}
+	switch rule.Freq {
+	case Daily, Weekly, Monthly:
+	case "":
+		return nil, fmt.Errorf("recurrence: FREQ is required")
+	default:
+		return nil, fmt.Errorf("recurrence: unsupported FREQ %q", rule.Freq)
+	}
+	if len(rule.ByDay) > 0 && rule.Freq != Weekly {
+		return nil, fmt.Errorf("recurrence: BYDAY is only supported with FREQ=WEEKLY")
+	}
+	if len(rule.ByMonthDay) > 0 && rule.Freq != Monthly {
+		return nil, fmt.Errorf("recurrence: BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, fmt.Errorf("recurrence: COUNT and UNTIL cannot both be set")
+	}
+	return rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if layout == "20060102" {
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("recurrence: invalid UNTIL %q", value)
+}
+
+// String formats the rule back to its RRULE representation.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, weekday := range r.ByDay {
+			days[i] = strings.ToUpper(weekday.String()[:2])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next returns the first occurrence of the series starting at start that
+// falls strictly after after. ok is false once the series has ended.
+func (r *Rule) Next(start, after time.Time) (next time.Time, ok bool) {
+	n := 0
+	for period := 0; period < maxPeriods; period++ {
+		for _, occurrence := range r.period(start, period) {
+			if occurrence.Before(start) {
+				continue
+			}
+			n++
+			if r.Count > 0 && n > r.Count {
+				return time.Time{}, false
+			}
+			if !r.Until.IsZero() && occurrence.After(r.Until) {
+				return time.Time{}, false
+			}
+			if occurrence.After(after) {
+				return occurrence, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// period returns the sorted candidate occurrences of the i-th period
+// (day, week or month, depending on Freq) of the series.
+func (r *Rule) period(start time.Time, i int) []time.Time {
+	step := i * r.Interval
+	switch r.Freq {
+	case Daily:
+		return []time.Time{start.AddDate(0, 0, step)}
+	case Weekly:
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+		monday := start.AddDate(0, 0, -((int(start.Weekday())+6)%7)+7*step)
+		var out []time.Time
+		for _, weekday := range days {
+			out = append(out, monday.AddDate(0, 0, (int(weekday)+6)%7))
+		}
+		slices.SortFunc(out, func(a, b time.Time) int { return a.Compare(b) })
+		return out
+	case Monthly:
+		days := r.ByMonthDay
+		if len(days) == 0 {
+			days = []int{start.Day()}
+		}
+		first := time.Date(start.Year(), start.Month()+time.Month(step), 1,
+			start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+		length := first.AddDate(0, 1, -1).Day()
+		var out []time.Time
+		for _, day := range days {
+			if day < 0 {
+				day = length + day + 1
+			}
+			if day < 1 || day > length {
+				continue
+			}
+			out = append(out, first.AddDate(0, 0, day-1))
+		}
+		slices.SortFunc(out, func(a, b time.Time) int { return a.Compare(b) })
+		return slices.Compact(out)
+	}
+	return nil
+}
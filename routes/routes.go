@@ -4,34 +4,176 @@ import (
 	"net/http"
 
 	"github.com/Anwarjondev/task-management-api/handlers"
-	"github.com/Anwarjondev/task-management-api/middleware"
 )
 
 
 func SetUpRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
+	for _, v := range versions {
+		v.register(&API{mux: mux, prefix: "/" + v.name, deprecation: v.deprecation})
+	}
+	registerDeprecated(&API{mux: mux, deprecation: &legacyPolicy, legacy: true})
+	return mux
+}
 
-	mux.HandleFunc("POST /regitser", handlers.Register)
-	mux.HandleFunc("POST /login", handlers.Login)
+// registerV1 mounts the resource oriented API. Everything that belongs to a
+// project is nested under /v1/projects/{id}.
+func registerV1(api *API) {
+	api.Public("POST /register", handlers.Register)
+	api.Public("POST /login", handlers.Login)
 
+	api.Admin("GET /admin/users", handlers.GetUsers)
+	api.Admin("DELETE /admin/users/{userId}", handlers.DeleteUser)
 
-	protected := http.NewServeMux()
-	protected.HandleFunc("POST /createproject", handlers.CreateTask)
-	protected.HandleFunc("GET /getproject", handlers.GetProject)
-	protected.HandleFunc("PUT /updateproject", handlers.UpdateProject)
-	protected.HandleFunc("DELETE /deleteproject", handlers.DeleteProject)
-	protected.HandleFunc("POST /projects/{id}/members", handlers.AddProjectMember)
-	protected.HandleFunc("POST /createtask", handlers.CreateTask)
-	protected.HandleFunc("GET /gettask", handlers.GetTask)
-	protected.HandleFunc("PUT /updatetask", handlers.Updatetask)
-	protected.HandleFunc("DELETE /deletetask", handlers.DeleteTask)
-	protected.HandleFunc("PUT /updateuser", handlers.UpdateUser)
+	api.Protected("POST /projects", handlers.CreateProject)
+	api.Protected("GET /projects", handlers.GetProject)
+	api.Protected("GET /projects/{id}", handlers.GetProjectByID)
+	api.Protected("PUT /projects/{id}", handlers.UpdateProject)
+	api.Protected("PATCH /projects/{id}", handlers.PatchProject)
+	api.Protected("DELETE /projects/{id}", handlers.DeleteProject)
+	api.Protected("POST /projects/{id}/members", handlers.AddProjectMember)
 
-	admiMux := http.NewServeMux()
-	admiMux.HandleFunc("GET /users", handlers.GetUsers)
-	admiMux.HandleFunc("DELETE /deleteusers", handlers.DeleteUser)
+	api.Protected("GET /projects/{id}/board", handlers.GetBoard)
+	api.Protected("PUT /projects/{id}/board/columns/{status}", handlers.UpdateBoardColumn)
+	api.Protected("GET /projects/{id}/velocity", handlers.GetVelocity)
 
-	mux.Handle("/", middleware.AuthMiddleware(protected))
-	mux.Handle("/admin/", middleware.AdminMiddleware(middleware.AuthMiddleware(admiMux)))
-	return mux
-}
\ No newline at end of file
+	api.Protected("POST /projects/{id}/labels", handlers.CreateLabel)
+	api.Protected("GET /projects/{id}/labels", handlers.GetLabels)
+	api.Protected("PUT /projects/{id}/labels/{labelId}", handlers.UpdateLabel)
+	api.Protected("PATCH /projects/{id}/labels/{labelId}", handlers.PatchLabel)
+	api.Protected("DELETE /projects/{id}/labels/{labelId}", handlers.DeleteLabel)
+
+	api.Protected("POST /projects/{id}/milestones", handlers.CreateMilestone)
+	api.Protected("GET /projects/{id}/milestones", handlers.GetMilestones)
+	api.Protected("GET /projects/{id}/milestones/{milestoneId}", handlers.GetMilestone)
+	api.Protected("PUT /projects/{id}/milestones/{milestoneId}", handlers.UpdateMilestone)
+	api.Protected("PATCH /projects/{id}/milestones/{milestoneId}", handlers.PatchMilestone)
+	api.Protected("DELETE /projects/{id}/milestones/{milestoneId}", handlers.DeleteMilestone)
+	api.Protected("POST /projects/{id}/milestones/{milestoneId}/close", handlers.CloseMilestone)
+	api.Protected("POST /projects/{id}/milestones/{milestoneId}/reopen", handlers.ReopenMilestone)
+
+	api.Protected("POST /projects/{id}/sprints", handlers.CreateSprint)
+	api.Protected("GET /projects/{id}/sprints", handlers.GetSprints)
+	api.Protected("GET /projects/{id}/sprints/{sprintId}", handlers.GetSprint)
+	api.Protected("PUT /projects/{id}/sprints/{sprintId}", handlers.UpdateSprint)
+	api.Protected("PATCH /projects/{id}/sprints/{sprintId}", handlers.PatchSprint)
+	api.Protected("DELETE /projects/{id}/sprints/{sprintId}", handlers.DeleteSprint)
+	api.Protected("POST /projects/{id}/sprints/{sprintId}/start", handlers.StartSprint)
+	api.Protected("POST /projects/{id}/sprints/{sprintId}/close", handlers.CloseSprint)
+
+	api.Protected("POST /projects/{id}/webhooks", handlers.CreateWebhook)
+	api.Protected("GET /projects/{id}/webhooks", handlers.GetWebhooks)
+	api.Protected("GET /projects/{id}/webhooks/{webhookId}", handlers.GetWebhook)
+	api.Protected("PUT /projects/{id}/webhooks/{webhookId}", handlers.UpdateWebhook)
+	api.Protected("PATCH /projects/{id}/webhooks/{webhookId}", handlers.PatchWebhook)
+	api.Protected("DELETE /projects/{id}/webhooks/{webhookId}", handlers.DeleteWebhook)
+	api.Protected("GET /projects/{id}/webhooks/{webhookId}/deliveries", handlers.GetWebhookDeliveries)
+	api.Protected("GET /projects/{id}/webhooks/{webhookId}/deliveries/{deliveryId}", handlers.GetWebhookDelivery)
+	api.Protected("POST /projects/{id}/webhooks/{webhookId}/deliveries/{deliveryId}/redeliver", handlers.RedeliverWebhook)
+
+	api.Protected("POST /projects/{id}/task-templates", handlers.CreateTaskTemplate)
+	api.Protected("GET /projects/{id}/task-templates", handlers.GetTaskTemplates)
+	api.Protected("PUT /projects/{id}/task-templates/{templateId}", handlers.UpdateTaskTemplate)
+	api.Protected("PATCH /projects/{id}/task-templates/{templateId}", handlers.PatchTaskTemplate)
+	api.Protected("DELETE /projects/{id}/task-templates/{templateId}", handlers.DeleteTaskTemplate)
+
+	api.Protected("POST /projects/{id}/tasks", handlers.CreateTask)
+	api.Protected("GET /projects/{id}/tasks", handlers.GetTask)
+	api.Protected("GET /projects/{id}/tasks/{taskId}", handlers.GetTaskByID)
+	api.Protected("PUT /projects/{id}/tasks/{taskId}", handlers.Updatetask)
+	api.Protected("PATCH /projects/{id}/tasks/{taskId}", handlers.PatchTask)
+	api.Protected("DELETE /projects/{id}/tasks/{taskId}", handlers.DeleteTask)
+	api.Protected("POST /projects/{id}/tasks/{taskId}/move", handlers.MoveTask)
+	api.Protected("PUT /projects/{id}/tasks/{taskId}/labels", handlers.SetTaskLabels)
+	api.Protected("DELETE /projects/{id}/tasks/{taskId}/labels/{labelId}", handlers.RemoveTaskLabel)
+	api.Protected("POST /projects/{id}/tasks/{taskId}/attachments", handlers.UploadAttachment)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/attachments", handlers.GetAttachments)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/attachments/{attachmentId}", handlers.DownloadAttachment)
+	api.Protected("DELETE /projects/{id}/tasks/{taskId}/attachments/{attachmentId}", handlers.DeleteAttachment)
+	api.Protected("POST /projects/{id}/tasks/{taskId}/worklogs", handlers.CreateWorkLog)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/worklogs", handlers.GetWorkLogs)
+	api.Protected("DELETE /projects/{id}/tasks/{taskId}/worklogs/{worklogId}", handlers.DeleteWorkLog)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/time", handlers.GetTaskTime)
+	api.Protected("POST /projects/{id}/tasks/{taskId}/timer", handlers.StartTimer)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/history", handlers.GetTaskHistory)
+	// The history is also reachable by task id alone, for links that
+	// do not carry the project.
+	api.Protected("GET /tasks/{taskId}/history", handlers.GetTaskHistory)
+
+	api.Protected("POST /projects/{id}/tasks/{taskId}/comments", handlers.CreateComment)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/comments", handlers.GetComments)
+	api.Protected("DELETE /projects/{id}/tasks/{taskId}/comments/{commentId}", handlers.DeleteComment)
+
+	api.Protected("POST /projects/{id}/tasks/{taskId}/subtasks", handlers.CreateSubTask)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/subtasks", handlers.GetSubtask)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/subtasks/{subId}", handlers.GetSubtaskByID)
+	api.Protected("PUT /projects/{id}/tasks/{taskId}/subtasks/{subId}", handlers.UpdateSubtask)
+	api.Protected("PATCH /projects/{id}/tasks/{taskId}/subtasks/{subId}", handlers.PatchSubtask)
+	api.Protected("DELETE /projects/{id}/tasks/{taskId}/subtasks/{subId}", handlers.DeleteSubtask)
+
+	api.Protected("POST /views", handlers.CreateSavedView)
+	api.Protected("GET /views", handlers.GetSavedViews)
+	api.Protected("GET /views/{viewId}", handlers.GetSavedView)
+	api.Protected("PUT /views/{viewId}", handlers.UpdateSavedView)
+	api.Protected("PATCH /views/{viewId}", handlers.PatchSavedView)
+	api.Protected("DELETE /views/{viewId}", handlers.DeleteSavedView)
+	api.Protected("GET /views/{viewId}/tasks", handlers.RunSavedView)
+
+	api.Protected("GET /search", handlers.Search)
+
+	api.Stream("GET /stream", handlers.Stream)
+	api.Protected("POST /stream/tokens", handlers.CreateStreamToken)
+
+	api.Protected("GET /notifications", handlers.GetNotifications)
+	api.Protected("GET /notifications/unread_count", handlers.GetUnreadNotificationCount)
+	api.Protected("POST /notifications/read_all", handlers.MarkAllNotificationsRead)
+	api.Protected("POST /notifications/{notificationId}/read", handlers.MarkNotificationRead)
+	api.Protected("GET /notifications/preferences", handlers.GetNotificationPreferences)
+	api.Protected("PUT /notifications/preferences", handlers.UpdateNotificationPreferences)
+	api.Protected("GET /notifications/email", handlers.GetEmailSettings)
+	api.Protected("PUT /notifications/email", handlers.UpdateEmailSettings)
+	api.Public("GET /unsubscribe", handlers.ConfirmUnsubscribe)
+	api.Public("POST /unsubscribe", handlers.Unsubscribe)
+
+	api.Protected("GET /projects/{id}/inbound_address", handlers.GetInboundAddress)
+	api.Protected("PUT /projects/{id}/inbound_address", handlers.UpdateInboundAddress)
+	api.Protected("DELETE /projects/{id}/inbound_address", handlers.DeleteInboundAddress)
+	api.Public("POST /inbound/email", handlers.ReceiveEmail)
+
+	api.Protected("POST /projects/{id}/watch", handlers.WatchProject)
+	api.Protected("DELETE /projects/{id}/watch", handlers.UnwatchProject)
+	api.Protected("GET /projects/{id}/watchers", handlers.GetProjectWatchers)
+	api.Protected("POST /projects/{id}/tasks/{taskId}/watch", handlers.WatchTask)
+	api.Protected("DELETE /projects/{id}/tasks/{taskId}/watch", handlers.UnwatchTask)
+	api.Protected("GET /projects/{id}/tasks/{taskId}/watchers", handlers.GetTaskWatchers)
+	api.Protected("GET /watching", handlers.GetWatching)
+
+	api.Protected("GET /timer", handlers.GetTimer)
+	api.Protected("POST /timer/stop", handlers.StopTimer)
+	api.Protected("PUT /users/{userId}", handlers.UpdateUser)
+	api.Protected("PATCH /users/{userId}", handlers.PatchUser)
+}
+
+// registerDeprecated keeps the pre-/v1 paths working for one more release.
+// Handlers read the same path values, so the aliases only differ in layout.
+// Every response carries the Deprecation and Sunset headers of legacyPolicy.
+// Only the routes served before /v1 have aliases; endpoints added since
+// exist under /v1 alone.
+func registerDeprecated(api *API) {
+	api.Public("POST /regitser", handlers.Register)
+	api.Public("POST /login", handlers.Login)
+
+	api.Protected("POST /createproject", handlers.CreateProject)
+	api.Protected("GET /getproject", handlers.GetProject)
+	api.Protected("PUT /updateproject/{id}", handlers.UpdateProject)
+	api.Protected("DELETE /deleteproject/{id}", handlers.DeleteProject)
+	api.Protected("POST /projects/{id}/members", handlers.AddProjectMember)
+	api.Protected("POST /createtask", handlers.CreateTask)
+	api.Protected("GET /gettask", handlers.GetTask)
+	api.Protected("PUT /updatetask/{taskId}", handlers.Updatetask)
+	api.Protected("DELETE /deletetask/{taskId}", handlers.DeleteTask)
+	api.Protected("PUT /updateuser/{userId}", handlers.UpdateUser)
+
+	api.Admin("GET /admin/users", handlers.GetUsers)
+	api.Admin("DELETE /admin/deleteusers/{userId}", handlers.DeleteUser)
+}
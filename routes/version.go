@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/middleware"
+)
+
+// version is one mounted API version. To ship /v2 add an entry with its own
+// register function; handlers that did not change can simply be registered
+// again. Setting deprecation marks every route of the version deprecated.
+type version struct {
+	name        string
+	register    func(api *API)
+	deprecation *middleware.DeprecationPolicy
+}
+
+var versions = []version{
+	{name: "v1", register: registerV1},
+}
+
+// legacyPolicy applies to the unversioned aliases kept from before /v1.
+var legacyPolicy = middleware.DeprecationPolicy{
+	Deprecated: time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+	Sunset:     time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC),
+	Successor:  "/v1/",
+}
+
+// Versions returns the names of the mounted API versions, e.g. ["v1"].
+func Versions() []string {
+	names := make([]string, 0, len(versions))
+	for _, v := range versions {
+		names = append(names, v.name)
+	}
+	return names
+}
+
+// API registers handlers of one version. Patterns are written without the
+// version ("GET /projects/{id}") and mounted under the version prefix.
+type API struct {
+	mux         *http.ServeMux
+	prefix      string
+	deprecation *middleware.DeprecationPolicy
+	// legacy marks the requests of the pre-/v1 aliases, see utils.IsLegacy.
+	legacy bool
+}
+
+// Public registers a route that needs no authentication.
+func (a *API) Public(pattern string, handler http.HandlerFunc) {
+	a.mux.Handle(a.pattern(pattern), a.wrap(handler))
+}
+
+// Protected registers a route that needs an authenticated user.
+func (a *API) Protected(pattern string, handler http.HandlerFunc) {
+	a.mux.Handle(a.pattern(pattern), a.wrap(middleware.AuthMiddleware(handler)))
+}
+
+// Stream registers a route that needs an authenticated user, who may also
+// authenticate with a stream token in the query.
+func (a *API) Stream(pattern string, handler http.HandlerFunc) {
+	a.mux.Handle(a.pattern(pattern), a.wrap(middleware.QueryTokenAuth(middleware.StreamAudience, handler)))
+}
+
+// Admin registers a route that needs an admin user.
+func (a *API) Admin(pattern string, handler http.HandlerFunc) {
+	a.mux.Handle(a.pattern(pattern), a.wrap(middleware.AuthMiddleware(middleware.AdminMiddleware(handler))))
+}
+
+// pattern turns "GET /projects" into "GET /v1/projects".
+func (a *API) pattern(pattern string) string {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		return a.prefix + pattern
+	}
+	return method + " " + a.prefix + path
+}
+
+// wrap adds the deprecation headers outside of authentication, so clients
+// see them even on a 401.
+func (a *API) wrap(handler http.Handler) http.Handler {
+	if a.legacy {
+		handler = middleware.Legacy(handler)
+	}
+	if a.deprecation == nil {
+		return handler
+	}
+	return middleware.Deprecated(*a.deprecation, handler)
+}
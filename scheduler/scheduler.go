@@ -0,0 +1,159 @@
+// Package scheduler runs the background job that turns recurring task
+// templates into tasks.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/board"
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/recurrence"
+	"github.com/Anwarjondev/task-management-api/watchers"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Start runs the scheduler every interval until ctx is cancelled.
+func Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := RunOnce(time.Now().UTC()); err != nil {
+			log.Println("Recurring task scheduler failed:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce creates every task instance that is due at now. Each template is
+// handled in its own transaction holding a row lock taken with SKIP LOCKED,
+// so several replicas can run the scheduler at the same time without
+// creating the same instance twice.
+func RunOnce(now time.Time) error {
+	for {
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			return generateNext(tx, now)
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func generateNext(tx *gorm.DB, now time.Time) error {
+	var template models.TaskTemplate
+	err := tx.Clauses(clause.Locking{
+		Strength: "UPDATE",
+		Table:    clause.Table{Name: "task_template"},
+		Options:  "SKIP LOCKED",
+	}).
+		Joins("left join task on task.id = task_template.last_task_id").
+		Where("task_template.active = ? and task_template.next_run_at is not null", true).
+		// A last task that was deleted counts as completed, or the
+		// template would wait for it forever.
+		Where(`(task_template.trigger = 'completion' and (task_template.last_task_id is null and task_template.next_run_at <= ?
+				or task.status = 'completed' or task_template.last_task_id is not null and task.id is null))
+			or (task_template.trigger <> 'completion' and task_template.next_run_at <= ?)`, now, now).
+		Order("task_template.next_run_at").
+		First(&template).Error
+	if err != nil {
+		return err
+	}
+
+	rule, err := recurrence.Parse(template.RRule)
+	if err != nil {
+		log.Printf("Deactivating task template %s: %v", template.ID, err)
+		return tx.Model(&template).Update("active", false).Error
+	}
+
+	due := *template.NextRunAt
+	ended := false
+	// A completion-triggered instance finished late would otherwise be
+	// due in the past; skip ahead to the next occurrence after now.
+	for template.Trigger == "completion" && due.Before(now) {
+		next, ok := rule.Next(template.StartAt, due)
+		if !ok {
+			ended = true
+			break
+		}
+		due = next
+	}
+	if ended {
+		return tx.Model(&template).Updates(map[string]any{"active": false, "next_run_at": nil}).Error
+	}
+
+	// New instances go to the end of the pending column.
+	if _, err := board.Lock(tx, template.ProjectID, "pending"); err != nil {
+		return err
+	}
+	rank, err := board.EndRank(tx, template.ProjectID, "pending", "")
+	if err != nil {
+		return err
+	}
+	task := models.Task{
+		Title:           template.Title,
+		Description:     template.Description,
+		Status:          "pending",
+		ProjectID:       template.ProjectID,
+		AssigneeID:      template.AssigneeID,
+		CreatorID:       template.CreatorID,
+		EstimateMinutes: template.EstimateMinutes,
+		DueDate:         &due,
+		TemplateID:      &template.ID,
+		Rank:            rank,
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit(clause.Associations).Create(&task)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Already generated by an earlier run; look it up so the template
+		// still points at the right instance.
+		if err := tx.First(&task, "template_id = ? and due_date = ?", template.ID, due).Error; err != nil {
+			return err
+		}
+	} else {
+		err := events.Record(tx, events.Event{
+			Type:      events.TaskCreated,
+			Key:       events.Key("task", task.ID),
+			ProjectID: task.ProjectID,
+			Data:      task,
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&models.TaskActivity{TaskID: task.ID, Action: events.TaskCreated}).Error
+		if err != nil {
+			return err
+		}
+		for _, userID := range []string{task.CreatorID, task.AssigneeUserID()} {
+			if err := watchers.Watch(tx, userID, watchers.Task, task.ID, task.ProjectID); err != nil {
+				return err
+			}
+		}
+	}
+
+	updates := map[string]any{
+		"last_task_id":     task.ID,
+		"occurrence_count": template.OccurrenceCount + 1,
+	}
+	if next, ok := rule.Next(template.StartAt, due); ok {
+		updates["next_run_at"] = next
+	} else {
+		updates["next_run_at"] = nil
+		updates["active"] = false
+	}
+	return tx.Model(&template).Updates(updates).Error
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3StandIn is an in-memory S3-compatible server, in the spirit of a local
+// MinIO. It checks the SigV4 signature of every request independently of
+// S3Storage.sign.
+type s3StandIn struct {
+	t         *testing.T
+	accessKey string
+	secretKey string
+	region    string
+
+	mu      sync.Mutex
+	objects map[string]s3Object
+}
+
+type s3Object struct {
+	body        []byte
+	contentType string
+}
+
+func newS3StandIn(t *testing.T) (*s3StandIn, *httptest.Server) {
+	standIn := &s3StandIn{
+		t:         t,
+		accessKey: "minio",
+		secretKey: "minio-secret",
+		region:    "us-east-1",
+		objects:   map[string]s3Object{},
+	}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	return standIn, server
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.verify(r); err != nil {
+		http.Error(w, "SignatureDoesNotMatch: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) != r.ContentLength {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = s3Object{body: body, contentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		object, ok := s.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Write(object.body)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify recomputes the SigV4 signature of r from the headers it lists.
+func (s *s3StandIn) verify(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	rest, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("not a SigV4 request")
+	}
+	parts := map[string]string{}
+	for _, part := range strings.Split(rest, ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		parts[name] = value
+	}
+	credential := strings.Split(parts["Credential"], "/")
+	if len(credential) != 5 || credential[0] != s.accessKey {
+		return errors.New("unknown access key")
+	}
+	date, region := credential[1], credential[2]
+	amzDate := r.Header.Get("X-Amz-Date")
+	if region != s.region || !strings.HasPrefix(amzDate, date) {
+		return errors.New("bad credential scope")
+	}
+	signed, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(signed).Abs() > 15*time.Minute {
+		return errors.New("request time too skewed")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(parts["SignedHeaders"], ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := r.Method + "\n" +
+		r.URL.EscapedPath() + "\n" +
+		r.URL.Query().Encode() + "\n" +
+		canonicalHeaders.String() + "\n" +
+		parts["SignedHeaders"] + "\n" +
+		r.Header.Get("X-Amz-Content-Sha256")
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range credential[1:] {
+		key = hmacSHA256(key, part)
+	}
+	if hex.EncodeToString(hmacSHA256(key, stringToSign)) != parts["Signature"] {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func newTestS3Storage(standIn *s3StandIn, server *httptest.Server) *S3Storage {
+	return NewS3Storage(S3Config{
+		Endpoint:  server.URL + "/",
+		Bucket:    "attachments",
+		AccessKey: standIn.accessKey,
+		SecretKey: standIn.secretKey,
+		PathStyle: true,
+	})
+}
+
+func TestS3PutGetDelete(t *testing.T) {
+	standIn, server := newS3StandIn(t)
+	store := newTestS3Storage(standIn, server)
+	ctx := context.Background()
+	key := "tasks/42/report file.txt"
+	content := "hello from the stand-in"
+
+	err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	object, ok := standIn.objects["/attachments/"+key]
+	if !ok {
+		t.Fatalf("object not stored under the bucket path; have %v", standIn.objects)
+	}
+	if object.contentType != "text/plain" {
+		t.Errorf("content type = %q, want text/plain", object.contentType)
+	}
+
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if string(got) != content {
+		t.Errorf("Get = %q, want %q", got, content)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing object = %v, want nil", err)
+	}
+}
+
+func TestS3GetMissing(t *testing.T) {
+	standIn, server := newS3StandIn(t)
+	store := newTestS3Storage(standIn, server)
+
+	if _, err := store.Get(context.Background(), "tasks/none"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3WrongSecretIsRejected(t *testing.T) {
+	standIn, server := newS3StandIn(t)
+	store := newTestS3Storage(standIn, server)
+	store.cfg.SecretKey = "not-the-secret"
+
+	err := store.Put(context.Background(), "tasks/1", strings.NewReader("x"), 1, "text/plain")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Put with a wrong secret = %v, want a 403 error", err)
+	}
+	if len(standIn.objects) != 0 {
+		t.Errorf("stand-in stored %d objects, want 0", len(standIn.objects))
+	}
+}
+
+func TestS3VirtualHostURL(t *testing.T) {
+	store := NewS3Storage(S3Config{Endpoint: "https://s3.example.com", Bucket: "files"})
+	u, err := store.objectURL("tasks/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.String(); got != "https://files.s3.example.com/tasks/1" {
+		t.Errorf("objectURL = %s", got)
+	}
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// ErrNotFound is returned when an object does not exist in the store.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage keeps uploaded file contents under string keys.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var Store Storage
+
+// Connect picks the storage backend from STORAGE_DRIVER ("local" or "s3").
+func Connect() {
+	switch os.Getenv("STORAGE_DRIVER") {
+	case "s3":
+		Store = NewS3Storage(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PathStyle: os.Getenv("S3_VIRTUAL_HOST") != "true",
+		})
+		log.Println("Using S3 storage at", os.Getenv("S3_ENDPOINT"))
+	default:
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		local, err := NewLocalStorage(dir)
+		if err != nil {
+			panic("Failed to prepare storage directory: " + err.Error())
+		}
+		Store = local
+		log.Println("Using local storage in", dir)
+	}
+}
@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/events"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Listen subscribes to Channel and broadcasts announced events to the
+// local subscribers until ctx is cancelled. A lost connection is replaced
+// after retry, catching up on the events announced in between.
+func Listen(ctx context.Context, retry time.Duration) {
+	for {
+		err := listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("Event stream listener failed:", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+func listen(ctx context.Context) error {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(ctx, "listen "+events.Channel); err != nil {
+			return err
+		}
+		if last := lastSeen(); last > 0 {
+			if err := catchUp(last); err != nil {
+				return err
+			}
+		}
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			position, err := strconv.ParseInt(notification.Payload, 10, 64)
+			if err != nil {
+				continue
+			}
+			if err := deliver(position); err != nil {
+				log.Printf("Loading event %d for the stream failed: %v", position, err)
+			}
+		}
+	})
+	// The connection is still listening; keep it out of the pool.
+	return errors.Join(err, driver.ErrBadConn)
+}
+
+// deliver loads the event published at position and broadcasts it.
+func deliver(position int64) error {
+	var rows []models.OutboxEvent
+	if err := db.DB.Where("publish_seq = ?", position).Limit(1).Find(&rows).Error; err != nil {
+		return err
+	}
+	loaded, err := withTasks(rows)
+	if err != nil {
+		return err
+	}
+	for _, event := range loaded {
+		broadcast(event)
+	}
+	return nil
+}
+
+// catchUp broadcasts the events published after position, which were
+// possibly announced while no connection was listening.
+func catchUp(position int64) error {
+	missed, err := Since(position)
+	if errors.Is(err, ErrTooOld) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, event := range missed {
+		broadcast(event)
+	}
+	return nil
+}
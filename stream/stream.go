@@ -0,0 +1,176 @@
+// Package stream fans published domain events out to clients connected
+// to this replica.
+//
+// The events outbox announces every published event on the Postgres
+// channel events.Channel when its publication commits. Each replica runs
+// Listen, which loads announced events and hands them to the local
+// subscribers, so a client sees events no matter which replica recorded
+// or published them. Events are identified by their PublishSeq, which
+// follows publication order; Since replays the events after one from the
+// outbox.
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/models"
+)
+
+const (
+	// MaxReplay is the most events Since returns.
+	MaxReplay = 1000
+	// buffer is how many events a subscriber may lag behind before it
+	// is dropped.
+	buffer = 64
+	// remembered is how many recently seen events the hub keeps to drop
+	// duplicate announcements.
+	remembered = 1024
+)
+
+// ErrTooOld is returned by Since when events after the given one are no
+// longer all available; the client has to reload its state.
+var ErrTooOld = errors.New("stream: events are no longer available")
+
+// Event is a published outbox event. Task is the task the event is about,
+// for events keyed by a task; only its id, project, creator and assignee
+// are set.
+type Event struct {
+	models.OutboxEvent
+	Task *models.Task
+}
+
+// Position is the PublishSeq of the event, its id in the stream.
+func (e *Event) Position() int64 {
+	return *e.PublishSeq
+}
+
+type hub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	seen        map[int64]struct{}
+	order       []int64
+	last        int64
+}
+
+var local = hub{
+	subscribers: map[chan Event]struct{}{},
+	seen:        map[int64]struct{}{},
+}
+
+// Subscribe returns a channel receiving every event published from now on
+// and a function ending the subscription. The channel is closed when the
+// subscriber falls too far behind or unsubscribes.
+func Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	local.mu.Lock()
+	local.subscribers[ch] = struct{}{}
+	local.mu.Unlock()
+	return ch, func() {
+		local.mu.Lock()
+		defer local.mu.Unlock()
+		if _, ok := local.subscribers[ch]; ok {
+			delete(local.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// broadcast hands event to every subscriber unless it was seen before.
+func broadcast(event Event) {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	position := event.Position()
+	if _, ok := local.seen[position]; ok {
+		return
+	}
+	local.seen[position] = struct{}{}
+	local.order = append(local.order, position)
+	if len(local.order) > remembered {
+		delete(local.seen, local.order[0])
+		local.order = local.order[1:]
+	}
+	local.last = max(local.last, position)
+	for ch := range local.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(local.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// lastSeen returns the highest position broadcast so far.
+func lastSeen() int64 {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	return local.last
+}
+
+// Since returns the events published after the one at position, in
+// publication order.
+func Since(position int64) ([]Event, error) {
+	var oldest *int64
+	err := db.DB.Model(&models.OutboxEvent{}).Select("min(publish_seq)").Scan(&oldest).Error
+	if err != nil {
+		return nil, err
+	}
+	if oldest != nil && position < *oldest-1 {
+		return nil, ErrTooOld
+	}
+	var rows []models.OutboxEvent
+	err = db.DB.Where("publish_seq > ?", position).
+		Order("publish_seq").
+		Limit(MaxReplay + 1).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > MaxReplay {
+		return nil, ErrTooOld
+	}
+	return withTasks(rows)
+}
+
+// withTasks resolves the tasks of events keyed by a task. A task deleted
+// since is taken from the event's data, which is the task itself for
+// task.deleted.
+func withTasks(rows []models.OutboxEvent) ([]Event, error) {
+	var ids []string
+	for _, row := range rows {
+		if id, ok := strings.CutPrefix(row.Key, "task:"); ok {
+			ids = append(ids, id)
+		}
+	}
+	tasks := map[string]*models.Task{}
+	if len(ids) > 0 {
+		var found []models.Task
+		err := db.DB.Select("id", "project_id", "creator_id", "assignee_id").Where("id in ?", ids).Find(&found).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			tasks[found[i].ID] = &found[i]
+		}
+	}
+	events := make([]Event, len(rows))
+	for i, row := range rows {
+		events[i] = Event{OutboxEvent: row}
+		id, ok := strings.CutPrefix(row.Key, "task:")
+		if !ok {
+			continue
+		}
+		events[i].Task = tasks[id]
+		if events[i].Task == nil {
+			var task models.Task
+			if json.Unmarshal([]byte(row.Payload), &task) == nil && task.ID == id {
+				events[i].Task = &task
+			}
+		}
+	}
+	return events, nil
+}
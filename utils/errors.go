@@ -2,16 +2,159 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Anwarjondev/task-management-api/i18n"
+	"github.com/go-playground/validator/v10"
 )
 
-type ErrorResponse struct {
-	Status  int    `json:"status"`
-	Message string `json:"message"`
+// Problem is an RFC 7807 problem details object. Code is a stable,
+// machine-readable identifier such as "task.not_found"; clients should
+// branch on it rather than on Detail, which is meant for humans.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Code     string       `json:"code"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes one invalid field of a request body. Field is the
+// JSON name of the field, Rule the failed validation rule (e.g. "required").
+// Position is the 1-based offset of the error within a query expression.
+type FieldError struct {
+	Field    string `json:"field"`
+	Rule     string `json:"rule"`
+	Param    string `json:"param,omitempty"`
+	Position int    `json:"position,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Codes shared by all resources. Resource specific codes are written as
+// "<resource>.<reason>" at the call site; each code needs a message in the
+// i18n catalogs.
+const (
+	CodeInternal           = "internal.error"
+	CodeValidation         = "request.validation_failed"
+	CodeMalformedBody      = "request.malformed_body"
+	CodeWrongType          = "request.wrong_type"
+	CodeIfMatchRequired    = "request.precondition_required"
+	CodeStale              = "request.precondition_failed"
+	CodeReadOnlyField      = "request.read_only_field"
+	CodeUnsupportedMedia   = "request.unsupported_media_type"
+	CodeTokenMissing       = "auth.token_missing"
+	CodeTokenInvalid       = "auth.token_invalid"
+	CodeTokenExpired       = "auth.token_expired"
+	CodeAdminOnly          = "auth.admin_only"
+	CodeInvalidLogin       = "auth.invalid_credentials"
+	CodeMissingLogin       = "auth.credentials_required"
+	CodeUnsupportedVersion = "api.unsupported_version"
+)
+
+// SendProblem writes p as application/problem+json. The title is
+// translated for the request's Accept-Language.
+func SendProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = i18n.T(r, "status."+strconv.Itoa(p.Status), http.StatusText(p.Status))
+	}
+	if p.Instance == "" && r != nil {
+		p.Instance = r.URL.Path
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Content-Language", i18n.Locale(r))
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// SendError writes a problem with the given status and code. The detail is
+// the catalog message for code, with params filling its placeholders.
+func SendError(w http.ResponseWriter, r *http.Request, status int, code string, params ...string) {
+	SendProblem(w, r, Problem{Status: status, Code: code, Detail: i18n.T(r, code, code, params...)})
+}
+
+// SendInternalError logs err and answers 500 without exposing it; database
+// and driver messages must never reach clients.
+func SendInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	SendError(w, r, http.StatusInternalServerError, CodeInternal)
+}
+
+// SendDecodeError answers 400 for a request body that is not valid JSON or
+// has a value of the wrong type.
+func SendDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field == "" {
+		SendError(w, r, http.StatusBadRequest, CodeMalformedBody)
+		return
+	}
+	SendProblem(w, r, Problem{
+		Status: http.StatusBadRequest,
+		Code:   CodeWrongType,
+		Detail: i18n.T(r, CodeWrongType, CodeWrongType),
+		Errors: []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: i18n.T(r, "validation.type", "", typeErr.Field, typeErr.Type.String()),
+		}},
+	})
+}
+
+// SendReadOnlyError answers 400 for a patch that names a field the caller
+// may not change.
+func SendReadOnlyError(w http.ResponseWriter, r *http.Request, field string) {
+	SendProblem(w, r, Problem{
+		Status: http.StatusBadRequest,
+		Code:   CodeReadOnlyField,
+		Detail: i18n.T(r, CodeReadOnlyField, CodeReadOnlyField, field),
+		Errors: []FieldError{{
+			Field:   field,
+			Rule:    "readonly",
+			Message: i18n.T(r, "validation.readonly", "", field),
+		}},
+	})
+}
+
+// SendValidationError answers 400 with one entry per failed field. Anything
+// other than validator.ValidationErrors is a programming error and is
+// reported as an internal error.
+func SendValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		SendInternalError(w, r, err)
+		return
+	}
+	p := Problem{Status: http.StatusBadRequest, Code: CodeValidation, Detail: i18n.T(r, CodeValidation, CodeValidation)}
+	for _, fe := range validationErrs {
+		// Namespace is "Task.title" or "Sprint.items[0].name"; drop the
+		// root struct so the path matches the request body.
+		field := fe.Namespace()
+		if _, rest, found := strings.Cut(field, "."); found {
+			field = rest
+		}
+		p.Errors = append(p.Errors, FieldError{
+			Field:   field,
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: validationMessage(r, field, fe),
+		})
+	}
+	SendProblem(w, r, p)
 }
 
-func SendError(w http.ResponseWriter, statuscode int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statuscode)
-	json.NewEncoder(w).Encode(ErrorResponse{Status: statuscode, Message: message})
+// validationMessage looks up "validation.<tag>" and falls back to
+// "validation.default" for tags without their own message.
+func validationMessage(r *http.Request, field string, fe validator.FieldError) string {
+	def := i18n.T(r, "validation.default", field+" is invalid", field, fe.Tag())
+	return i18n.T(r, "validation."+fe.Tag(), def, field, fe.Param())
 }
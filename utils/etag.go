@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag returns the entity tag of a resource at version.
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// SetETag sets the ETag header for a resource at version.
+func SetETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", ETag(version))
+}
+
+// NotModified answers a conditional GET. It sets the ETag header and, when
+// If-None-Match names the current version, writes 304 Not Modified and
+// returns true.
+func NotModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	SetETag(w, version)
+	if !matchETag(r.Header.Get("If-None-Match"), ETag(version), true) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// CheckIfMatch enforces If-Match on a write to a resource at version. It
+// answers 428 when the header is missing and 412 when it names another
+// version, and returns whether the write may go ahead. Deprecated aliases
+// predate If-Match, so a write without the header stays unconditional
+// there until they are removed.
+func CheckIfMatch(w http.ResponseWriter, r *http.Request, version int) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if IsLegacy(r) {
+			return true
+		}
+		SendError(w, r, http.StatusPreconditionRequired, CodeIfMatchRequired)
+		return false
+	}
+	if !matchETag(header, ETag(version), false) {
+		SetETag(w, version)
+		SendError(w, r, http.StatusPreconditionFailed, CodeStale)
+		return false
+	}
+	return true
+}
+
+// matchETag reports whether a list of entity tags contains etag or "*".
+// If-None-Match compares weakly, If-Match strongly.
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckIfMatch(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		legacy  bool
+		ok      bool
+		status  int
+	}{
+		{`"3"`, false, true, http.StatusOK},
+		{`"2", "3"`, false, true, http.StatusOK},
+		{`*`, false, true, http.StatusOK},
+		{``, false, false, http.StatusPreconditionRequired},
+		{`"2"`, false, false, http.StatusPreconditionFailed},
+		{`W/"3"`, false, false, http.StatusPreconditionFailed},
+		{``, true, true, http.StatusOK},
+		{`"2"`, true, false, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/v1/projects/p", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		if tt.legacy {
+			r = r.WithContext(WithLegacy(r.Context()))
+		}
+		w := httptest.NewRecorder()
+		if ok := CheckIfMatch(w, r, 3); ok != tt.ok || w.Code != tt.status {
+			t.Errorf("If-Match %q, legacy %v: got %v %d, want %v %d", tt.ifMatch, tt.legacy, ok, w.Code, tt.ok, tt.status)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+)
+
+type legacyKey struct{}
+
+// WithLegacy marks a request context as served by a deprecated
+// unversioned alias, whose clients predate /v1.
+func WithLegacy(ctx context.Context) context.Context {
+	return context.WithValue(ctx, legacyKey{}, true)
+}
+
+// IsLegacy reports whether r came in on a deprecated unversioned alias.
+// Such routes keep the behaviour they had before /v1.
+func IsLegacy(r *http.Request) bool {
+	legacy, _ := r.Context().Value(legacyKey{}).(bool)
+	return legacy
+}
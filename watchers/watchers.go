@@ -0,0 +1,65 @@
+// Package watchers keeps track of the users watching tasks and projects.
+//
+// Creators, assignees and commenters of a task and the owner of a project
+// watch it without asking. Watchers are notified of the activity of what
+// they watch and listed in webhook payloads.
+package watchers
+
+import (
+	"strings"
+
+	"github.com/Anwarjondev/task-management-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Target types of a watch.
+const (
+	Task    = "task"
+	Project = "project"
+)
+
+// Watch subscribes a user to a task or project. Watching something twice
+// is not an error; an empty userID is ignored.
+func Watch(tx *gorm.DB, userID, targetType, targetID, projectID string) error {
+	if userID == "" {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Watch{
+		UserID:     userID,
+		TargetType: targetType,
+		TargetID:   targetID,
+		ProjectID:  projectID,
+	}).Error
+}
+
+// Unwatch ends the subscription of a user, if there is one.
+func Unwatch(tx *gorm.DB, userID, targetType, targetID string) error {
+	return tx.Where("user_id = ? and target_type = ? and target_id = ?", userID, targetType, targetID).
+		Delete(&models.Watch{}).Error
+}
+
+// Forget removes every watch of a target, for when it is deleted.
+func Forget(tx *gorm.DB, targetType, targetID string) error {
+	return tx.Where("target_type = ? and target_id = ?", targetType, targetID).Delete(&models.Watch{}).Error
+}
+
+// Of returns the users watching what event is about: the task for events
+// ordered by a task key, which covers its subtasks, comments, attachments
+// and work logs, and the project for the other events of a project.
+func Of(tx *gorm.DB, event models.OutboxEvent) ([]string, error) {
+	targetType, targetID := Task, ""
+	if id, ok := strings.CutPrefix(event.Key, Task+":"); ok {
+		targetID = id
+	} else if event.ProjectID != nil {
+		targetType, targetID = Project, *event.ProjectID
+	} else {
+		return nil, nil
+	}
+	var users []string
+	err := tx.Model(&models.Watch{}).
+		Where("target_type = ? and target_id = ?", targetType, targetID).
+		Order("user_id").
+		Pluck("user_id", &users).Error
+	return users, err
+}
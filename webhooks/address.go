@@ -0,0 +1,108 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for a webhook URL whose host is, or
+// resolves to, a loopback, private, link-local or otherwise non-public
+// address. Deliveries must not reach the server's own network, such as a
+// cloud metadata service at 169.254.169.254.
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// client sends deliveries. Its dialer checks the address it actually
+// connects to, so a host that resolved to a public address when the
+// webhook was saved and to a private one now is still refused, and so is
+// a redirect to a private address.
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: controlDial,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// CheckURL resolves the host of a webhook URL and returns
+// ErrForbiddenAddress when any of its addresses is not public.
+func CheckURL(ctx context.Context, rawURL string) error {
+	if allowPrivate() {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", u.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// controlDial refuses connections to non-public addresses. It runs after
+// name resolution, on the address being dialed.
+func controlDial(network, address string, _ syscall.RawConn) error {
+	if allowPrivate() {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// publicAddr reports whether addr is a globally routable unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedPrefixes are special-purpose ranges that IsGlobalUnicast and
+// IsPrivate let through.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT, RFC 6598
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, may map to private IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+}
+
+// allowPrivate lets webhooks reach private addresses when
+// WEBHOOK_ALLOW_PRIVATE_URLS is true, for development against a local
+// receiver.
+func allowPrivate() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_URLS") == "true"
+}
@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+		"64:ff9b::a00:1":   false,
+	}
+	for addr, want := range tests {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckURLRejectsLocalHosts(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/hook",
+		"http://localhost/hook",
+	} {
+		if err := CheckURL(context.Background(), rawURL); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("CheckURL(%s) = %v, want ErrForbiddenAddress", rawURL, err)
+		}
+	}
+}
+
+func TestClientRefusesToDialLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the loopback server")
+	}))
+	defer server.Close()
+
+	_, err := client.Post(server.URL, "application/json", nil)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Post to loopback = %v, want ErrForbiddenAddress", err)
+	}
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_URLS", "true")
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	resp, err := client.Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Post with WEBHOOK_ALLOW_PRIVATE_URLS = %v", err)
+	}
+	resp.Body.Close()
+}
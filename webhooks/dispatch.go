@@ -0,0 +1,188 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxResponseBody is how much of a response is kept in the delivery log.
+const maxResponseBody = 2048
+
+// claimLease is how long a claimed delivery is hidden from other
+// dispatchers. It outlasts a send, so a delivery is only picked up again
+// when the dispatcher that claimed it died before recording the result.
+const claimLease = 2 * time.Minute
+
+// Start runs the dispatcher every interval until ctx is cancelled.
+func Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := RunOnce(time.Now().UTC()); err != nil {
+			log.Println("Webhook dispatcher failed:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce attempts every delivery that is due at now. A delivery is
+// claimed in a short transaction that takes its row lock with SKIP LOCKED
+// and pushes next_attempt_at past claimLease, so several replicas never
+// send the same attempt twice. The request is sent with no transaction
+// open and its result is recorded in a second one.
+func RunOnce(now time.Time) error {
+	for {
+		delivery, err := claimNext(now)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var hook models.Webhook
+		err = db.DB.First(&hook, "id = ?", delivery.WebhookID).Error
+		if err != nil {
+			return err
+		}
+		result := send(&hook, &delivery)
+		err = db.DB.Transaction(func(tx *gorm.DB) error {
+			return recordResult(tx, delivery.ID, result, now)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// claimNext takes the next due delivery of an active webhook and counts
+// the attempt about to be made.
+func claimNext(now time.Time) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{
+			Strength: "UPDATE",
+			Table:    clause.Table{Name: "webhook_delivery"},
+			Options:  "SKIP LOCKED",
+		}).
+			Joins("join webhook on webhook.id = webhook_delivery.webhook_id").
+			Where("webhook_delivery.status = ? and webhook_delivery.next_attempt_at <= ? and webhook.active", "pending", now).
+			Order("webhook_delivery.next_attempt_at").
+			First(&delivery).Error
+		if err != nil {
+			return err
+		}
+		lease := time.Now().UTC().Add(claimLease)
+		delivery.Attempts++
+		delivery.NextAttemptAt = &lease
+		return tx.Model(&delivery).Updates(map[string]any{
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": lease,
+		}).Error
+	})
+	return delivery, err
+}
+
+// attempt is the outcome of sending a delivery once.
+type attempt struct {
+	status   int
+	body     string
+	duration time.Duration
+	err      error
+}
+
+func (a attempt) succeeded() bool {
+	return a.err == nil && a.status >= 200 && a.status < 300
+}
+
+// recordResult stores the outcome of an attempt on the delivery and its
+// webhook, and schedules a retry or switches the webhook off on failure.
+func recordResult(tx *gorm.DB, deliveryID string, result attempt, now time.Time) error {
+	var delivery models.WebhookDelivery
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&delivery, "id = ?", deliveryID).Error
+	if err != nil {
+		return err
+	}
+	var hook models.Webhook
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&hook, "id = ?", delivery.WebhookID).Error
+	if err != nil {
+		return err
+	}
+
+	delivery.ResponseStatus = result.status
+	delivery.ResponseBody = result.body
+	delivery.DurationMs = int(result.duration.Milliseconds())
+	delivery.Error = ""
+	if result.err != nil {
+		delivery.Error = result.err.Error()
+	}
+
+	hookUpdates := map[string]any{}
+	if result.succeeded() {
+		delivered := time.Now().UTC()
+		delivery.Status = "succeeded"
+		delivery.DeliveredAt = &delivered
+		delivery.NextAttemptAt = nil
+		hookUpdates["consecutive_failures"] = 0
+	} else {
+		if delivery.Attempts >= MaxAttempts {
+			delivery.Status = "failed"
+			delivery.NextAttemptAt = nil
+		} else {
+			next := now.Add(backoff(delivery.Attempts))
+			delivery.NextAttemptAt = &next
+		}
+		hookUpdates["consecutive_failures"] = hook.ConsecutiveFailures + 1
+		if hook.ConsecutiveFailures+1 >= DisableAfter {
+			log.Printf("Disabling webhook %s after %d failed deliveries", hook.ID, DisableAfter)
+			hookUpdates["active"] = false
+			hookUpdates["disabled_at"] = now
+		}
+	}
+	err = tx.Save(&delivery).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&hook).Updates(hookUpdates).Error
+}
+
+// send posts the delivery's payload and returns the response status and
+// the start of its body.
+func send(hook *models.Webhook, delivery *models.WebhookDelivery) attempt {
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return attempt{err: err}
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "task-management-api-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(hook.Secret, timestamp, payload))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		return attempt{duration: duration, err: err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return attempt{status: resp.StatusCode, body: string(body), duration: duration}
+}
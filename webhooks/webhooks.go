@@ -0,0 +1,150 @@
+// Package webhooks delivers project events to subscribed URLs.
+//
+// Publish, registered as a sink of the events outbox, records one pending
+// delivery per subscribed webhook; the dispatcher started by Start sends
+// them. Every request carries
+//
+//	X-Webhook-Event:     the event name, e.g. task.created
+//	X-Webhook-Delivery:  the delivery id
+//	X-Webhook-Timestamp: unix seconds when the request was signed
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// keyed with the webhook's secret. A delivery answered with anything but
+// 2xx is retried with exponential backoff; a webhook failing DisableAfter
+// attempts in a row is switched off. Deliveries only go to public
+// addresses; see CheckURL.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Anwarjondev/task-management-api/db"
+	"github.com/Anwarjondev/task-management-api/models"
+	"github.com/Anwarjondev/task-management-api/watchers"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxAttempts is how often a delivery is tried before it fails.
+	MaxAttempts = 8
+	// DisableAfter is the number of failed attempts in a row, across
+	// deliveries, that switches a webhook off.
+	DisableAfter = 20
+	// BaseBackoff is the wait after the first failed attempt; it doubles
+	// with every further attempt.
+	BaseBackoff = 30 * time.Second
+)
+
+// Envelope is the JSON body of a delivery. ID is the id of the event, the
+// same for every webhook and redelivery. Watchers are the ids of the users
+// watching the task or project of the event when it was published, so
+// receivers can mention them.
+type Envelope struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	ProjectID string    `json:"project_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Watchers  []string  `json:"watchers,omitempty"`
+	Data      any       `json:"data"`
+}
+
+// Publish is the events sink of webhooks. It queues a delivery of a
+// published event for every active webhook of the event's project that
+// subscribed to it. An event published again is not queued twice.
+func Publish(event models.OutboxEvent) error {
+	if event.ProjectID == nil {
+		return nil
+	}
+	watching, err := watchers.Of(db.DB, event)
+	if err != nil {
+		return err
+	}
+	return Enqueue(db.DB, Envelope{
+		ID:        event.ID,
+		Event:     event.Type,
+		ProjectID: *event.ProjectID,
+		CreatedAt: event.CreatedAt.UTC(),
+		Watchers:  watching,
+		Data:      json.RawMessage(event.Payload),
+	})
+}
+
+// Enqueue records a pending delivery of envelope for every active webhook
+// of its project subscribed to its event that has not got one yet.
+func Enqueue(tx *gorm.DB, envelope Envelope) error {
+	var hooks []models.Webhook
+	err := tx.Where("project_id = ? and active", envelope.ProjectID).
+		Where("not exists (select 1 from webhook_delivery where webhook_delivery.webhook_id = webhook.id and webhook_delivery.event_id = ?)", envelope.ID).
+		Find(&hooks).Error
+	if err != nil {
+		return err
+	}
+	hooks = slices.DeleteFunc(hooks, func(h models.Webhook) bool {
+		return !slices.Contains(h.Events, envelope.Event)
+	})
+	if len(hooks) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	deliveries := make([]models.WebhookDelivery, len(hooks))
+	for i, hook := range hooks {
+		deliveries[i] = models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			EventID:       envelope.ID,
+			Event:         envelope.Event,
+			Payload:       string(payload),
+			Status:        "pending",
+			NextAttemptAt: &now,
+		}
+	}
+	return tx.Create(&deliveries).Error
+}
+
+// Redeliver queues a new delivery with the event and payload of an
+// earlier one.
+func Redeliver(tx *gorm.DB, delivery *models.WebhookDelivery) (models.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	redelivery := models.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		EventID:       delivery.EventID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        "pending",
+		NextAttemptAt: &now,
+	}
+	err := tx.Create(&redelivery).Error
+	return redelivery, err
+}
+
+// Sign returns the X-Webhook-Signature value for a payload sent at
+// timestamp. Receivers recompute it with their copy of the secret.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func backoff(attempts int) time.Duration {
+	return BaseBackoff << (attempts - 1)
+}